@@ -0,0 +1,508 @@
+package blogpb
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/andreasatle/grpc-go-course/validate"
+)
+
+// The limits of the fields of blogs, comments and authors.
+const (
+	MaxTitleLength       = 200
+	MaxContentLength     = 100000
+	MaxTags              = 20
+	MaxTagLength         = 50
+	MaxCommentLength     = 10000
+	MaxAuthorIDLength    = 64
+	MaxDisplayNameLength = 100
+	MaxBioLength         = 2000
+	MaxURLLength         = 2000
+	MaxQueryLength       = 200
+	MaxSlugLength        = 100
+	MaxAttachments       = 50
+	MaxFilenameLength    = 255
+	MaxReactionLength    = 32
+	// MaxChunkSize is the largest chunk of an attachment in an UploadAttachmentRequest.
+	MaxChunkSize = 1 << 20
+)
+
+var (
+	objectIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+	authorIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	slugPattern     = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	sha256Pattern   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	// reactionPattern matches words like "like", and emoji with their modifiers and joiners
+	reactionPattern = regexp.MustCompile(`^(?:[a-z][a-z_-]*|[\p{So}\p{Sk}\p{Mn}\x{200D}\x{FE0F}]+)$`)
+	// contentTypePattern matches media types like "image/png", without parameters
+	contentTypePattern = regexp.MustCompile(`^[a-z]+/[a-z0-9][a-z0-9.+-]*$`)
+)
+
+// checkObjectID checks an id of a blog or comment, which is optional unless required is set.
+func checkObjectID(v *validate.Violations, field, id string, required bool) {
+	if id == "" {
+		if required {
+			v.Add(field, "is required")
+		}
+		return
+	}
+	v.Match(field, id, objectIDPattern, "24 hexadecimal digits")
+}
+
+// checkAuthorID checks the id of an author, which is optional unless required is set.
+func checkAuthorID(v *validate.Violations, field, id string, required bool) {
+	if id == "" {
+		if required {
+			v.Add(field, "is required")
+		}
+		return
+	}
+	if v.MaxLength(field, id, MaxAuthorIDLength) {
+		v.Match(field, id, authorIDPattern, "letters, digits, '.', '_' and '-'")
+	}
+}
+
+// checkSlug checks a slug of a blog, which is optional unless required is set.
+func checkSlug(v *validate.Violations, field, slug string, required bool) {
+	if slug == "" {
+		if required {
+			v.Add(field, "is required")
+		}
+		return
+	}
+	if v.MaxLength(field, slug, MaxSlugLength) {
+		v.Match(field, slug, slugPattern, "lower case letters and digits joined by single dashes")
+	}
+}
+
+func checkTitle(v *validate.Violations, field, title string) {
+	if v.Required(field, title) {
+		v.MaxLength(field, title, MaxTitleLength)
+	}
+}
+
+func checkTags(v *validate.Violations, field string, tags []string) {
+	if len(tags) > MaxTags {
+		v.Add(field, "must have at most %d tags, not %d", MaxTags, len(tags))
+		return
+	}
+	for i, tag := range tags {
+		v.MaxLength(fmt.Sprintf("%s[%d]", field, i), tag, MaxTagLength)
+	}
+}
+
+func checkAttachmentIDs(v *validate.Violations, field string, ids []string) {
+	if len(ids) > MaxAttachments {
+		v.Add(field, "must have at most %d attachments, not %d", MaxAttachments, len(ids))
+		return
+	}
+	for i, id := range ids {
+		checkObjectID(v, fmt.Sprintf("%s[%d]", field, i), id, true)
+	}
+}
+
+// checkBlog checks the content of a blog, that is its title, content, tags, and author, if set.
+func checkBlog(v *validate.Violations, blog *Blog) {
+	if blog == nil {
+		v.Add("blog", "is required")
+		return
+	}
+	checkAuthorID(v, "blog.author_id", blog.GetAuthorId(), false)
+	checkTitle(v, "blog.title", blog.GetTitle())
+	v.MaxLength("blog.content", blog.GetContent(), MaxContentLength)
+	checkTags(v, "blog.tags", blog.GetTags())
+	checkAttachmentIDs(v, "blog.attachment_ids", blog.GetAttachmentIds())
+}
+
+// checkPublication checks the status and publish time that a new blog asks for.
+func checkPublication(v *validate.Violations, blog *Blog) {
+	switch blog.GetStatus() {
+	case Blog_UNKNOWN, Blog_DRAFT, Blog_PUBLISHED:
+	case Blog_SCHEDULED:
+		if blog.GetPublishTime() == nil {
+			v.Add("blog.publish_time", "is required for scheduled blogs")
+		}
+	case Blog_ARCHIVED:
+		v.Add("blog.status", "cannot be ARCHIVED for a new blog")
+	default:
+		v.Add("blog.status", "is not a known status: %v", blog.GetStatus())
+	}
+	if t := blog.GetPublishTime(); t != nil && t.CheckValid() != nil {
+		v.Add("blog.publish_time", "is not a valid timestamp")
+	}
+}
+
+func checkPageSize(v *validate.Violations, pageSize int32) {
+	v.Min("page_size", int64(pageSize), 0)
+}
+
+func checkExpectedVersion(v *validate.Violations, version int64) {
+	v.Min("expected_version", version, 0)
+}
+
+func checkVersion(v *validate.Violations, version int64) {
+	v.Min("version", version, 1)
+}
+
+// Validate checks the fields of the request.
+func (r *CreateBlogRequest) Validate() error {
+	var v validate.Violations
+	checkBlog(&v, r.GetBlog())
+	checkPublication(&v, r.GetBlog())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *BulkCreateBlogsRequest) Validate() error {
+	var v validate.Violations
+	checkBlog(&v, r.GetBlog())
+	checkPublication(&v, r.GetBlog())
+	return v.Err()
+}
+
+// Validate checks the fields of the request. Only the ids are checked, since
+// backups may hold items from before the other limits.
+func (r *ImportBlogsRequest) Validate() error {
+	var v validate.Violations
+	switch data := r.GetData().(type) {
+	case *ImportBlogsRequest_Blog:
+		checkObjectID(&v, "blog.id", data.Blog.GetId(), false)
+		checkAuthorID(&v, "blog.author_id", data.Blog.GetAuthorId(), true)
+		checkAttachmentIDs(&v, "blog.attachment_ids", data.Blog.GetAttachmentIds())
+		checkSlug(&v, "blog.slug", data.Blog.GetSlug(), false)
+		for i, slug := range data.Blog.GetOldSlugs() {
+			checkSlug(&v, fmt.Sprintf("blog.old_slugs[%d]", i), slug, true)
+		}
+	case *ImportBlogsRequest_Author:
+		checkAuthorID(&v, "author.id", data.Author.GetId(), true)
+	case *ImportBlogsRequest_Revision:
+		checkObjectID(&v, "revision.blog_id", data.Revision.GetBlogId(), true)
+		checkAuthorID(&v, "revision.author_id", data.Revision.GetAuthorId(), true)
+		v.Min("revision.version", data.Revision.GetVersion(), 1)
+	case *ImportBlogsRequest_Comment:
+		checkObjectID(&v, "comment.id", data.Comment.GetId(), true)
+		checkObjectID(&v, "comment.blog_id", data.Comment.GetBlogId(), true)
+		checkObjectID(&v, "comment.parent_id", data.Comment.GetParentId(), false)
+		checkAuthorID(&v, "comment.author_id", data.Comment.GetAuthorId(), true)
+	default:
+		v.Add("data", "is required")
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ReadBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *UploadAttachmentRequest) Validate() error {
+	var v validate.Violations
+	switch data := r.GetData().(type) {
+	case *UploadAttachmentRequest_Info:
+		info := data.Info
+		if v.Required("info.content_type", info.GetContentType()) {
+			v.Match("info.content_type", info.GetContentType(), contentTypePattern, "a media type like image/png")
+		}
+		v.MaxLength("info.filename", info.GetFilename(), MaxFilenameLength)
+		v.Min("info.size", info.GetSize(), 1)
+		if v.Required("info.sha256", info.GetSha256()) {
+			v.Match("info.sha256", info.GetSha256(), sha256Pattern, "64 lower case hexadecimal digits")
+		}
+	case *UploadAttachmentRequest_Chunk:
+		if len(data.Chunk) > MaxChunkSize {
+			v.Add("chunk", "must be at most %d bytes, not %d", MaxChunkSize, len(data.Chunk))
+		}
+	default:
+		v.Add("data", "is required")
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *DownloadAttachmentRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "attachment_id", r.GetAttachmentId(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *GetBlogBySlugRequest) Validate() error {
+	var v validate.Violations
+	checkSlug(&v, "slug", r.GetSlug(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *RenderBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *UpdateBlogRequest) Validate() error {
+	var v validate.Violations
+	checkBlog(&v, r.GetBlog())
+	if r.GetBlog() != nil {
+		checkObjectID(&v, "blog.id", r.GetBlog().GetId(), true)
+		if r.GetBlog().GetAuthorId() == "" {
+			v.Add("blog.author_id", "is required")
+		}
+	}
+	checkExpectedVersion(&v, r.GetExpectedVersion())
+	return v.Err()
+}
+
+// Validate checks the fields of the request, and the fields of the blog in its update mask.
+func (r *PatchBlogRequest) Validate() error {
+	var v validate.Violations
+	blog := r.GetBlog()
+	checkObjectID(&v, "blog.id", blog.GetId(), true)
+	paths := r.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		v.Add("update_mask.paths", "is required")
+	}
+	for i, path := range paths {
+		switch path {
+		case "author_id":
+			checkAuthorID(&v, "blog.author_id", blog.GetAuthorId(), true)
+		case "title":
+			checkTitle(&v, "blog.title", blog.GetTitle())
+		case "content":
+			v.MaxLength("blog.content", blog.GetContent(), MaxContentLength)
+		case "tags":
+			checkTags(&v, "blog.tags", blog.GetTags())
+		case "attachment_ids":
+			checkAttachmentIDs(&v, "blog.attachment_ids", blog.GetAttachmentIds())
+		default:
+			v.Add(fmt.Sprintf("update_mask.paths[%d]", i), "%q is not a field that can be patched", path)
+		}
+	}
+	checkExpectedVersion(&v, r.GetExpectedVersion())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *DeleteBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	checkExpectedVersion(&v, r.GetExpectedVersion())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *PublishBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	if t := r.GetPublishTime(); t != nil && t.CheckValid() != nil {
+		v.Add("publish_time", "is not a valid timestamp")
+	}
+	checkExpectedVersion(&v, r.GetExpectedVersion())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *UnpublishBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	checkExpectedVersion(&v, r.GetExpectedVersion())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *RestoreBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ListBlogRevisionsRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *GetBlogRevisionRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	checkVersion(&v, r.GetVersion())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *RevertBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	checkVersion(&v, r.GetVersion())
+	checkExpectedVersion(&v, r.GetExpectedVersion())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ListBlogRequest) Validate() error {
+	var v validate.Violations
+	checkPageSize(&v, r.GetPageSize())
+	checkAuthorID(&v, "author_id", r.GetAuthorId(), false)
+	v.MaxLength("title_prefix", r.GetTitlePrefix(), MaxTitleLength)
+	v.MaxLength("title_contains", r.GetTitleContains(), MaxTitleLength)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ListDeletedBlogsRequest) Validate() error {
+	var v validate.Violations
+	checkPageSize(&v, r.GetPageSize())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ReactToBlogRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	if v.Required("reaction", r.GetReaction()) && v.MaxLength("reaction", r.GetReaction(), MaxReactionLength) {
+		v.Match("reaction", r.GetReaction(), reactionPattern, "a lower case word or an emoji")
+	}
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *RecordViewRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ListTopBlogsRequest) Validate() error {
+	var v validate.Violations
+	if _, ok := ListTopBlogsRequest_Metric_name[int32(r.GetMetric())]; !ok {
+		v.Add("metric", "is not a known metric: %v", r.GetMetric())
+	}
+	start, end := r.GetStartTime(), r.GetEndTime()
+	if start != nil && start.CheckValid() != nil {
+		v.Add("start_time", "is not a valid timestamp")
+	}
+	if end != nil && end.CheckValid() != nil {
+		v.Add("end_time", "is not a valid timestamp")
+	}
+	if start != nil && end != nil && !start.AsTime().Before(end.AsTime()) {
+		v.Add("end_time", "must be after start_time")
+	}
+	v.Min("limit", int64(r.GetLimit()), 0)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ListBlogsByTagRequest) Validate() error {
+	var v validate.Violations
+	if v.Required("tag", r.GetTag()) {
+		v.MaxLength("tag", r.GetTag(), MaxTagLength)
+	}
+	checkPageSize(&v, r.GetPageSize())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *SearchBlogsRequest) Validate() error {
+	var v validate.Violations
+	if v.Required("query", r.GetQuery()) {
+		v.MaxLength("query", r.GetQuery(), MaxQueryLength)
+	}
+	v.Min("limit", int64(r.GetLimit()), 0)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *WatchBlogsRequest) Validate() error {
+	var v validate.Violations
+	checkAuthorID(&v, "author_id", r.GetAuthorId(), false)
+	checkObjectID(&v, "blog_id", r.GetBlogId(), false)
+	return v.Err()
+}
+
+func checkCommentContent(v *validate.Violations, content string) {
+	if v.Required("comment.content", content) {
+		v.MaxLength("comment.content", content, MaxCommentLength)
+	}
+}
+
+// Validate checks the fields of the request.
+func (r *CreateCommentRequest) Validate() error {
+	var v validate.Violations
+	comment := r.GetComment()
+	if comment == nil {
+		v.Add("comment", "is required")
+		return v.Err()
+	}
+	checkObjectID(&v, "comment.blog_id", comment.GetBlogId(), true)
+	checkObjectID(&v, "comment.parent_id", comment.GetParentId(), false)
+	checkAuthorID(&v, "comment.author_id", comment.GetAuthorId(), false)
+	checkCommentContent(&v, comment.GetContent())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *UpdateCommentRequest) Validate() error {
+	var v validate.Violations
+	comment := r.GetComment()
+	if comment == nil {
+		v.Add("comment", "is required")
+		return v.Err()
+	}
+	checkObjectID(&v, "comment.id", comment.GetId(), true)
+	checkCommentContent(&v, comment.GetContent())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *DeleteCommentRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "comment_id", r.GetCommentId(), true)
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *ListCommentsRequest) Validate() error {
+	var v validate.Violations
+	checkObjectID(&v, "blog_id", r.GetBlogId(), true)
+	checkObjectID(&v, "parent_id", r.GetParentId(), false)
+	return v.Err()
+}
+
+// checkAuthor checks the fields of an author profile.
+func checkAuthor(v *validate.Violations, author *Author) {
+	if author == nil {
+		v.Add("author", "is required")
+		return
+	}
+	checkAuthorID(v, "author.id", author.GetId(), true)
+	v.MaxLength("author.display_name", author.GetDisplayName(), MaxDisplayNameLength)
+	v.MaxLength("author.bio", author.GetBio(), MaxBioLength)
+	v.MaxLength("author.avatar_url", author.GetAvatarUrl(), MaxURLLength)
+}
+
+// Validate checks the fields of the request.
+func (r *CreateAuthorRequest) Validate() error {
+	var v validate.Violations
+	checkAuthor(&v, r.GetAuthor())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *UpdateAuthorRequest) Validate() error {
+	var v validate.Violations
+	checkAuthor(&v, r.GetAuthor())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *GetAuthorRequest) Validate() error {
+	var v validate.Violations
+	checkAuthorID(&v, "author_id", r.GetAuthorId(), true)
+	return v.Err()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+)
+
+// uploadChunkSize is the size of the chunks that uploadAttachment sends.
+const uploadChunkSize = 256 << 10
+
+// uploadAttachment uploads the file at path in chunks, and prints the id of the attachment.
+func uploadAttachment(ctx context.Context, c blogpb.BlogServiceClient, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Error opening attachment: %v\n", err)
+	}
+	defer f.Close()
+
+	// Read the file once for its checksum and content type, and once more to send it
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		log.Fatalf("Error reading attachment: %v\n", err)
+	}
+	info := &blogpb.Attachment{
+		ContentType: contentType(f, path),
+		Filename:    filepath.Base(path),
+		Size:        size,
+		Sha256:      hex.EncodeToString(h.Sum(nil)),
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Fatalf("Error reading attachment: %v\n", err)
+	}
+
+	stream, err := c.UploadAttachment(ctx)
+	if err != nil {
+		log.Fatalf("Error uploading attachment to server: %v\n", err)
+	}
+	if err := stream.Send(&blogpb.UploadAttachmentRequest{Data: &blogpb.UploadAttachmentRequest_Info{Info: info}}); err != nil {
+		log.Fatalf("Error sending upload stream to server: %v\n", err)
+	}
+	buf := make([]byte, uploadChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			// io.EOF means that the server has answered, maybe because it has the file already
+			if err := stream.Send(&blogpb.UploadAttachmentRequest{Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: buf[:n]}}); err == io.EOF {
+				break
+			} else if err != nil {
+				log.Fatalf("Error sending upload stream to server: %v\n", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error reading attachment: %v\n", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatalf("Error receiving upload result from server: %v\n", err)
+	}
+	log.Printf("Attachment has been uploaded: %v\n", res.GetAttachment())
+}
+
+// contentType returns the media type of the file f at path, from its extension or else its content.
+func contentType(f *os.File, path string) string {
+	typ := mime.TypeByExtension(filepath.Ext(path))
+	if typ == "" {
+		head := make([]byte, 512)
+		n, _ := f.ReadAt(head, 0)
+		typ = http.DetectContentType(head[:n])
+	}
+	// The server takes the media type without parameters like charset
+	typ, _, _ = strings.Cut(typ, ";")
+	return strings.TrimSpace(typ)
+}
+
+// downloadAttachment writes the content of the attachment with the given id to the file at path.
+func downloadAttachment(ctx context.Context, c blogpb.BlogServiceClient, id, path string) {
+	stream, err := c.DownloadAttachment(ctx, &blogpb.DownloadAttachmentRequest{AttachmentId: id})
+	if err != nil {
+		log.Fatalf("Error downloading attachment from server: %v\n", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Error creating attachment file: %v\n", err)
+	}
+	defer f.Close()
+
+	var info *blogpb.Attachment
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error receiving download stream from server: %v\n", err)
+		}
+		if res.GetInfo() != nil {
+			info = res.GetInfo()
+			continue
+		}
+		if _, err := f.Write(res.GetChunk()); err != nil {
+			log.Fatalf("Error writing attachment file: %v\n", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Error writing attachment file: %v\n", err)
+	}
+	log.Printf("Downloaded %v (%v, %v bytes) to %v\n", info.GetFilename(), info.GetContentType(), info.GetSize(), path)
+}
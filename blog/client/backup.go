@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxItemSize is the largest message size of an item in a protobuf file, the default message size limit of gRPC.
+const maxItemSize = 4 << 20
+
+// exportBlogs writes all authors, blogs, also those in the trash, revisions and comments to the file at path.
+// Every item is an ExportBlogsResponse, which ImportBlogsRequest reads the same way.
+func exportBlogs(ctx context.Context, c blogpb.BlogServiceClient, path, format string) {
+	write, err := itemWriter(format)
+	if err != nil {
+		log.Fatalf("Error exporting blogs: %v\n", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Error creating export file: %v\n", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	stream, err := c.ExportBlogs(ctx, &blogpb.ExportBlogsRequest{IncludeDeleted: true})
+	if err != nil {
+		log.Fatalf("Error exporting blogs from server: %v\n", err)
+	}
+	n := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error receiving export stream from server: %v\n", err)
+		}
+		if err := write(w, res); err != nil {
+			log.Fatalf("Error writing export file: %v\n", err)
+		}
+		n++
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("Error writing export file: %v\n", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Error writing export file: %v\n", err)
+	}
+	log.Printf("Exported %v items to %v\n", n, path)
+}
+
+// importBlogs sends all items in the file at path to the server.
+func importBlogs(ctx context.Context, c blogpb.BlogServiceClient, path, format string) {
+	read, err := itemReader(format)
+	if err != nil {
+		log.Fatalf("Error importing blogs: %v\n", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Error opening import file: %v\n", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	stream, err := c.ImportBlogs(ctx)
+	if err != nil {
+		log.Fatalf("Error importing blogs to server: %v\n", err)
+	}
+	for {
+		req := &blogpb.ImportBlogsRequest{}
+		err := read(r, req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error reading import file: %v\n", err)
+		}
+		if err := stream.Send(req); err != nil {
+			log.Fatalf("Error sending import stream to server: %v\n", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatalf("Error receiving import result from server: %v\n", err)
+	}
+	for _, failure := range res.GetFailures() {
+		log.Printf("Item %v (blog %q) was not imported: %v\n", failure.GetIndex(), failure.GetBlogId(), failure.GetError())
+	}
+	log.Printf("Imported %v items from %v, %v failed\n", res.GetImported(), path, res.GetFailed())
+}
+
+// itemWriter returns a function writing one item in the given file format:
+// "jsonl" for JSON Lines, or "proto" for varint length-delimited protobuf messages.
+func itemWriter(format string) (func(w *bufio.Writer, item proto.Message) error, error) {
+	switch format {
+	case "jsonl":
+		return func(w *bufio.Writer, item proto.Message) error {
+			b, err := protojson.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			return w.WriteByte('\n')
+		}, nil
+	case "proto":
+		return func(w *bufio.Writer, item proto.Message) error {
+			b, err := proto.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(b)))); err != nil {
+				return err
+			}
+			_, err = w.Write(b)
+			return err
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format: %q", format)
+}
+
+// itemReader returns a function reading one item in the given file format into item, see itemWriter.
+// It returns io.EOF at the end of the file.
+func itemReader(format string) (func(r *bufio.Reader, item proto.Message) error, error) {
+	switch format {
+	case "jsonl":
+		return func(r *bufio.Reader, item proto.Message) error {
+			for {
+				line, err := r.ReadBytes('\n')
+				if len(line) == 0 || (len(line) == 1 && line[0] == '\n') {
+					if err != nil {
+						return err
+					}
+					// Skip empty lines
+					continue
+				}
+				if err != nil && err != io.EOF {
+					return err
+				}
+				return protojson.Unmarshal(line, item)
+			}
+		}, nil
+	case "proto":
+		return func(r *bufio.Reader, item proto.Message) error {
+			size, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			if size > maxItemSize {
+				return fmt.Errorf("item of %v bytes is too large", size)
+			}
+			b := make([]byte, size)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			return proto.Unmarshal(b, item)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format: %q", format)
+}
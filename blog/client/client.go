@@ -2,19 +2,36 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"time"
 
+	"github.com/andreasatle/grpc-go-course/blog/auth"
 	"github.com/andreasatle/grpc-go-course/blog/blogpb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func main() {
 	// Setup the logging, for if program crashes
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	authSecret := flag.String("auth-secret", "", "secret shared with the server, for signing the tokens of the authors")
+	format := flag.String("format", "jsonl", "file format of export and import: jsonl or proto")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [export|import <file> | upload <file> | download <id> <file>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	signer := auth.NewSigner([]byte(*authSecret))
+
 	fmt.Println("Hello, I'm a client!")
 
 	tls := false
@@ -39,57 +56,158 @@ func main() {
 
 	c := blogpb.NewBlogServiceClient(connection)
 	fmt.Printf("Client created: %v\n", c)
-	tst(c, "Andreas", "First", "first")
-	tst(c, "Anton", "Second", "second")
+
+	// Backups need the admin role, attachments are uploaded and downloaded by the first author
+	switch flag.Arg(0) {
+	case "":
+	case "export":
+		exportBlogs(authContext(signer, "admin", auth.AdminRole), c, flag.Arg(1), *format)
+		return
+	case "import":
+		importBlogs(authContext(signer, "admin", auth.AdminRole), c, flag.Arg(1), *format)
+		return
+	case "upload":
+		uploadAttachment(authContext(signer, "Andreas"), c, flag.Arg(1))
+		return
+	case "download":
+		downloadAttachment(authContext(signer, "Andreas"), c, flag.Arg(1), flag.Arg(2))
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Blogs can only be written by authors with a profile
+	a := blogpb.NewAuthorServiceClient(connection)
+	createAuthor(authContext(signer, "Andreas"), a, "Andreas", "Andreas Atle")
+	createAuthor(authContext(signer, "Anton"), a, "Anton", "Anton")
+
+	tst(authContext(signer, "Andreas"), c, "First", "first")
+	tst(authContext(signer, "Anton"), c, "Second", "second")
+}
+
+// authContext returns a context that calls the server as the author with the given id and roles.
+func authContext(signer *auth.Signer, authorID string, roles ...string) context.Context {
+	token, err := signer.Sign(auth.Claims{Subject: authorID, Roles: roles, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		log.Fatalf("Error signing token: %v\n", err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+// createAuthor creates an author profile as the author in ctx, unless it already exists.
+func createAuthor(ctx context.Context, a blogpb.AuthorServiceClient, id, displayName string) {
+	author := &blogpb.Author{Id: id, DisplayName: displayName}
+	_, err := a.CreateAuthor(ctx, &blogpb.CreateAuthorRequest{Author: author})
+	if status.Code(err) == codes.AlreadyExists {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error creating author on server: %v\n", err)
+	}
+	log.Printf("Author has been created: %v\n", id)
 }
 
-func tst(c blogpb.BlogServiceClient, author, title, content string) {
+// tst runs through the life cycle of a blog, written by the author in ctx.
+func tst(ctx context.Context, c blogpb.BlogServiceClient, title, content string) {
 	blog := &blogpb.Blog{
-		AuthorId: author,
-		Title:    title,
-		Content:  content,
+		Title:   title,
+		Content: content,
+		Tags:    []string{"gRPC", "Go Course"},
 	}
 
 	// Create an entry in the database on the server side
-	createRes, err := c.CreateBlog(context.Background(), &blogpb.CreateBlogRequest{Blog: blog})
+	createRes, err := c.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: blog})
 	if err != nil {
 		log.Fatalf("Error receiving data from server: %v\n", err)
 		return
 	}
 	log.Printf("Blog has been created: %v\n", createRes.Blog)
 
-	// Read the entry that just was created from the database on the server side
-	readRes, err := c.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: createRes.Blog.GetId()})
+	// Read the entry that just was created from the database on the server side,
+	// new blogs are drafts that only their author can read
+	readRes, err := c.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: createRes.Blog.GetId(), RenderContent: true})
 	if err != nil {
 		log.Fatalf("Error receiving data from server: %v", err)
 		return
 	}
 	log.Printf("Blog has been read: %v\n", readRes.Blog)
+	log.Printf("Blog has been rendered: %v\n", readRes.GetRendered().GetHtml())
 	updateBlog := &blogpb.Blog{
 		Id:       createRes.GetBlog().GetId(),
-		AuthorId: createRes.GetBlog().GetAuthorId() + "(mod)",
+		AuthorId: createRes.GetBlog().GetAuthorId(),
 		Title:    createRes.GetBlog().GetTitle() + "{mod}",
 		Content:  createRes.GetBlog().GetContent() + "[mod]",
 	}
 
 	// Update the entry that just was created from the database on the server side
-	updateRes, err := c.UpdateBlog(context.Background(), &blogpb.UpdateBlogRequest{Blog: updateBlog})
+	updateRes, err := c.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{
+		Blog:            updateBlog,
+		ExpectedVersion: createRes.GetBlog().GetVersion(),
+	})
 	if err != nil {
 		log.Fatalf("Error updating data on server: %v", err)
 		return
 	}
 	log.Printf("Blog has been updated: %v\n", updateRes.Blog)
 
-	_, err = c.DeleteBlog(context.Background(), &blogpb.DeleteBlogRequest{BlogId: createRes.GetBlog().GetId()})
+	// Patch only the title of the entry
+	patchRes, err := c.PatchBlog(ctx, &blogpb.PatchBlogRequest{
+		Blog:            &blogpb.Blog{Id: updateRes.GetBlog().GetId(), Title: title + "{patch}"},
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+		ExpectedVersion: updateRes.GetBlog().GetVersion(),
+	})
+	if err != nil {
+		log.Fatalf("Error patching data on server: %v", err)
+		return
+	}
+	log.Printf("Blog has been patched: %v\n", patchRes.Blog)
+
+	// Publish the entry, so that everybody can read it
+	publishRes, err := c.PublishBlog(ctx, &blogpb.PublishBlogRequest{BlogId: patchRes.GetBlog().GetId()})
+	if err != nil {
+		log.Fatalf("Error publishing data on server: %v", err)
+		return
+	}
+	log.Printf("Blog has been published: %v\n", publishRes.Blog)
+
+	// Read the entry by the slug in its public URL, like a reader would
+	slugRes, err := c.GetBlogBySlug(context.Background(), &blogpb.GetBlogBySlugRequest{Slug: publishRes.GetBlog().GetSlug()})
+	if err != nil {
+		log.Fatalf("Error reading data by slug on server: %v", err)
+		return
+	}
+	log.Printf("Blog has been read by slug %v: %v\n", slugRes.GetBlog().GetSlug(), slugRes.GetBlog().GetTitle())
+
+	// Count the read, and like the entry
+	viewRes, err := c.RecordView(context.Background(), &blogpb.RecordViewRequest{BlogId: slugRes.GetBlog().GetId()})
+	if err != nil {
+		log.Fatalf("Error recording view on server: %v", err)
+		return
+	}
+	reactRes, err := c.ReactToBlog(ctx, &blogpb.ReactToBlogRequest{BlogId: slugRes.GetBlog().GetId(), Reaction: "like"})
+	if err != nil {
+		log.Fatalf("Error reacting to data on server: %v", err)
+		return
+	}
+	log.Printf("Blog has %v views and reactions %v\n", viewRes.GetViewCount(), reactRes.GetBlog().GetReactionCounts())
+
+	_, err = c.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: createRes.GetBlog().GetId()})
 	if err != nil {
 		log.Fatalf("Error deleting data on server: %v", err)
 		return
 	}
 	log.Printf("Blog has been deleted\n")
 
-	stream, err := c.ListBlog(context.Background(), &blogpb.ListBlogRequest{})
+	listBlogs(c, 10)
+	searchBlogs(c, title)
+}
+
+// searchBlogs prints the blogs matching query, best match first.
+func searchBlogs(c blogpb.BlogServiceClient, query string) {
+	stream, err := c.SearchBlogs(context.Background(), &blogpb.SearchBlogsRequest{Query: query})
 	if err != nil {
-		log.Fatalf("Error listing blogs from server: %v\n", err)
+		log.Fatalf("Error searching blogs on server: %v\n", err)
 		return
 	}
 	for {
@@ -98,9 +216,40 @@ func tst(c blogpb.BlogServiceClient, author, title, content string) {
 			break
 		}
 		if err != nil {
-			log.Fatalf("Error receiving list stream from server: %v\n", err)
+			log.Fatalf("Error receiving search stream from server: %v\n", err)
+			return
+		}
+		log.Printf("Search hit (%.2f): %v\n", res.GetScore(), res.GetTitleSnippet())
+	}
+}
+
+// listBlogs lists all blogs, one page of pageSize blogs at the time.
+func listBlogs(c blogpb.BlogServiceClient, pageSize int32) {
+	pageToken := ""
+	for {
+		req := &blogpb.ListBlogRequest{PageSize: pageSize, PageToken: pageToken}
+		stream, err := c.ListBlog(context.Background(), req)
+		if err != nil {
+			log.Fatalf("Error listing blogs from server: %v\n", err)
+			return
+		}
+		pageToken = ""
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Fatalf("Error receiving list stream from server: %v\n", err)
+				return
+			}
+			log.Println("List blog:", res.GetBlog())
+			if res.GetNextPageToken() != "" {
+				pageToken = res.GetNextPageToken()
+			}
+		}
+		if pageToken == "" {
 			return
 		}
-		log.Println("List blog:", res.GetBlog())
 	}
 }
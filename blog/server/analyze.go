@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// token is a normalized term together with its position in the analyzed text.
+type token struct {
+	term       string
+	start, end int // byte offsets of the original word
+}
+
+// stopWords are common English words that are not indexed.
+var stopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true, "has": true, "have": true,
+	"i": true, "if": true, "in": true, "into": true, "is": true, "it": true, "its": true,
+	"no": true, "not": true, "of": true, "on": true, "or": true, "so": true, "such": true,
+	"that": true, "the": true, "their": true, "then": true, "there": true, "these": true,
+	"they": true, "this": true, "to": true, "was": true, "we": true, "were": true,
+	"what": true, "when": true, "which": true, "who": true, "will": true, "with": true,
+	"you": true,
+}
+
+// analyze splits text into words, lower cases them, drops stop words and
+// stems the rest. The same analysis is used for documents and queries.
+func analyze(text string) []token {
+	var tokens []token
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		word := strings.ToLower(text[start:end])
+		if !stopWords[word] {
+			tokens = append(tokens, token{term: stem(word), start: start, end: end})
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return tokens
+}
+
+// stem strips common English suffixes, so that e.g. "posts", "posting" and
+// "posted" all end up as "post". It is deliberately simple and only strips
+// a suffix if at least three characters remain.
+func stem(word string) string {
+	strip := func(suffix, replacement string) (string, bool) {
+		if !strings.HasSuffix(word, suffix) || len(word)-len(suffix) < 3 {
+			return word, false
+		}
+		return word[:len(word)-len(suffix)] + replacement, true
+	}
+	for _, rule := range [][2]string{
+		{"sses", "ss"}, {"ies", "y"},
+	} {
+		if w, ok := strip(rule[0], rule[1]); ok {
+			return w
+		}
+	}
+	for _, suffix := range []string{"ing", "edly", "ed"} {
+		if w, ok := strip(suffix, ""); ok {
+			// "running" -> "runn" -> "run"
+			if n := len(w); w[n-1] == w[n-2] && !strings.ContainsRune("aeiouslz", rune(w[n-1])) {
+				w = w[:n-1]
+			}
+			return w
+		}
+	}
+	if w, ok := strip("ly", ""); ok {
+		return w
+	}
+	if !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") {
+		if w, ok := strip("s", ""); ok {
+			return w
+		}
+	}
+	return word
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// attachmentChunkSize is the size of the chunks that DownloadAttachment sends.
+	attachmentChunkSize = 64 << 10
+	// defaultMaxAttachmentSize is the size of the largest attachment, unless configured otherwise.
+	defaultMaxAttachmentSize = 10 << 20
+	// defaultAttachmentQuota is the total size of the attachments of an author, unless configured otherwise.
+	defaultAttachmentQuota = 100 << 20
+)
+
+// UploadAttachment is an RPC for the Blog Service to upload a file in chunks, for blogs to reference
+func (s *server) UploadAttachment(stream blogpb.BlogService_UploadAttachmentServer) error {
+	log.Println("Invoked RPC UploadAttachment...")
+	ctx := stream.Context()
+	owner := callerID(ctx)
+	if owner == "" {
+		return status.Errorf(codes.Unauthenticated, "Missing token")
+	}
+
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return status.Errorf(codes.InvalidArgument, "Missing attachment info")
+	}
+	if err != nil {
+		log.Printf("Error receiving stream from client: %v\n", err)
+		return err
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	info := req.GetInfo()
+	if info == nil {
+		return status.Errorf(codes.InvalidArgument, "The first message must have the attachment info")
+	}
+	if info.GetSize() > s.maxAttachmentSize {
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Attachment of %v bytes is larger than %v bytes", info.GetSize(), s.maxAttachmentSize))
+	}
+
+	// The same file uploaded again is the same attachment, the server answers before the chunks are sent
+	existing, err := s.store.FindAttachment(ctx, owner, info.GetSha256(), info.GetContentType())
+	switch err {
+	case nil:
+		return stream.SendAndClose(&blogpb.UploadAttachmentResponse{Attachment: attachmentToPb(existing)})
+	case errAttachmentNotFound:
+	default:
+		return storeError(err, attachments)
+	}
+	// Concurrent uploads of an author may exceed the quota by a file each, which we accept
+	usage, err := s.store.AttachmentUsage(ctx, owner)
+	if err != nil {
+		return storeError(err, attachments)
+	}
+	if usage+info.GetSize() > s.attachmentQuota {
+		return quotaExceeded(owner, usage, info.GetSize(), s.attachmentQuota)
+	}
+
+	w, err := s.blobs.Create()
+	if err != nil {
+		return status.Errorf(codes.Internal, fmt.Sprintf("Cannot store attachment: %v", err))
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			w.Abort()
+		}
+	}()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Error receiving stream from client: %v\n", err)
+			return err
+		}
+		if err := req.Validate(); err != nil {
+			return err
+		}
+		chunk := req.GetChunk()
+		if req.GetInfo() != nil {
+			return status.Errorf(codes.InvalidArgument, "Only the first message may have the attachment info")
+		}
+		if w.Size+int64(len(chunk)) > info.GetSize() {
+			return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Attachment is larger than its size of %v bytes", info.GetSize()))
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return status.Errorf(codes.Internal, fmt.Sprintf("Cannot store attachment: %v", err))
+		}
+	}
+	if w.Size != info.GetSize() {
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Received %v bytes of an attachment of %v bytes", w.Size, info.GetSize()))
+	}
+	if sum := w.Sum(); sum != info.GetSha256() {
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Checksum of the content is %v, not %v", sum, info.GetSha256()))
+	}
+	sum, err := w.Commit()
+	committed = true
+	if err != nil {
+		return status.Errorf(codes.Internal, fmt.Sprintf("Cannot store attachment: %v", err))
+	}
+
+	data, err := s.store.CreateAttachment(ctx, &attachmentItem{
+		OwnerID:     owner,
+		ContentType: info.GetContentType(),
+		Filename:    info.GetFilename(),
+		Size:        w.Size,
+		SHA256:      sum,
+		CreateTime:  s.now(),
+	})
+	if err != nil {
+		return storeError(err, attachments)
+	}
+	return stream.SendAndClose(&blogpb.UploadAttachmentResponse{Attachment: attachmentToPb(data)})
+}
+
+// quotaExceeded returns a codes.ResourceExhausted error, with a QuotaFailure detail, for an
+// upload of size bytes by owner, who has usage bytes of attachments already.
+func quotaExceeded(owner string, usage, size, quota int64) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("Attachment quota of %v bytes exceeded, %v bytes are in use", quota, usage))
+	violation := &errdetails.QuotaFailure_Violation{
+		Subject:     "authors/" + owner,
+		Description: fmt.Sprintf("Uploading %v bytes with %v of %v bytes in use", size, usage, quota),
+	}
+	if withInfo, err := st.WithDetails(&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{violation}}); err == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
+
+// DownloadAttachment is an RPC for the Blog Service to download the content of an attachment in chunks
+func (s *server) DownloadAttachment(req *blogpb.DownloadAttachmentRequest, stream blogpb.BlogService_DownloadAttachmentServer) error {
+	log.Println("Invoked RPC DownloadAttachment...")
+	oid, err := primitive.ObjectIDFromHex(req.GetAttachmentId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	ctx := stream.Context()
+	data, err := s.store.ReadAttachment(ctx, oid)
+	if err != nil {
+		return storeError(err, attachmentResource(req.GetAttachmentId()))
+	}
+	visible, err := s.canDownload(ctx, data)
+	if err != nil {
+		return storeError(err, attachmentResource(req.GetAttachmentId()))
+	}
+	if !visible {
+		// Like hidden blogs, attachments that the caller may not see do not exist for them
+		return storeError(errAttachmentNotFound, attachmentResource(req.GetAttachmentId()))
+	}
+	f, err := s.blobs.Open(data.SHA256)
+	if err == errBlobNotFound {
+		return status.Errorf(codes.DataLoss, fmt.Sprintf("Content of attachment is missing: %v", req.GetAttachmentId()))
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, fmt.Sprintf("Cannot read attachment: %v", err))
+	}
+	defer f.Close()
+
+	if err := stream.Send(&blogpb.DownloadAttachmentResponse{Data: &blogpb.DownloadAttachmentResponse_Info{Info: attachmentToPb(data)}}); err != nil {
+		return err
+	}
+	buf := make([]byte, attachmentChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			chunk := &blogpb.DownloadAttachmentResponse_Chunk{Chunk: buf[:n]}
+			if err := stream.Send(&blogpb.DownloadAttachmentResponse{Data: chunk}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, fmt.Sprintf("Cannot read attachment: %v", err))
+		}
+	}
+}
+
+// attachmentIDs returns the attachment ids of blog, without duplicates.
+// The validation has checked that they are ids.
+func attachmentIDs(blog *blogpb.Blog) []primitive.ObjectID {
+	var ids []primitive.ObjectID
+	seen := map[primitive.ObjectID]bool{}
+	for _, hex := range blog.GetAttachmentIds() {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// checkAttachments checks that the attachments of data that are not in before exist, and were
+// uploaded by the author of the blog. Admins may attach the attachments of any author.
+func (s *server) checkAttachments(ctx context.Context, data *blogItem, before []primitive.ObjectID) error {
+	had := map[primitive.ObjectID]bool{}
+	for _, id := range before {
+		had[id] = true
+	}
+	for _, id := range data.AttachmentIDs {
+		if had[id] {
+			continue
+		}
+		a, err := s.store.ReadAttachment(ctx, id)
+		if err != nil {
+			return storeError(err, attachmentResource(id.Hex()))
+		}
+		if a.OwnerID != data.AuthorID && !isAdmin(ctx) {
+			return status.Errorf(codes.PermissionDenied, fmt.Sprintf("Attachment belongs to another author: %v", id.Hex()))
+		}
+	}
+	return nil
+}
+
+// canDownload reports whether the caller may download the attachment data. Owners and admins may download
+// all attachments, everybody else only those of the blogs that they can see.
+func (s *server) canDownload(ctx context.Context, data *attachmentItem) (bool, error) {
+	caller := callerID(ctx)
+	if caller != "" && caller == data.OwnerID || isAdmin(ctx) {
+		return true, nil
+	}
+	q := listQuery{Filter: blogFilter{AttachmentID: data.ID}, Limit: 1}
+	onlyVisible(&q.Filter, caller)
+	found := false
+	err := s.store.List(ctx, q, func(*blogItem) error {
+		found = true
+		return nil
+	})
+	return found, err
+}
+
+// hasAttachment reports whether item references the attachment id.
+func hasAttachment(item *blogItem, id primitive.ObjectID) bool {
+	for _, a := range item.AttachmentIDs {
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
+
+func attachmentToPb(data *attachmentItem) *blogpb.Attachment {
+	return &blogpb.Attachment{
+		Id:          data.ID.Hex(),
+		OwnerId:     data.OwnerID,
+		ContentType: data.ContentType,
+		Filename:    data.Filename,
+		Size:        data.Size,
+		Sha256:      data.SHA256,
+		CreateTime:  timestampOrNil(data.CreateTime),
+	}
+}
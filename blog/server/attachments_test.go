@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// image is the content of an attachment that takes several chunks to download.
+var image = bytes.Repeat([]byte("0123456789abcdef"), attachmentChunkSize/8+3)
+
+// attachmentInfo returns the info of an upload of content.
+func attachmentInfo(content []byte) *blogpb.Attachment {
+	sum := sha256.Sum256(content)
+	return &blogpb.Attachment{ContentType: "image/png", Filename: "image.png", Size: int64(len(content)), Sha256: hex.EncodeToString(sum[:])}
+}
+
+// upload uploads content with info, in chunks of chunkSize bytes.
+func upload(ctx context.Context, c blogpb.BlogServiceClient, info *blogpb.Attachment, content []byte, chunkSize int) (*blogpb.Attachment, error) {
+	stream, err := c.UploadAttachment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&blogpb.UploadAttachmentRequest{Data: &blogpb.UploadAttachmentRequest_Info{Info: info}}); err != nil {
+		return nil, err
+	}
+	for len(content) > 0 {
+		n := chunkSize
+		if n > len(content) {
+			n = len(content)
+		}
+		err := stream.Send(&blogpb.UploadAttachmentRequest{Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: content[:n]}})
+		if err == io.EOF {
+			// The server answered early, CloseAndRecv has the answer
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		content = content[n:]
+	}
+	res, err := stream.CloseAndRecv()
+	return res.GetAttachment(), err
+}
+
+// download returns the info and the content of an attachment.
+func download(ctx context.Context, c blogpb.BlogServiceClient, id string) (*blogpb.Attachment, []byte, error) {
+	stream, err := c.DownloadAttachment(ctx, &blogpb.DownloadAttachmentRequest{AttachmentId: id})
+	if err != nil {
+		return nil, nil, err
+	}
+	var info *blogpb.Attachment
+	var content []byte
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return info, content, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if res.GetInfo() != nil {
+			info = res.GetInfo()
+		}
+		content = append(content, res.GetChunk()...)
+	}
+}
+
+func TestUploadAttachment(t *testing.T) {
+	uploadAs := func(subject string, info func() *blogpb.Attachment, content []byte) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := upload(f.as(subject), f.blogs, info(), content, 1000)
+			return err
+		}
+	}
+	valid := func() *blogpb.Attachment { return attachmentInfo(image) }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: uploadAs("alice", valid, image), want: codes.OK},
+		{name: "anonymous", call: func(f *fixture) error {
+			_, err := upload(context.Background(), f.blogs, valid(), image, 1000)
+			return err
+		}, want: codes.Unauthenticated},
+		{name: "bad checksum", call: uploadAs("alice", func() *blogpb.Attachment {
+			info := valid()
+			info.Sha256 = hex.EncodeToString(make([]byte, sha256.Size))
+			return info
+		}, image), want: codes.InvalidArgument},
+		{name: "shorter than its size", call: uploadAs("alice", valid, image[:100]), want: codes.InvalidArgument},
+		{name: "longer than its size", call: uploadAs("alice", valid, append(image, 'x')), want: codes.InvalidArgument},
+		{name: "missing content type", call: uploadAs("alice", func() *blogpb.Attachment {
+			info := valid()
+			info.ContentType = ""
+			return info
+		}, image), want: codes.InvalidArgument},
+		{name: "chunk before info", call: func(f *fixture) error {
+			stream, err := f.blogs.UploadAttachment(f.as("alice"))
+			if err != nil {
+				return err
+			}
+			stream.Send(&blogpb.UploadAttachmentRequest{Data: &blogpb.UploadAttachmentRequest_Chunk{Chunk: image}})
+			_, err = stream.CloseAndRecv()
+			return err
+		}, want: codes.InvalidArgument},
+		{name: "too large", call: func(f *fixture) error {
+			f.srv.maxAttachmentSize = int64(len(image)) - 1
+			return uploadAs("alice", valid, image)(f)
+		}, want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: uploadAs("alice", valid, image), want: codes.Unavailable},
+	})
+}
+
+func TestAttachmentQuota(t *testing.T) {
+	f := newFixture(t)
+	f.srv.attachmentQuota = int64(len(image)) + 10
+	if _, err := upload(f.as("alice"), f.blogs, attachmentInfo(image), image, 1000); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	// The same file again does not count
+	if _, err := upload(f.as("alice"), f.blogs, attachmentInfo(image), image, 1000); err != nil {
+		t.Fatalf("upload of the same file: %v", err)
+	}
+	other := image[:20]
+	_, err := upload(f.as("alice"), f.blogs, attachmentInfo(other), other, 1000)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("upload over the quota: got %v, want ResourceExhausted", err)
+	}
+	var failure *errdetails.QuotaFailure
+	for _, d := range status.Convert(err).Details() {
+		if q, ok := d.(*errdetails.QuotaFailure); ok {
+			failure = q
+		}
+	}
+	if got := failure.GetViolations(); len(got) != 1 || got[0].GetSubject() != "authors/alice" {
+		t.Errorf("got quota violations %v, want one of authors/alice", got)
+	}
+	// The quota is per author
+	if _, err := upload(f.as("bob"), f.blogs, attachmentInfo(other), other, 1000); err != nil {
+		t.Errorf("upload of bob: %v", err)
+	}
+}
+
+func TestAttachmentsAreDeduplicated(t *testing.T) {
+	f := newFixture(t)
+	first, err := upload(f.as("alice"), f.blogs, attachmentInfo(image), image, 1000)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	again, err := upload(f.as("alice"), f.blogs, attachmentInfo(image), image, 1000)
+	if err != nil {
+		t.Fatalf("upload of the same file: %v", err)
+	}
+	if again.GetId() != first.GetId() {
+		t.Errorf("got attachment %v for the same file, want %v", again.GetId(), first.GetId())
+	}
+	ofBob, err := upload(f.as("bob"), f.blogs, attachmentInfo(image), image, 1000)
+	if err != nil {
+		t.Fatalf("upload of bob: %v", err)
+	}
+	if ofBob.GetId() == first.GetId() || ofBob.GetOwnerId() != "bob" {
+		t.Errorf("got attachment %v, want a new attachment of bob", ofBob)
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(f.srv.blobs.dir, "??", "*"))
+	if err != nil || len(blobs) != 1 {
+		t.Errorf("got stored files %v, want the content once", blobs)
+	}
+	temps, err := os.ReadDir(filepath.Join(f.srv.blobs.dir, "tmp"))
+	if err != nil || len(temps) != 0 {
+		t.Errorf("got temporary files %v, want none", temps)
+	}
+}
+
+func TestDownloadAttachment(t *testing.T) {
+	f := newFixture(t)
+	uploaded, err := upload(f.as("alice"), f.blogs, attachmentInfo(image), image, 777)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	info, content, err := download(f.as("alice"), f.blogs, uploaded.GetId())
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if !bytes.Equal(content, image) {
+		t.Errorf("got %v bytes, want the %v uploaded bytes", len(content), len(image))
+	}
+	if info.GetContentType() != "image/png" || info.GetFilename() != "image.png" || info.GetSize() != int64(len(image)) {
+		t.Errorf("got info %v, want the uploaded info", info)
+	}
+
+	for name, tt := range map[string]struct {
+		id   string
+		want codes.Code
+	}{
+		"missing": {missingID, codes.NotFound},
+		"bad id":  {"nope", codes.InvalidArgument},
+	} {
+		if _, _, err := download(f.as("alice"), f.blogs, tt.id); status.Code(err) != tt.want {
+			t.Errorf("%v: got %v, want %v", name, err, tt.want)
+		}
+	}
+}
+
+func TestDownloadAttachmentAccess(t *testing.T) {
+	// downloadAs uploads an attachment of alice, which the blog of alice that blog returns references,
+	// if any, and downloads it as subject, or anonymously
+	downloadAs := func(blog func(f *fixture) *blogpb.Blog, subject string, roles ...string) func(f *fixture) error {
+		return func(f *fixture) error {
+			uploaded, err := upload(f.as("alice"), f.blogs, attachmentInfo(image), image, 1000)
+			if err != nil {
+				return err
+			}
+			if b := blog(f); b != nil {
+				req := &blogpb.PatchBlogRequest{
+					Blog:       &blogpb.Blog{Id: b.GetId(), AttachmentIds: []string{uploaded.GetId()}},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"attachment_ids"}},
+				}
+				if _, err := f.blogs.PatchBlog(f.as("alice"), req); err != nil {
+					t.Errorf("PatchBlog: %v", err)
+				}
+			}
+			ctx := context.Background()
+			if subject != "" {
+				ctx = f.as(subject, roles...)
+			}
+			_, content, err := download(ctx, f.blogs, uploaded.GetId())
+			if err == nil && !bytes.Equal(content, image) {
+				t.Errorf("got %v bytes, want the %v uploaded bytes", len(content), len(image))
+			}
+			return err
+		}
+	}
+	none := func(f *fixture) *blogpb.Blog { return nil }
+	published := func(f *fixture) *blogpb.Blog { return f.blog }
+	draft := func(f *fixture) *blogpb.Blog { return f.draft }
+	runRPCTests(t, []rpcTest{
+		{name: "owner", call: downloadAs(none, "alice"), want: codes.OK},
+		{name: "admin", call: downloadAs(none, "carol", "admin"), want: codes.OK},
+		{name: "anonymous", call: downloadAs(none, ""), want: codes.NotFound},
+		{name: "other user", call: downloadAs(none, "bob"), want: codes.NotFound},
+		{name: "anonymous of a published blog", call: downloadAs(published, ""), want: codes.OK},
+		{name: "other user of a draft", call: downloadAs(draft, "bob"), want: codes.NotFound},
+	})
+}
+
+func TestBlogAttachments(t *testing.T) {
+	create := func(subject string, ids func(f *fixture) []string) func(f *fixture) error {
+		return func(f *fixture) error {
+			uploaded, err := upload(f.as("alice"), f.blogs, attachmentInfo(image), image, 1000)
+			if err != nil {
+				return err
+			}
+			blog := &blogpb.Blog{Title: "With image", AttachmentIds: append(ids(f), uploaded.GetId())}
+			res, err := f.blogs.CreateBlog(f.as(subject), &blogpb.CreateBlogRequest{Blog: blog})
+			if err == nil && len(res.GetBlog().GetAttachmentIds()) != len(blog.AttachmentIds) {
+				t.Errorf("got attachments %v, want %v", res.GetBlog().GetAttachmentIds(), blog.AttachmentIds)
+			}
+			return err
+		}
+	}
+	none := func(f *fixture) []string { return nil }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: create("alice", none), want: codes.OK},
+		{name: "of another author", call: create("bob", none), want: codes.PermissionDenied},
+		{name: "missing", call: create("alice", func(f *fixture) []string { return []string{missingID} }), want: codes.NotFound},
+		{name: "bad id", call: create("alice", func(f *fixture) []string { return []string{"nope"} }), want: codes.InvalidArgument},
+	})
+}
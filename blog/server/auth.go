@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authMethods are the RPCs that can only be called with a valid token.
+var authMethods = map[string]bool{
+	"/blog.BlogService/CreateBlog":       true,
+	"/blog.BlogService/UpdateBlog":       true,
+	"/blog.BlogService/PatchBlog":        true,
+	"/blog.BlogService/DeleteBlog":       true,
+	"/blog.BlogService/RestoreBlog":      true,
+	"/blog.BlogService/ListDeletedBlogs": true,
+	"/blog.BlogService/PublishBlog":      true,
+	"/blog.BlogService/UnpublishBlog":    true,
+	"/blog.BlogService/ReactToBlog":      true,
+	"/blog.BlogService/RevertBlog":       true,
+	"/blog.BlogService/BulkCreateBlogs":  true,
+	"/blog.BlogService/ExportBlogs":      true,
+	"/blog.BlogService/ImportBlogs":      true,
+	"/blog.BlogService/UploadAttachment": true,
+
+	"/blog.CommentService/CreateComment": true,
+	"/blog.CommentService/UpdateComment": true,
+	"/blog.CommentService/DeleteComment": true,
+
+	"/blog.AuthorService/CreateAuthor": true,
+	"/blog.AuthorService/UpdateAuthor": true,
+}
+
+// authenticator verifies the bearer token in the "authorization" metadata of the
+// requests, and passes the claims of the caller on to the handlers in the context.
+type authenticator struct {
+	signer *auth.Signer
+	now    func() time.Time
+}
+
+// newAuthenticator returns an authenticator for tokens signed with secret.
+func newAuthenticator(secret []byte) *authenticator {
+	return &authenticator{signer: auth.NewSigner(secret), now: time.Now}
+}
+
+// authenticate returns ctx with the claims of the caller. A request without a token
+// is passed on anonymously, unless method is one of the authMethods.
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			token = v[0]
+		}
+	}
+	if token == "" {
+		if authMethods[method] {
+			return nil, status.Errorf(codes.Unauthenticated, "Missing token")
+		}
+		return ctx, nil
+	}
+
+	if !strings.HasPrefix(token, "Bearer ") {
+		return nil, status.Errorf(codes.Unauthenticated, "Authorization is not a bearer token")
+	}
+	claims, err := a.signer.Verify(strings.TrimPrefix(token, "Bearer "), a.now())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, fmt.Sprintf("Invalid token: %v", err))
+	}
+	return auth.NewContext(ctx, claims), nil
+}
+
+// Unary is the interceptor for unary RPCs.
+func (a *authenticator) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// Stream is the interceptor for streaming RPCs.
+func (a *authenticator) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+}
+
+// authStream is a server stream with the claims of the caller in its context.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// callerID returns the id of the authenticated caller in ctx, or "" for anonymous callers.
+func callerID(ctx context.Context) string {
+	claims, _ := auth.FromContext(ctx)
+	return claims.Subject
+}
+
+// isAdmin reports whether the caller in ctx has the admin role.
+func isAdmin(ctx context.Context) bool {
+	claims, _ := auth.FromContext(ctx)
+	return claims.IsAdmin()
+}
+
+// requireAdmin returns a gRPC status error unless the caller in ctx is an admin.
+func requireAdmin(ctx context.Context) error {
+	claims, ok := auth.FromContext(ctx)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "Missing token")
+	}
+	if !claims.IsAdmin() {
+		return status.Errorf(codes.PermissionDenied, fmt.Sprintf("%q is not an admin", claims.Subject))
+	}
+	return nil
+}
+
+// checkOwner returns a gRPC status error unless the caller in ctx wrote data, or is an admin.
+func checkOwner(ctx context.Context, data *blogItem) error {
+	return requireOwner(ctx, data.AuthorID, "blog")
+}
+
+// requireOwner returns a gRPC status error unless the caller in ctx is ownerID, or an admin.
+// kind is what the caller wants to modify, for the error message.
+func requireOwner(ctx context.Context, ownerID, kind string) error {
+	claims, ok := auth.FromContext(ctx)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "Missing token")
+	}
+	if ownerID != claims.Subject && !claims.IsAdmin() {
+		return status.Errorf(codes.PermissionDenied, fmt.Sprintf("%q cannot modify a %s of %q", claims.Subject, kind, ownerID))
+	}
+	return nil
+}
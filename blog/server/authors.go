@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authorServer implements the AuthorServiceServer interface.
+// It shares the storage of the blog server, which checks blog authors against the profiles.
+type authorServer struct {
+	*server
+}
+
+// CreateAuthor is an RPC for the Author Service to create the profile of the caller, or of anyone for admins
+func (s *authorServer) CreateAuthor(ctx context.Context, req *blogpb.CreateAuthorRequest) (*blogpb.CreateAuthorResponse, error) {
+	log.Println("Invoked RPC CreateAuthor...")
+	author := req.GetAuthor()
+	if author.GetId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Empty author ID")
+	}
+	// Authors create their own profile, admins anyone's
+	if err := requireOwner(ctx, author.GetId(), "profile"); err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	data, err := s.store.CreateAuthor(ctx, &authorItem{
+		ID:          author.GetId(),
+		DisplayName: author.GetDisplayName(),
+		Bio:         author.GetBio(),
+		AvatarURL:   author.GetAvatarUrl(),
+		CreateTime:  now,
+		UpdateTime:  now,
+	})
+	if err != nil {
+		return nil, storeError(err, authorResource(author.GetId()))
+	}
+	return &blogpb.CreateAuthorResponse{Author: authorToPb(data)}, nil
+}
+
+// GetAuthor is an RPC for the Author Service to read an author profile
+func (s *authorServer) GetAuthor(ctx context.Context, req *blogpb.GetAuthorRequest) (*blogpb.GetAuthorResponse, error) {
+	log.Println("Invoked RPC GetAuthor...")
+	data, err := s.store.ReadAuthor(ctx, req.GetAuthorId())
+	if err != nil {
+		return nil, storeError(err, authorResource(req.GetAuthorId()))
+	}
+	return &blogpb.GetAuthorResponse{Author: authorToPb(data)}, nil
+}
+
+// UpdateAuthor is an RPC for the Author Service to replace the profile of the caller, or of anyone for admins
+func (s *authorServer) UpdateAuthor(ctx context.Context, req *blogpb.UpdateAuthorRequest) (*blogpb.UpdateAuthorResponse, error) {
+	log.Println("Invoked RPC UpdateAuthor...")
+	author := req.GetAuthor()
+	if err := requireOwner(ctx, author.GetId(), "profile"); err != nil {
+		return nil, err
+	}
+	data, err := s.store.ReadAuthor(ctx, author.GetId())
+	if err != nil {
+		return nil, storeError(err, authorResource(author.GetId()))
+	}
+
+	data.DisplayName = author.GetDisplayName()
+	data.Bio = author.GetBio()
+	data.AvatarURL = author.GetAvatarUrl()
+	data.UpdateTime = s.now()
+	data, err = s.store.UpdateAuthor(ctx, data)
+	if err != nil {
+		return nil, storeError(err, authorResource(author.GetId()))
+	}
+	return &blogpb.UpdateAuthorResponse{Author: authorToPb(data)}, nil
+}
+
+// ListAuthors is an RPC for the Author Service to list all author profiles
+func (s *authorServer) ListAuthors(req *blogpb.ListAuthorsRequest, stream blogpb.AuthorService_ListAuthorsServer) error {
+	log.Println("Invoked RPC ListAuthors...")
+	err := s.store.ListAuthors(stream.Context(), func(data *authorItem) error {
+		return stream.Send(&blogpb.ListAuthorsResponse{Author: authorToPb(data)})
+	})
+	if err != nil {
+		return storeError(err, authors)
+	}
+	return nil
+}
+
+// checkAuthor returns a gRPC status error unless there is a profile for the author with the given id.
+func (s *server) checkAuthor(ctx context.Context, authorID string) error {
+	_, err := s.store.ReadAuthor(ctx, authorID)
+	if err == errAuthorNotFound {
+		return status.Errorf(codes.FailedPrecondition, fmt.Sprintf("Unknown author: %q", authorID))
+	}
+	return storeError(err, authorResource(authorID))
+}
+
+func authorToPb(data *authorItem) *blogpb.Author {
+	return &blogpb.Author{
+		Id:          data.ID,
+		DisplayName: data.DisplayName,
+		Bio:         data.Bio,
+		AvatarUrl:   data.AvatarURL,
+		CreateTime:  timestampOrNil(data.CreateTime),
+		UpdateTime:  timestampOrNil(data.UpdateTime),
+	}
+}
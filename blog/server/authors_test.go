@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/auth"
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCreateAuthor(t *testing.T) {
+	create := func(ctx func(f *fixture) context.Context, id string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.authors.CreateAuthor(ctx(f), &blogpb.CreateAuthorRequest{Author: &blogpb.Author{Id: id}})
+			return err
+		}
+	}
+	carol := create(asUser("carol"), "carol")
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: carol, want: codes.OK},
+		{name: "admin", call: create(asUser("admin", auth.AdminRole), "carol"), want: codes.OK},
+		{name: "another user", call: create(asUser("bob"), "carol"), want: codes.PermissionDenied},
+		{name: "anonymous", call: create(anonymous, "carol"), want: codes.Unauthenticated},
+		{name: "no id", call: create(asUser("carol"), ""), want: codes.InvalidArgument},
+		{name: "exists", call: create(asUser("alice"), "alice"), want: codes.AlreadyExists},
+		{name: "store unavailable", storeErr: errDown, call: carol, want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: carol, want: codes.Internal},
+	})
+}
+
+func TestGetAuthor(t *testing.T) {
+	get := func(id string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.authors.GetAuthor(context.Background(), &blogpb.GetAuthorRequest{AuthorId: id})
+			return err
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: get("alice"), want: codes.OK},
+		{name: "missing", call: get("carol"), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: get("alice"), want: codes.Unavailable},
+	})
+}
+
+func TestUpdateAuthor(t *testing.T) {
+	update := func(ctx func(f *fixture) context.Context, id string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.authors.UpdateAuthor(ctx(f), &blogpb.UpdateAuthorRequest{Author: &blogpb.Author{Id: id, DisplayName: "Alice"}})
+			return err
+		}
+	}
+	alice := update(asUser("alice"), "alice")
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: alice, want: codes.OK},
+		{name: "admin", call: update(asUser("admin", auth.AdminRole), "alice"), want: codes.OK},
+		{name: "another user", call: update(asUser("bob"), "alice"), want: codes.PermissionDenied},
+		{name: "anonymous", call: update(anonymous, "alice"), want: codes.Unauthenticated},
+		{name: "missing", call: update(asUser("carol"), "carol"), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: alice, want: codes.Unavailable},
+	})
+}
+
+func TestListAuthors(t *testing.T) {
+	list := func(f *fixture) error {
+		stream, err := f.authors.ListAuthors(context.Background(), &blogpb.ListAuthorsRequest{})
+		return drain(stream, err, &blogpb.ListAuthorsResponse{})
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: list, want: codes.OK},
+		{name: "store unavailable", storeErr: errDown, call: list, want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: list, want: codes.Internal},
+	})
+}
+
+func TestReadBlogIncludeAuthor(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	profile := &blogpb.Author{Id: "alice", DisplayName: "Alice", Bio: "Writes about gRPC"}
+	if _, err := f.authors.UpdateAuthor(f.as("alice"), &blogpb.UpdateAuthorRequest{Author: profile}); err != nil {
+		t.Fatalf("UpdateAuthor: %v", err)
+	}
+
+	res, err := f.blogs.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: f.blog.GetId(), IncludeAuthor: true})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if got := res.GetAuthor(); got.GetDisplayName() != "Alice" || got.GetBio() != profile.GetBio() {
+		t.Errorf("got author %v, want the profile of alice", got)
+	}
+	res, err = f.blogs.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if res.GetAuthor() != nil {
+		t.Errorf("got author %v without asking for it", res.GetAuthor())
+	}
+}
+
+func TestUpdateBlogWithoutAuthorProfile(t *testing.T) {
+	f := newFixture(t)
+	// A blog written before the author profiles were introduced
+	data, err := f.store.Create(context.Background(), &blogItem{AuthorID: "carol", Title: "Legacy"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	req := &blogpb.UpdateBlogRequest{Blog: &blogpb.Blog{Id: data.ID.Hex(), AuthorId: "carol", Title: "Still editable"}}
+	if _, err := f.blogs.UpdateBlog(f.as("carol"), req); err != nil {
+		t.Errorf("UpdateBlog of a blog without an author profile: %v", err)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ExportBlogs is an RPC for the Blog Service to stream all authors, blogs, revisions and comments with all of their fields, for a backup
+func (s *server) ExportBlogs(req *blogpb.ExportBlogsRequest, stream blogpb.BlogService_ExportBlogsServer) error {
+	log.Println("Invoked RPC ExportBlogs...")
+	ctx := stream.Context()
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	var sendErr error
+	send := func(res *blogpb.ExportBlogsResponse) error {
+		sendErr = stream.Send(res)
+		return sendErr
+	}
+	err := s.store.ListAuthors(ctx, func(data *authorItem) error {
+		return send(&blogpb.ExportBlogsResponse{Data: &blogpb.ExportBlogsResponse_Author{Author: authorToPb(data)}})
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return storeError(err, authors)
+	}
+
+	queries := []listQuery{{}}
+	if req.GetIncludeDeleted() {
+		queries = append(queries, listQuery{Filter: blogFilter{Trashed: true}})
+	}
+	// The revisions and comments follow all blogs, so that the store is not listed from within a listing
+	var ids []primitive.ObjectID
+	for _, q := range queries {
+		err := s.store.List(ctx, q, func(data *blogItem) error {
+			ids = append(ids, data.ID)
+			return send(&blogpb.ExportBlogsResponse{Data: &blogpb.ExportBlogsResponse_Blog{Blog: dataToBlogPb(data)}})
+		})
+		if sendErr != nil {
+			return sendErr
+		}
+		if err != nil {
+			return storeError(err, blogs)
+		}
+	}
+
+	for _, id := range ids {
+		err := s.store.ListRevisions(ctx, id, func(rev *revisionItem) error {
+			return send(&blogpb.ExportBlogsResponse{Data: &blogpb.ExportBlogsResponse_Revision{Revision: revisionToPb(rev)}})
+		})
+		if sendErr != nil {
+			return sendErr
+		}
+		if err != nil {
+			return storeError(err, blogResource(id.Hex()))
+		}
+
+		replies, err := s.commentThreads(ctx, id)
+		if err != nil {
+			return storeError(err, blogResource(id.Hex()))
+		}
+		// In thread order, so that every reply follows the comment it replies to
+		var walk func(parent primitive.ObjectID) error
+		walk = func(parent primitive.ObjectID) error {
+			for _, c := range replies[parent] {
+				if err := send(&blogpb.ExportBlogsResponse{Data: &blogpb.ExportBlogsResponse_Comment{Comment: commentToPb(c, 0)}}); err != nil {
+					return err
+				}
+				if err := walk(c.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := walk(primitive.NilObjectID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importState is what ImportBlogs knows about the items of the request stream imported so far.
+type importState struct {
+	// blogs has the ids of the imported blogs, which revisions and comments may refer to
+	blogs map[primitive.ObjectID]bool
+	// comments has the blog of every imported comment, which replies may refer to
+	comments map[primitive.ObjectID]primitive.ObjectID
+	// unrecorded has the version of every imported blog, until a revision of it is imported
+	unrecorded map[primitive.ObjectID]int64
+}
+
+// ImportBlogs is an RPC for the Blog Service to insert authors, blogs, revisions and comments from a backup as they are.
+// The blogs are inserted in batches.
+func (s *server) ImportBlogs(stream blogpb.BlogService_ImportBlogsServer) error {
+	log.Println("Invoked RPC ImportBlogs...")
+	ctx := stream.Context()
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	res := &blogpb.ImportBlogsResponse{}
+	fail := func(index int64, blogID string, err error) {
+		res.Failures = append(res.Failures, &blogpb.ImportBlogsResponse_Failure{Index: index, BlogId: blogID, Error: err.Error()})
+		res.Failed++
+	}
+	st := &importState{
+		blogs:      map[primitive.ObjectID]bool{},
+		comments:   map[primitive.ObjectID]primitive.ObjectID{},
+		unrecorded: map[primitive.ObjectID]int64{},
+	}
+	var batch []*blogItem
+	var indexes []int64
+	// The slugs of the blogs in the batch, which the store does not know yet
+	batchSlugs := map[string]bool{}
+	flush := func() {
+		errs := s.store.Insert(ctx, batch)
+		for i, data := range batch {
+			if errs[i] != nil {
+				fail(indexes[i], data.ID.Hex(), errs[i])
+				continue
+			}
+			res.Imported++
+			st.blogs[data.ID] = true
+			st.unrecorded[data.ID] = data.Version
+			if !data.trashed() {
+				s.index.Add(data)
+				s.events.Publish(blogpb.WatchBlogsResponse_CREATED, nil, data, s.now())
+			}
+		}
+		batch, indexes = batch[:0], indexes[:0]
+		batchSlugs = map[string]bool{}
+	}
+
+	for index := int64(0); ; index++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Error receiving stream from client: %v\n", err)
+			return err
+		}
+		if err := req.Validate(); err != nil {
+			fail(index, importedBlogID(req), errors.New(status.Convert(err).Message()))
+			continue
+		}
+		if req.GetBlog() == nil {
+			// The revisions and comments refer to the blogs sent before them
+			if len(batch) > 0 {
+				flush()
+			}
+			if err := s.importItem(ctx, req, st); err != nil {
+				fail(index, importedBlogID(req), err)
+				continue
+			}
+			res.Imported++
+			continue
+		}
+
+		data, err := importedBlog(req.GetBlog(), s.now())
+		if err != nil {
+			fail(index, req.GetBlog().GetId(), err)
+			continue
+		}
+		if err := s.importedAttachments(ctx, data); err != nil {
+			fail(index, req.GetBlog().GetId(), err)
+			continue
+		}
+		// Backups from before the slugs get new ones
+		if len(data.Slugs) == 0 {
+			slug, err := s.uniqueSlug(ctx, data.ID, data.Title, batchSlugs)
+			if err != nil {
+				fail(index, req.GetBlog().GetId(), err)
+				continue
+			}
+			data.Slugs = []string{slug}
+		}
+		if !reserveSlugs(batchSlugs, data.Slugs) {
+			fail(index, req.GetBlog().GetId(), errSlugExists)
+			continue
+		}
+		batch = append(batch, data)
+		indexes = append(indexes, index)
+		if len(batch) == bulkBatchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+
+	// The blogs of backups from before the revisions get one for their current version
+	for id, version := range st.unrecorded {
+		data, err := s.store.Read(ctx, id)
+		if err != nil {
+			log.Printf("Error recording revision %v of blog %v: %v\n", version, id.Hex(), err)
+			continue
+		}
+		// Unless the blog was edited since, which recorded a revision
+		if data.Version == version {
+			s.recordRevision(ctx, data)
+		}
+	}
+	return stream.SendAndClose(res)
+}
+
+// importedBlogID returns the blog of an item in an import stream, for its failure. It is empty for authors.
+func importedBlogID(req *blogpb.ImportBlogsRequest) string {
+	switch data := req.GetData().(type) {
+	case *blogpb.ImportBlogsRequest_Blog:
+		return data.Blog.GetId()
+	case *blogpb.ImportBlogsRequest_Revision:
+		return data.Revision.GetBlogId()
+	case *blogpb.ImportBlogsRequest_Comment:
+		return data.Comment.GetBlogId()
+	}
+	return ""
+}
+
+// importItem inserts an author, revision or comment from a backup. The revisions and comments
+// must be on blogs imported before them, and replies must follow the comment they reply to.
+func (s *server) importItem(ctx context.Context, req *blogpb.ImportBlogsRequest, st *importState) error {
+	switch data := req.GetData().(type) {
+	case *blogpb.ImportBlogsRequest_Author:
+		return s.importAuthor(ctx, data.Author)
+	case *blogpb.ImportBlogsRequest_Revision:
+		return s.importRevision(ctx, data.Revision, st)
+	case *blogpb.ImportBlogsRequest_Comment:
+		return s.importComment(ctx, data.Comment, st)
+	}
+	return fmt.Errorf("unknown item")
+}
+
+func (s *server) importAuthor(ctx context.Context, author *blogpb.Author) error {
+	data := &authorItem{
+		ID:          author.GetId(),
+		DisplayName: author.GetDisplayName(),
+		Bio:         author.GetBio(),
+		AvatarURL:   author.GetAvatarUrl(),
+	}
+	var err error
+	if data.CreateTime, err = importedTime(author.GetCreateTime(), s.now()); err != nil {
+		return fmt.Errorf("invalid create_time: %v", err)
+	}
+	if data.UpdateTime, err = importedTime(author.GetUpdateTime(), data.CreateTime); err != nil {
+		return fmt.Errorf("invalid update_time: %v", err)
+	}
+	_, err = s.store.CreateAuthor(ctx, data)
+	return err
+}
+
+func (s *server) importRevision(ctx context.Context, rev *blogpb.BlogRevision, st *importState) error {
+	blogID, err := primitive.ObjectIDFromHex(rev.GetBlogId())
+	if err != nil {
+		return fmt.Errorf("cannot parse blog ID: %v", err)
+	}
+	if !st.blogs[blogID] {
+		return fmt.Errorf("blog %v was not imported", rev.GetBlogId())
+	}
+	data := &revisionItem{
+		BlogID:   blogID,
+		Version:  rev.GetVersion(),
+		AuthorID: rev.GetAuthorId(),
+		Title:    rev.GetTitle(),
+		Content:  rev.GetContent(),
+		Editor:   rev.GetEditor(),
+	}
+	if data.CreateTime, err = importedTime(rev.GetCreateTime(), s.now()); err != nil {
+		return fmt.Errorf("invalid create_time: %v", err)
+	}
+	if err := s.store.AddRevision(ctx, data); err != nil {
+		return err
+	}
+	delete(st.unrecorded, blogID)
+	return nil
+}
+
+func (s *server) importComment(ctx context.Context, comment *blogpb.Comment, st *importState) error {
+	data := &commentItem{
+		AuthorID: comment.GetAuthorId(),
+		Content:  comment.GetContent(),
+	}
+	var err error
+	if data.ID, err = primitive.ObjectIDFromHex(comment.GetId()); err != nil {
+		return fmt.Errorf("cannot parse ID: %v", err)
+	}
+	if data.BlogID, err = primitive.ObjectIDFromHex(comment.GetBlogId()); err != nil {
+		return fmt.Errorf("cannot parse blog ID: %v", err)
+	}
+	if !st.blogs[data.BlogID] {
+		return fmt.Errorf("blog %v was not imported", comment.GetBlogId())
+	}
+	if comment.GetParentId() != "" {
+		if data.ParentID, err = primitive.ObjectIDFromHex(comment.GetParentId()); err != nil {
+			return fmt.Errorf("cannot parse parent ID: %v", err)
+		}
+		if st.comments[data.ParentID] != data.BlogID {
+			return fmt.Errorf("parent comment %v was not imported on the same blog", comment.GetParentId())
+		}
+	}
+	if data.CreateTime, err = importedTime(comment.GetCreateTime(), s.now()); err != nil {
+		return fmt.Errorf("invalid create_time: %v", err)
+	}
+	if data.UpdateTime, err = importedTime(comment.GetUpdateTime(), data.CreateTime); err != nil {
+		return fmt.Errorf("invalid update_time: %v", err)
+	}
+	if err := s.store.InsertComment(ctx, data); err != nil {
+		return err
+	}
+	st.comments[data.ID] = data.BlogID
+	return nil
+}
+
+// importedBlog converts a blog from a backup to a database item.
+// A missing id, version or timestamp is filled in as if the blog was created now.
+func importedBlog(blog *blogpb.Blog, now time.Time) (*blogItem, error) {
+	if blog.GetAuthorId() == "" {
+		return nil, fmt.Errorf("missing author")
+	}
+	data := &blogItem{
+		ID:       primitive.NewObjectID(),
+		AuthorID: blog.GetAuthorId(),
+		Title:    blog.GetTitle(),
+		Content:  blog.GetContent(),
+		Version:  blog.GetVersion(),
+		Tags:     normalizeTags(blog.GetTags()),
+	}
+	if blog.GetId() != "" {
+		oid, err := primitive.ObjectIDFromHex(blog.GetId())
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse ID: %v", err)
+		}
+		data.ID = oid
+	}
+	if data.Version < 1 {
+		data.Version = 1
+	}
+	// Backups do not include the attachments, ImportBlogs drops the ones the store does not have
+	for _, hex := range blog.GetAttachmentIds() {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse attachment ID: %v", err)
+		}
+		data.AttachmentIDs = append(data.AttachmentIDs, id)
+	}
+	if blog.GetSlug() != "" {
+		data.Slugs = append(append(data.Slugs, blog.GetOldSlugs()...), blog.GetSlug())
+	}
+	// Backups from before the statuses only have public blogs
+	data.Status = statusPublished
+	if status, ok := statusFromPb[blog.GetStatus()]; ok {
+		data.Status = status
+	}
+
+	var err error
+	if data.CreateTime, err = importedTime(blog.GetCreateTime(), now); err != nil {
+		return nil, fmt.Errorf("invalid create_time: %v", err)
+	}
+	if data.UpdateTime, err = importedTime(blog.GetUpdateTime(), data.CreateTime); err != nil {
+		return nil, fmt.Errorf("invalid update_time: %v", err)
+	}
+	if data.DeleteTime, err = importedTime(blog.GetDeleteTime(), time.Time{}); err != nil {
+		return nil, fmt.Errorf("invalid delete_time: %v", err)
+	}
+	if data.PublishTime, err = importedTime(blog.GetPublishTime(), time.Time{}); err != nil {
+		return nil, fmt.Errorf("invalid publish_time: %v", err)
+	}
+	return data, nil
+}
+
+// importedAttachments removes the attachments of data that the store does not have, since the
+// server cannot serve them without their content.
+func (s *server) importedAttachments(ctx context.Context, data *blogItem) error {
+	var kept []primitive.ObjectID
+	for _, id := range data.AttachmentIDs {
+		_, err := s.store.ReadAttachment(ctx, id)
+		if err == errAttachmentNotFound {
+			log.Printf("Dropping missing attachment %v of imported blog %v\n", id.Hex(), data.ID.Hex())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		kept = append(kept, id)
+	}
+	data.AttachmentIDs = kept
+	return nil
+}
+
+// importedTime converts ts to the precision of the stores, or returns def if ts is not set.
+func importedTime(ts *timestamppb.Timestamp, def time.Time) (time.Time, error) {
+	if ts == nil {
+		return def, nil
+	}
+	if err := ts.CheckValid(); err != nil {
+		return time.Time{}, err
+	}
+	return ts.AsTime().Truncate(time.Millisecond), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"os"
+	"path/filepath"
+)
+
+// errBlobNotFound is returned by a blobStore when it has no content with the given checksum.
+var errBlobNotFound = errors.New("blob not found")
+
+// blobStore keeps the content of attachments in a directory on the local filesystem, addressed
+// by the SHA-256 checksum of the content, so that the same content is only stored once.
+// The files are written to a temporary file first, and renamed into place when complete,
+// so that readers never see partial content.
+type blobStore struct {
+	dir string
+}
+
+// newBlobStore returns a blobStore in dir, which is created if needed.
+func newBlobStore(dir string) (*blobStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+	return &blobStore{dir: dir}, nil
+}
+
+// path returns the path of the content with the given checksum, in a subdirectory
+// for its first two digits, so that no directory gets too large.
+func (b *blobStore) path(sum string) string {
+	return filepath.Join(b.dir, sum[:2], sum)
+}
+
+// Create returns a writer for new content. The content is stored by Commit, or dropped by Abort.
+func (b *blobStore) Create() (*blobWriter, error) {
+	f, err := os.CreateTemp(filepath.Join(b.dir, "tmp"), "upload-")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{store: b, f: f, hash: sha256.New()}, nil
+}
+
+// Open opens the content with the given checksum for reading.
+func (b *blobStore) Open(sum string) (*os.File, error) {
+	f, err := os.Open(b.path(sum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+// blobWriter writes new content to a blobStore, and computes its checksum and size on the way.
+type blobWriter struct {
+	store *blobStore
+	f     *os.File
+	hash  hash.Hash
+	// Size is the number of bytes written so far.
+	Size int64
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.hash.Write(p[:n])
+	w.Size += int64(n)
+	return n, err
+}
+
+// Sum returns the checksum of the content written so far, in hexadecimal.
+func (w *blobWriter) Sum() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// Commit stores the content under its checksum and returns the checksum. If the store
+// has the content already, the copy that was just written is dropped.
+func (w *blobWriter) Commit() (string, error) {
+	sum := w.Sum()
+	if err := w.f.Sync(); err != nil {
+		w.Abort()
+		return "", err
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+	path := w.store.path(sum)
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(w.f.Name())
+		return sum, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+	// Two uploads of the same content may race here, either rename leaves the same content behind
+	if err := os.Rename(w.f.Name(), path); err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+	return sum, nil
+}
+
+// Abort drops the content written so far.
+func (w *blobWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
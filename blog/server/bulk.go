@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bulkBatchSize is the number of blogs that BulkCreateBlogs writes to the store at a time.
+const bulkBatchSize = 500
+
+// BulkCreateBlogs is an RPC for the Blog Service to create many blogs, written to the database in batches
+func (s *server) BulkCreateBlogs(stream blogpb.BlogService_BulkCreateBlogsServer) error {
+	log.Println("Invoked RPC BulkCreateBlogs...")
+	ctx := stream.Context()
+	caller := callerID(ctx)
+	if caller == "" {
+		return status.Errorf(codes.Unauthenticated, "Missing token")
+	}
+
+	res := &blogpb.BulkCreateBlogsResponse{}
+	// The authors that have a profile, an archive usually has few of them
+	knownAuthors := map[string]bool{}
+	// The slugs of the blogs in the batch, which the store does not know yet
+	batchSlugs := map[string]bool{}
+	var batch []*blogItem
+	var results []*blogpb.BulkCreateBlogsResponse_Result
+	flush := func() {
+		created, errs := s.store.CreateMany(ctx, batch)
+		for i, data := range created {
+			if errs[i] != nil {
+				results[i].Error = fmt.Sprintf("Error creating blog: %v", errs[i])
+				res.Failed++
+				continue
+			}
+			results[i].BlogId = data.ID.Hex()
+			res.Created++
+			s.index.Add(data)
+			s.recordRevision(ctx, data)
+			s.events.Publish(blogpb.WatchBlogsResponse_CREATED, nil, data, data.CreateTime)
+		}
+		batch, results = batch[:0], results[:0]
+		batchSlugs = map[string]bool{}
+	}
+
+	for index := int64(0); ; index++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Error receiving stream from client: %v\n", err)
+			return err
+		}
+		result := &blogpb.BulkCreateBlogsResponse_Result{Index: index}
+		res.Results = append(res.Results, result)
+		if err := req.Validate(); err != nil {
+			result.Error = status.Convert(err).Message()
+			res.Failed++
+			continue
+		}
+
+		// Admins may import the blogs of other authors
+		blog := req.GetBlog()
+		authorID := caller
+		if isAdmin(ctx) && blog.GetAuthorId() != "" {
+			authorID = blog.GetAuthorId()
+		}
+		if !knownAuthors[authorID] {
+			if err := s.checkAuthor(ctx, authorID); err != nil {
+				result.Error = status.Convert(err).Message()
+				res.Failed++
+				continue
+			}
+			knownAuthors[authorID] = true
+		}
+
+		now := s.now()
+		data := &blogItem{
+			AuthorID:   authorID,
+			Title:      blog.GetTitle(),
+			Content:    blog.GetContent(),
+			Tags:       normalizeTags(blog.GetTags()),
+			CreateTime: now,
+			UpdateTime: now,
+		}
+		data.Status, data.PublishTime = publication(blog, now)
+		data.AttachmentIDs = attachmentIDs(blog)
+		if err := s.checkAttachments(ctx, data, nil); err != nil {
+			result.Error = status.Convert(err).Message()
+			res.Failed++
+			continue
+		}
+		slug, err := s.uniqueSlug(ctx, data.ID, data.Title, batchSlugs)
+		if err != nil {
+			result.Error = status.Convert(storeError(err, blogs)).Message()
+			res.Failed++
+			continue
+		}
+		data.Slugs = []string{slug}
+		reserveSlugs(batchSlugs, data.Slugs)
+		batch = append(batch, data)
+		results = append(results, result)
+		if len(batch) == bulkBatchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+	return stream.SendAndClose(res)
+}
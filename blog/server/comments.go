@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// commentServer implements the CommentServiceServer interface.
+// It shares the storage of the blog server, so that comments can be checked against the blogs.
+type commentServer struct {
+	*server
+}
+
+// CreateComment is an RPC for the Comment Service to comment on a blog, or reply to a comment
+func (s *commentServer) CreateComment(ctx context.Context, req *blogpb.CreateCommentRequest) (*blogpb.CreateCommentResponse, error) {
+	log.Println("Invoked RPC CreateComment...")
+	comment := req.GetComment()
+	// The author is always the caller, whatever the request says
+	authorID := callerID(ctx)
+	if authorID == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "Missing token")
+	}
+
+	blogID, err := primitive.ObjectIDFromHex(comment.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse blog ID: %v", err))
+	}
+	if err := s.checkBlog(ctx, blogID); err != nil {
+		return nil, err
+	}
+
+	var parentID primitive.ObjectID
+	if comment.GetParentId() != "" {
+		parentID, err = primitive.ObjectIDFromHex(comment.GetParentId())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse parent ID: %v", err))
+		}
+		parent, err := s.store.ReadComment(ctx, parentID)
+		if err == errCommentNotFound {
+			return nil, status.Errorf(codes.FailedPrecondition, fmt.Sprintf("Parent comment not found: %v", comment.GetParentId()))
+		}
+		if err != nil {
+			return nil, storeError(err, commentResource(comment.GetParentId()))
+		}
+		if parent.BlogID != blogID {
+			return nil, status.Errorf(codes.InvalidArgument, "Parent comment is on another blog")
+		}
+	}
+
+	now := s.now()
+	data, err := s.store.CreateComment(ctx, &commentItem{
+		BlogID:     blogID,
+		ParentID:   parentID,
+		AuthorID:   authorID,
+		Content:    comment.GetContent(),
+		CreateTime: now,
+		UpdateTime: now,
+	})
+	if err != nil {
+		return nil, storeError(err, blogResource(blogID.Hex()))
+	}
+	return &blogpb.CreateCommentResponse{Comment: commentToPb(data, 0)}, nil
+}
+
+// ListComments is an RPC for the Comment Service to list the comments on a blog in thread order
+func (s *commentServer) ListComments(req *blogpb.ListCommentsRequest, stream blogpb.CommentService_ListCommentsServer) error {
+	log.Println("Invoked RPC ListComments...")
+	ctx := stream.Context()
+	blogID, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse blog ID: %v", err))
+	}
+	var parentID primitive.ObjectID
+	if req.GetParentId() != "" {
+		parentID, err = primitive.ObjectIDFromHex(req.GetParentId())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse parent ID: %v", err))
+		}
+	}
+	if err := s.checkBlog(ctx, blogID); err != nil {
+		return err
+	}
+
+	replies, err := s.commentThreads(ctx, blogID)
+	if err != nil {
+		return storeError(err, blogResource(req.GetBlogId()))
+	}
+
+	// Depth first, so that every comment is followed by its replies
+	var walk func(parent primitive.ObjectID, depth int32) error
+	walk = func(parent primitive.ObjectID, depth int32) error {
+		for _, c := range replies[parent] {
+			if err := stream.Send(&blogpb.ListCommentsResponse{Comment: commentToPb(c, depth)}); err != nil {
+				return err
+			}
+			if err := walk(c.ID, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(parentID, 0)
+}
+
+// UpdateComment is an RPC for the Comment Service to change the content of a comment, by its author or an admin
+func (s *commentServer) UpdateComment(ctx context.Context, req *blogpb.UpdateCommentRequest) (*blogpb.UpdateCommentResponse, error) {
+	log.Println("Invoked RPC UpdateComment...")
+	comment := req.GetComment()
+	data, err := s.readComment(ctx, comment.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := requireOwner(ctx, data.AuthorID, "comment"); err != nil {
+		return nil, err
+	}
+
+	data.Content = comment.GetContent()
+	data.UpdateTime = s.now()
+	data, err = s.store.UpdateComment(ctx, data)
+	if err != nil {
+		return nil, storeError(err, commentResource(comment.GetId()))
+	}
+	return &blogpb.UpdateCommentResponse{Comment: commentToPb(data, 0)}, nil
+}
+
+// DeleteComment is an RPC for the Comment Service to delete a comment together with all replies to it, by its author or an admin
+func (s *commentServer) DeleteComment(ctx context.Context, req *blogpb.DeleteCommentRequest) (*blogpb.DeleteCommentResponse, error) {
+	log.Println("Invoked RPC DeleteComment...")
+	data, err := s.readComment(ctx, req.GetCommentId())
+	if err != nil {
+		return nil, err
+	}
+	if err := requireOwner(ctx, data.AuthorID, "comment"); err != nil {
+		return nil, err
+	}
+
+	replies, err := s.commentThreads(ctx, data.BlogID)
+	if err != nil {
+		return nil, storeError(err, blogResource(data.BlogID.Hex()))
+	}
+	ids := []primitive.ObjectID{data.ID}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range replies[ids[i]] {
+			ids = append(ids, c.ID)
+		}
+	}
+	if err := s.store.DeleteComments(ctx, ids); err != nil {
+		return nil, storeError(err, commentResource(req.GetCommentId()))
+	}
+	return &blogpb.DeleteCommentResponse{}, nil
+}
+
+// checkBlog returns a gRPC status error unless the blog with the given id exists and is not in the trash.
+func (s *commentServer) checkBlog(ctx context.Context, blogID primitive.ObjectID) error {
+	_, err := s.readBlog(ctx, blogID, false)
+	return storeError(err, blogResource(blogID.Hex()))
+}
+
+// readComment returns the comment with the given id, on a blog that is not in the trash.
+// The returned error is a gRPC status.
+func (s *commentServer) readComment(ctx context.Context, commentID string) (*commentItem, error) {
+	oid, err := primitive.ObjectIDFromHex(commentID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	data, err := s.store.ReadComment(ctx, oid)
+	if err != nil {
+		return nil, storeError(err, commentResource(commentID))
+	}
+	if err := s.checkBlog(ctx, data.BlogID); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// commentThreads returns the comments on a blog grouped by the comment they reply to,
+// oldest first. Top level comments are grouped under the zero id.
+func (s *server) commentThreads(ctx context.Context, blogID primitive.ObjectID) (map[primitive.ObjectID][]*commentItem, error) {
+	replies := map[primitive.ObjectID][]*commentItem{}
+	err := s.store.ListComments(ctx, blogID, func(c *commentItem) error {
+		replies[c.ParentID] = append(replies[c.ParentID], c)
+		return nil
+	})
+	return replies, err
+}
+
+func commentToPb(data *commentItem, depth int32) *blogpb.Comment {
+	c := &blogpb.Comment{
+		Id:         data.ID.Hex(),
+		BlogId:     data.BlogID.Hex(),
+		AuthorId:   data.AuthorID,
+		Content:    data.Content,
+		CreateTime: timestampOrNil(data.CreateTime),
+		UpdateTime: timestampOrNil(data.UpdateTime),
+		Depth:      depth,
+	}
+	if !data.ParentID.IsZero() {
+		c.ParentId = data.ParentID.Hex()
+	}
+	return c
+}
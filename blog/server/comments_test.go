@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/auth"
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCreateComment(t *testing.T) {
+	create := func(comment func(f *fixture) *blogpb.Comment) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.comments.CreateComment(f.as("alice"), &blogpb.CreateCommentRequest{Comment: comment(f)})
+			return err
+		}
+	}
+	onBlog := create(func(f *fixture) *blogpb.Comment {
+		return &blogpb.Comment{BlogId: f.blog.GetId(), Content: "Thanks"}
+	})
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: onBlog, want: codes.OK},
+		{name: "reply", call: create(func(f *fixture) *blogpb.Comment {
+			return &blogpb.Comment{BlogId: f.blog.GetId(), ParentId: f.comment.GetId(), Content: "Thanks"}
+		}), want: codes.OK},
+		{name: "anonymous", call: func(f *fixture) error {
+			comment := &blogpb.Comment{BlogId: f.blog.GetId(), AuthorId: "alice", Content: "Thanks"}
+			_, err := f.comments.CreateComment(context.Background(), &blogpb.CreateCommentRequest{Comment: comment})
+			return err
+		}, want: codes.Unauthenticated},
+		{name: "bad blog id", call: create(func(f *fixture) *blogpb.Comment {
+			return &blogpb.Comment{BlogId: "nope"}
+		}), want: codes.InvalidArgument},
+		{name: "missing blog", call: create(func(f *fixture) *blogpb.Comment {
+			return &blogpb.Comment{BlogId: missingID, Content: "Hi"}
+		}), want: codes.NotFound},
+		{name: "trashed blog", call: create(func(f *fixture) *blogpb.Comment {
+			return &blogpb.Comment{BlogId: f.trashed.GetId(), Content: "Hi"}
+		}), want: codes.NotFound},
+		{name: "bad parent id", call: create(func(f *fixture) *blogpb.Comment {
+			return &blogpb.Comment{BlogId: f.blog.GetId(), ParentId: "nope"}
+		}), want: codes.InvalidArgument},
+		{name: "missing parent", call: create(func(f *fixture) *blogpb.Comment {
+			return &blogpb.Comment{BlogId: f.blog.GetId(), ParentId: missingID, Content: "Hi"}
+		}), want: codes.FailedPrecondition},
+		{name: "store unavailable", storeErr: errDown, call: onBlog, want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: onBlog, want: codes.Internal},
+	})
+}
+
+func TestCreateCommentAsTheCaller(t *testing.T) {
+	f := newFixture(t)
+	comment := &blogpb.Comment{BlogId: f.blog.GetId(), AuthorId: "bob", Content: "Not bob"}
+	res, err := f.comments.CreateComment(f.as("alice"), &blogpb.CreateCommentRequest{Comment: comment})
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	if got := res.GetComment().GetAuthorId(); got != "alice" {
+		t.Errorf("got author %q, want the caller", got)
+	}
+}
+
+func TestListComments(t *testing.T) {
+	list := func(req func(f *fixture) *blogpb.ListCommentsRequest) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.comments.ListComments(context.Background(), req(f))
+			return drain(stream, err, &blogpb.ListCommentsResponse{})
+		}
+	}
+	onBlog := list(func(f *fixture) *blogpb.ListCommentsRequest {
+		return &blogpb.ListCommentsRequest{BlogId: f.blog.GetId()}
+	})
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: onBlog, want: codes.OK},
+		{name: "bad blog id", call: list(func(f *fixture) *blogpb.ListCommentsRequest {
+			return &blogpb.ListCommentsRequest{BlogId: "nope"}
+		}), want: codes.InvalidArgument},
+		{name: "missing blog", call: list(func(f *fixture) *blogpb.ListCommentsRequest {
+			return &blogpb.ListCommentsRequest{BlogId: missingID}
+		}), want: codes.NotFound},
+		{name: "bad parent id", call: list(func(f *fixture) *blogpb.ListCommentsRequest {
+			return &blogpb.ListCommentsRequest{BlogId: f.blog.GetId(), ParentId: "nope"}
+		}), want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: onBlog, want: codes.Unavailable},
+	})
+}
+
+func TestUpdateComment(t *testing.T) {
+	update := func(ctx func(f *fixture) context.Context, id func(f *fixture) string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.comments.UpdateComment(ctx(f), &blogpb.UpdateCommentRequest{Comment: &blogpb.Comment{Id: id(f), Content: "Edited"}})
+			return err
+		}
+	}
+	comment := func(f *fixture) string { return f.comment.GetId() }
+	existing := update(asUser("bob"), comment)
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: existing, want: codes.OK},
+		{name: "admin", call: update(asUser("admin", auth.AdminRole), comment), want: codes.OK},
+		{name: "another user", call: update(asUser("alice"), comment), want: codes.PermissionDenied},
+		{name: "anonymous", call: update(anonymous, comment), want: codes.Unauthenticated},
+		{name: "bad id", call: update(asUser("bob"), func(f *fixture) string { return "nope" }), want: codes.InvalidArgument},
+		{name: "missing", call: update(asUser("bob"), func(f *fixture) string { return missingID }), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: existing, want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: existing, want: codes.Internal},
+	})
+}
+
+func TestDeleteComment(t *testing.T) {
+	del := func(ctx func(f *fixture) context.Context, id func(f *fixture) string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.comments.DeleteComment(ctx(f), &blogpb.DeleteCommentRequest{CommentId: id(f)})
+			return err
+		}
+	}
+	comment := func(f *fixture) string { return f.comment.GetId() }
+	existing := del(asUser("bob"), comment)
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: existing, want: codes.OK},
+		{name: "admin", call: del(asUser("admin", auth.AdminRole), comment), want: codes.OK},
+		{name: "another user", call: del(asUser("alice"), comment), want: codes.PermissionDenied},
+		{name: "anonymous", call: del(anonymous, comment), want: codes.Unauthenticated},
+		{name: "bad id", call: del(asUser("bob"), func(f *fixture) string { return "nope" }), want: codes.InvalidArgument},
+		{name: "missing", call: del(asUser("bob"), func(f *fixture) string { return missingID }), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: existing, want: codes.Unavailable},
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// counterShards is the number of shards of a counter, enough that concurrent increments rarely share one.
+const counterShards = 16
+
+// counter is an int64 that many goroutines can add to at once without contention:
+// every Add goes to a random shard, and Value adds up the shards.
+type counter struct {
+	shards [counterShards]struct {
+		n atomic.Int64
+		// Keep every shard on a cache line of its own
+		_ [56]byte
+	}
+}
+
+// Add adds delta to the counter.
+func (c *counter) Add(delta int64) {
+	c.shards[rand.IntN(counterShards)].n.Add(delta)
+}
+
+// Value returns the sum of everything added to the counter.
+func (c *counter) Value() int64 {
+	var sum int64
+	for i := range c.shards {
+		sum += c.shards[i].n.Load()
+	}
+	return sum
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultTopWindow is the window of ListTopBlogs when the client does not ask for one.
+	defaultTopWindow = 7 * 24 * time.Hour
+	// defaultTopLimit is the number of blogs that ListTopBlogs returns when the client does not ask for a limit.
+	defaultTopLimit = 10
+	// maxTopLimit is the largest number of blogs that ListTopBlogs returns.
+	maxTopLimit = 100
+)
+
+// errEnough stops the ranking of TopBlogs once ListTopBlogs has sent enough blogs.
+var errEnough = errors.New("enough blogs")
+
+// ReactToBlog is an RPC for the Blog Service to react to a blog, or to take a reaction back
+func (s *server) ReactToBlog(ctx context.Context, req *blogpb.ReactToBlogRequest) (*blogpb.ReactToBlogResponse, error) {
+	log.Println("Invoked RPC ReactToBlog...")
+	userID := callerID(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "Missing token")
+	}
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	data, err := s.readBlog(ctx, oid, false)
+	if err != nil {
+		return nil, storeError(err, blogResource(req.GetBlogId()))
+	}
+
+	// Reacting is idempotent, so whether the reaction changed anything does not matter here
+	if req.GetRemove() {
+		_, err = s.store.RemoveReaction(ctx, oid, userID, req.GetReaction())
+	} else {
+		_, err = s.store.AddReaction(ctx, &reactionItem{BlogID: oid, UserID: userID, Reaction: req.GetReaction(), CreateTime: s.now()})
+	}
+	if err != nil {
+		return nil, storeError(err, blogResource(req.GetBlogId()))
+	}
+	blog, err := s.blogWithCounts(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.ReactToBlogResponse{Blog: blog}, nil
+}
+
+// RecordView is an RPC for the Blog Service to count a view of a blog
+func (s *server) RecordView(ctx context.Context, req *blogpb.RecordViewRequest) (*blogpb.RecordViewResponse, error) {
+	log.Println("Invoked RPC RecordView...")
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	if _, err := s.readBlog(ctx, oid, false); err != nil {
+		return nil, storeError(err, blogResource(req.GetBlogId()))
+	}
+	views, err := s.store.AddView(ctx, oid, s.now())
+	if err != nil {
+		return nil, storeError(err, blogResource(req.GetBlogId()))
+	}
+	return &blogpb.RecordViewResponse{ViewCount: views}, nil
+}
+
+// ListTopBlogs is an RPC for the Blog Service to stream the most viewed or reacted to published blogs in a time window
+func (s *server) ListTopBlogs(req *blogpb.ListTopBlogsRequest, stream blogpb.BlogService_ListTopBlogsServer) error {
+	log.Println("Invoked RPC ListTopBlogs...")
+	ctx := stream.Context()
+	metric := metricViews
+	if req.GetMetric() == blogpb.ListTopBlogsRequest_REACTIONS {
+		metric = metricReactions
+	}
+	end := s.now()
+	if req.GetEndTime() != nil {
+		end = req.GetEndTime().AsTime()
+	}
+	start := end.Add(-defaultTopWindow)
+	if req.GetStartTime() != nil {
+		start = req.GetStartTime().AsTime()
+	}
+	if !start.Before(end) {
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Window ends before it starts: %v to %v", start, end))
+	}
+	limit := int(req.GetLimit())
+	switch {
+	case limit < 0:
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Negative limit: %v", limit))
+	case limit == 0:
+		limit = defaultTopLimit
+	case limit > maxTopLimit:
+		limit = maxTopLimit
+	}
+
+	sent := 0
+	var sendErr error
+	err := s.store.TopBlogs(ctx, metric, start, end, func(bc blogCount) error {
+		data, err := s.store.Read(ctx, bc.BlogID)
+		if err == errNotFound {
+			// Purged since it was viewed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// The rankings are public, so they only have published blogs
+		if data.trashed() || data.status() != statusPublished {
+			return nil
+		}
+		blog, err := s.blogWithCounts(ctx, data)
+		if err != nil {
+			sendErr = err
+			return err
+		}
+		if sendErr = stream.Send(&blogpb.ListTopBlogsResponse{Blog: blog, Count: bc.Count}); sendErr != nil {
+			return sendErr
+		}
+		if sent++; sent == limit {
+			return errEnough
+		}
+		return nil
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil && err != errEnough {
+		return storeError(err, blogs)
+	}
+	return nil
+}
+
+// blogWithCounts returns data as a protobuf blog, with its views and reactions.
+// The returned error is a gRPC status.
+func (s *server) blogWithCounts(ctx context.Context, data *blogItem) (*blogpb.Blog, error) {
+	blog := dataToBlogPb(data)
+	counts, err := s.store.ReadCounts(ctx, data.ID)
+	if err != nil {
+		return nil, storeError(err, blogResource(data.ID.Hex()))
+	}
+	blog.ViewCount = counts.Views
+	blog.ReactionCounts = counts.Reactions
+	return blog, nil
+}
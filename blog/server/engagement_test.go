@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestReactToBlog(t *testing.T) {
+	react := func(subject string, id func(f *fixture) string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.ReactToBlog(f.as(subject), &blogpb.ReactToBlogRequest{BlogId: id(f), Reaction: "like"})
+			return err
+		}
+	}
+	published := func(f *fixture) string { return f.blog.GetId() }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: react("bob", published), want: codes.OK},
+		{name: "emoji", call: func(f *fixture) error {
+			_, err := f.blogs.ReactToBlog(f.as("bob"), &blogpb.ReactToBlogRequest{BlogId: f.blog.GetId(), Reaction: "👍🏽"})
+			return err
+		}, want: codes.OK},
+		{name: "anonymous", call: func(f *fixture) error {
+			_, err := f.blogs.ReactToBlog(context.Background(), &blogpb.ReactToBlogRequest{BlogId: f.blog.GetId(), Reaction: "like"})
+			return err
+		}, want: codes.Unauthenticated},
+		{name: "bad reaction", call: func(f *fixture) error {
+			_, err := f.blogs.ReactToBlog(f.as("bob"), &blogpb.ReactToBlogRequest{BlogId: f.blog.GetId(), Reaction: "Like!"})
+			return err
+		}, want: codes.InvalidArgument},
+		{name: "draft of another author", call: react("bob", func(f *fixture) string { return f.draft.GetId() }), want: codes.NotFound},
+		{name: "trashed", call: react("bob", func(f *fixture) string { return f.trashed.GetId() }), want: codes.NotFound},
+		{name: "missing", call: react("bob", func(f *fixture) string { return missingID }), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: react("bob", published), want: codes.Unavailable},
+	})
+}
+
+func TestReactionsAreIdempotentPerUser(t *testing.T) {
+	f := newFixture(t)
+	react := func(subject, reaction string, remove bool) *blogpb.Blog {
+		t.Helper()
+		req := &blogpb.ReactToBlogRequest{BlogId: f.blog.GetId(), Reaction: reaction, Remove: remove}
+		res, err := f.blogs.ReactToBlog(f.as(subject), req)
+		if err != nil {
+			t.Fatalf("ReactToBlog: %v", err)
+		}
+		return res.GetBlog()
+	}
+	react("bob", "like", false)
+	react("bob", "like", false)
+	react("alice", "like", false)
+	got := react("bob", "🎉", false).GetReactionCounts()
+	if got["like"] != 2 || got["🎉"] != 1 {
+		t.Errorf("got reactions %v, want 2 likes and 1 🎉", got)
+	}
+	react("bob", "like", true)
+	got = react("bob", "like", true).GetReactionCounts()
+	if got["like"] != 1 {
+		t.Errorf("got reactions %v after taking a like back twice, want 1 like", got)
+	}
+	react("bob", "🎉", true)
+
+	res, err := f.blogs.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if got := res.GetBlog().GetReactionCounts(); len(got) != 1 || got["like"] != 1 {
+		t.Errorf("got reactions %v from ReadBlog, want only 1 like", got)
+	}
+}
+
+func TestRecordView(t *testing.T) {
+	view := func(id func(f *fixture) string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.RecordView(context.Background(), &blogpb.RecordViewRequest{BlogId: id(f)})
+			return err
+		}
+	}
+	published := func(f *fixture) string { return f.blog.GetId() }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: view(published), want: codes.OK},
+		{name: "draft", call: view(func(f *fixture) string { return f.draft.GetId() }), want: codes.NotFound},
+		{name: "bad id", call: view(func(f *fixture) string { return "nope" }), want: codes.InvalidArgument},
+		{name: "missing", call: view(func(f *fixture) string { return missingID }), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: view(published), want: codes.Unavailable},
+	})
+}
+
+func TestConcurrentViewsAreAllCounted(t *testing.T) {
+	f := newFixture(t)
+	const workers, views = 16, 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < views; j++ {
+				if _, err := f.blogs.RecordView(context.Background(), &blogpb.RecordViewRequest{BlogId: f.blog.GetId()}); err != nil {
+					t.Errorf("RecordView: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	res, err := f.blogs.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if got := res.GetBlog().GetViewCount(); got != workers*views {
+		t.Errorf("got %v views, want %v", got, workers*views)
+	}
+}
+
+func TestListTopBlogs(t *testing.T) {
+	f := newFixture(t)
+	now := time.Now().Truncate(time.Hour).Add(30 * time.Minute)
+	f.srv.now = func() time.Time { return now }
+	create := func(title string) string {
+		t.Helper()
+		blog := &blogpb.Blog{Title: title, Status: blogpb.Blog_PUBLISHED}
+		res, err := f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: blog})
+		if err != nil {
+			t.Fatalf("CreateBlog: %v", err)
+		}
+		return res.GetBlog().GetId()
+	}
+	viewed := create("Viewed")
+	viewedEarlier := create("Viewed earlier")
+	view := func(id string, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := f.blogs.RecordView(context.Background(), &blogpb.RecordViewRequest{BlogId: id}); err != nil {
+				t.Fatalf("RecordView: %v", err)
+			}
+		}
+	}
+	// Ten days ago, so outside of the default window of a week
+	now = now.Add(-10 * 24 * time.Hour)
+	view(viewedEarlier, 5)
+	now = now.Add(10 * 24 * time.Hour)
+	view(viewed, 3)
+	view(f.blog.GetId(), 1)
+	view(viewedEarlier, 2)
+	if _, err := f.blogs.ReactToBlog(f.as("bob"), &blogpb.ReactToBlogRequest{BlogId: viewedEarlier, Reaction: "like"}); err != nil {
+		t.Fatalf("ReactToBlog: %v", err)
+	}
+	// Unpublished blogs are not ranked, whatever their views
+	if _, err := f.blogs.UnpublishBlog(f.as("alice"), &blogpb.UnpublishBlogRequest{BlogId: f.blog.GetId()}); err != nil {
+		t.Fatalf("UnpublishBlog: %v", err)
+	}
+
+	// The windows end now, and the reaction is from the minute before
+	now = now.Add(time.Minute)
+
+	top := func(req *blogpb.ListTopBlogsRequest) ([]string, []int64) {
+		t.Helper()
+		stream, err := f.blogs.ListTopBlogs(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ListTopBlogs: %v", err)
+		}
+		var titles []string
+		var counts []int64
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				break
+			}
+			titles = append(titles, res.GetBlog().GetTitle())
+			counts = append(counts, res.GetCount())
+		}
+		return titles, counts
+	}
+	for name, tt := range map[string]struct {
+		req    *blogpb.ListTopBlogsRequest
+		titles []string
+		counts []int64
+	}{
+		"last week": {&blogpb.ListTopBlogsRequest{}, []string{"Viewed", "Viewed earlier"}, []int64{3, 2}},
+		"all time": {
+			&blogpb.ListTopBlogsRequest{StartTime: timestamppb.New(now.Add(-30 * 24 * time.Hour))},
+			[]string{"Viewed earlier", "Viewed"}, []int64{7, 3},
+		},
+		"until a week ago": {
+			&blogpb.ListTopBlogsRequest{StartTime: timestamppb.New(now.Add(-30 * 24 * time.Hour)), EndTime: timestamppb.New(now.Add(-7 * 24 * time.Hour))},
+			[]string{"Viewed earlier"}, []int64{5},
+		},
+		"limit": {&blogpb.ListTopBlogsRequest{Limit: 1}, []string{"Viewed"}, []int64{3}},
+		"reactions": {
+			&blogpb.ListTopBlogsRequest{Metric: blogpb.ListTopBlogsRequest_REACTIONS},
+			[]string{"Viewed earlier"}, []int64{1},
+		},
+	} {
+		titles, counts := top(tt.req)
+		if len(titles) != len(tt.titles) || len(counts) != len(tt.counts) {
+			t.Errorf("%v: got %v with counts %v, want %v with counts %v", name, titles, counts, tt.titles, tt.counts)
+			continue
+		}
+		for i := range titles {
+			if titles[i] != tt.titles[i] || counts[i] != tt.counts[i] {
+				t.Errorf("%v: got %v with counts %v, want %v with counts %v", name, titles, counts, tt.titles, tt.counts)
+				break
+			}
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	var c counter
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.Value(); got != 8000 {
+		t.Errorf("got %v, want 8000", got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resource is what a request is about, for the messages and ResourceInfo details of its errors.
+type resource struct {
+	// kind is the name of the resource in messages, like "Blog"
+	kind string
+	// typ is the full protobuf name of the resource, like "blog.Blog"
+	typ string
+	// name identifies the resource, like "blogs/5f1b2c3d4e5f6a7b8c9d0e1f"
+	name string
+}
+
+// blogs, authors and attachments are the collections of all of them, for the errors of requests about many of them.
+var (
+	blogs       = resource{kind: "Blogs", typ: "blog.Blog", name: "blogs"}
+	authors     = resource{kind: "Authors", typ: "blog.Author", name: "authors"}
+	attachments = resource{kind: "Attachments", typ: "blog.Attachment", name: "attachments"}
+)
+
+func blogResource(id string) resource {
+	return resource{kind: "Blog", typ: "blog.Blog", name: "blogs/" + id}
+}
+
+func slugResource(slug string) resource {
+	return resource{kind: "Blog", typ: "blog.Blog", name: "blogs/slug:" + slug}
+}
+
+func revisionResource(blogID string, version int64) resource {
+	return resource{kind: "Revision", typ: "blog.BlogRevision", name: fmt.Sprintf("blogs/%v/revisions/%v", blogID, version)}
+}
+
+func commentResource(id string) resource {
+	return resource{kind: "Comment", typ: "blog.Comment", name: "comments/" + id}
+}
+
+func authorResource(id string) resource {
+	return resource{kind: "Author", typ: "blog.Author", name: "authors/" + id}
+}
+
+func attachmentResource(id string) resource {
+	return resource{kind: "Attachment", typ: "blog.Attachment", name: "attachments/" + id}
+}
+
+// storeError translates an error of the store about res into a gRPC status error:
+//
+//   - the not found errors of the stores to codes.NotFound
+//   - the already exists errors of the stores to codes.AlreadyExists
+//   - errVersionMismatch and errSlugExists to codes.Aborted
+//   - errors reaching the database to codes.Unavailable, so that clients can retry
+//   - everything else to codes.Internal
+//
+// The status has a ResourceInfo detail naming res. The errors of the database itself
+// are only logged, as their text may tell clients about its internals. Errors that
+// already are a gRPC status are returned as they are.
+func storeError(err error, res resource) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var st *status.Status
+	description := err.Error()
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, errRevisionNotFound),
+		errors.Is(err, errCommentNotFound), errors.Is(err, errAuthorNotFound), errors.Is(err, errAttachmentNotFound):
+		st = status.New(codes.NotFound, fmt.Sprintf("%s not found: %s", res.kind, res.name))
+	case errors.Is(err, errBlogExists), errors.Is(err, errAuthorExists), errors.Is(err, errCommentExists):
+		st = status.New(codes.AlreadyExists, fmt.Sprintf("%s already exists: %s", res.kind, res.name))
+	case errors.Is(err, errVersionMismatch):
+		st = status.New(codes.Aborted, fmt.Sprintf("%s was modified concurrently: %s", res.kind, res.name))
+	case errors.Is(err, errSlugExists):
+		// Another blog took the slug between our check and the write, a retry picks the next one
+		st = status.New(codes.Aborted, fmt.Sprintf("%s slug was taken concurrently: %s", res.kind, res.name))
+	case errors.Is(err, context.Canceled):
+		st = status.New(codes.Canceled, "Request canceled")
+	case errors.Is(err, context.DeadlineExceeded):
+		st = status.New(codes.DeadlineExceeded, "Request deadline exceeded")
+	case unavailable(err):
+		log.Printf("Database unavailable for %v: %v\n", res.name, err)
+		st = status.New(codes.Unavailable, "Database unavailable")
+		description = ""
+	default:
+		log.Printf("Database error for %v: %v\n", res.name, err)
+		st = status.New(codes.Internal, "Internal error")
+		description = ""
+	}
+
+	info := &errdetails.ResourceInfo{ResourceType: res.typ, ResourceName: res.name, Description: description}
+	if withInfo, err := st.WithDetails(info); err == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
+
+// unavailable reports whether err means that the database cannot be reached right now.
+func unavailable(err error) bool {
+	var selection topology.ServerSelectionError
+	return mongo.IsNetworkError(err) || errors.Is(err, mongo.ErrClientDisconnected) ||
+		errors.Is(err, topology.ErrServerSelectionTimeout) || errors.As(err, &selection)
+}
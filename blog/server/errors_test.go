@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStoreError(t *testing.T) {
+	const id = "5f1b2c3d4e5f6a7b8c9d0e1f"
+	tests := []struct {
+		name string
+		err  error
+		res  resource
+		want codes.Code
+	}{
+		{"blog not found", errNotFound, blogResource(id), codes.NotFound},
+		{"wrapped not found", fmt.Errorf("reading: %w", errNotFound), blogResource(id), codes.NotFound},
+		{"revision not found", errRevisionNotFound, revisionResource(id, 3), codes.NotFound},
+		{"comment not found", errCommentNotFound, commentResource(id), codes.NotFound},
+		{"author not found", errAuthorNotFound, authorResource("alice"), codes.NotFound},
+		{"blog exists", errBlogExists, blogResource(id), codes.AlreadyExists},
+		{"author exists", errAuthorExists, authorResource("alice"), codes.AlreadyExists},
+		{"version mismatch", errVersionMismatch, blogResource(id), codes.Aborted},
+		{"canceled", context.Canceled, blogs, codes.Canceled},
+		{"deadline", context.DeadlineExceeded, blogs, codes.DeadlineExceeded},
+		{"network error", errDown, blogs, codes.Unavailable},
+		{"client disconnected", mongo.ErrClientDisconnected, authors, codes.Unavailable},
+		{"unknown", errBroken, blogResource(id), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(storeError(tt.err, tt.res))
+			if st.Code() != tt.want {
+				t.Fatalf("got code %v (%v), want %v", st.Code(), st.Message(), tt.want)
+			}
+			var info *errdetails.ResourceInfo
+			for _, d := range st.Details() {
+				if d, ok := d.(*errdetails.ResourceInfo); ok {
+					info = d
+				}
+			}
+			if info == nil {
+				t.Fatalf("no ResourceInfo in %v", st.Details())
+			}
+			description := tt.err.Error()
+			// The errors of the database itself are not passed on to clients
+			if tt.want == codes.Unavailable || tt.want == codes.Internal {
+				description = ""
+				if strings.Contains(st.Message(), tt.err.Error()) {
+					t.Errorf("got message %q, want it without the error of the database", st.Message())
+				}
+			}
+			if info.GetResourceType() != tt.res.typ || info.GetResourceName() != tt.res.name || info.GetDescription() != description {
+				t.Errorf("got ResourceInfo %v, want %v %v %q", info, tt.res.typ, tt.res.name, description)
+			}
+		})
+	}
+}
+
+func TestStoreErrorPassesStatus(t *testing.T) {
+	if err := storeError(nil, blogs); err != nil {
+		t.Errorf("storeError(nil) = %v, want nil", err)
+	}
+	err := status.Error(codes.PermissionDenied, "no")
+	if got := storeError(err, blogs); got != err {
+		t.Errorf("storeError(%v) = %v, want it unchanged", err, got)
+	}
+}
+
+func TestNotFoundCarriesBlogID(t *testing.T) {
+	f := newFixture(t)
+	_, err := f.blogs.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: missingID})
+	st := status.Convert(err)
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ResourceInfo); ok && info.GetResourceName() == "blogs/"+missingID {
+			return
+		}
+	}
+	t.Errorf("got details %v, want a ResourceInfo for blogs/%v", st.Details(), missingID)
+}
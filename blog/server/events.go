@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// eventHistory is the number of past events kept for resuming watchers.
+	eventHistory = 4096
+	// subscriberBuffer is the number of events a watcher may lag behind before it is dropped.
+	subscriberBuffer = 256
+)
+
+// blogEvent is a change to a blog, published by the server after every write.
+type blogEvent struct {
+	Seq  uint64
+	Type blogpb.WatchBlogsResponse_EventType
+	Blog blogItem
+	// Before is the blog before the change, nil if the change created it
+	Before *blogItem
+	Time   time.Time
+}
+
+var (
+	// errInvalidResumeToken is returned when a resume token cannot be parsed.
+	errInvalidResumeToken = errors.New("invalid resume token")
+	// errResumeTokenExpired is returned when the events after a resume token are no longer available.
+	errResumeTokenExpired = errors.New("resume token expired")
+)
+
+// subscriber receives the events published after it subscribed.
+// The channel is closed if the subscriber falls too far behind.
+type subscriber struct {
+	ch chan blogEvent
+}
+
+// eventBus is an in-process publish/subscribe bus for blog events. It keeps a
+// window of recent events, so that watchers can reconnect without missing any.
+type eventBus struct {
+	mu      sync.Mutex
+	epoch   uint64 // distinguishes the sequence numbers of this process from an earlier one
+	seq     uint64
+	history []blogEvent
+	subs    map[*subscriber]bool
+}
+
+// newEventBus returns an event bus without subscribers.
+func newEventBus() *eventBus {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return &eventBus{epoch: binary.BigEndian.Uint64(b[:]), subs: map[*subscriber]bool{}}
+}
+
+// Publish sends an event for the change of a blog from before to item to all subscribers.
+// before is nil if the change created the blog.
+func (b *eventBus) Publish(typ blogpb.WatchBlogsResponse_EventType, before, item *blogItem, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := blogEvent{Seq: b.seq, Type: typ, Blog: *item, Time: now}
+	if before != nil {
+		old := *before
+		e.Before = &old
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > eventHistory {
+		b.history = b.history[len(b.history)-eventHistory:]
+	}
+	for sub := range b.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			// Never block the writers, the watcher has to resume instead
+			log.Println("Dropping a watcher that fell behind...")
+			close(sub.ch)
+			delete(b.subs, sub)
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. If resumeToken is set, the events after
+// it are returned, and the subscriber gets all events after those.
+func (b *eventBus) Subscribe(resumeToken string) ([]blogEvent, *subscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []blogEvent
+	if resumeToken != "" {
+		epoch, seq, err := decodeResumeToken(resumeToken)
+		if err != nil {
+			return nil, nil, err
+		}
+		if epoch != b.epoch || seq > b.seq {
+			return nil, nil, errResumeTokenExpired
+		}
+		// The oldest kept event must directly follow the token, or events were lost
+		if seq < b.seq && (len(b.history) == 0 || b.history[0].Seq > seq+1) {
+			return nil, nil, errResumeTokenExpired
+		}
+		for _, e := range b.history {
+			if e.Seq > seq {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	sub := &subscriber{ch: make(chan blogEvent, subscriberBuffer)}
+	b.subs[sub] = true
+	return replay, sub, nil
+}
+
+// Unsubscribe removes sub from the bus.
+func (b *eventBus) Unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		close(sub.ch)
+		delete(b.subs, sub)
+	}
+}
+
+// ResumeToken returns the token to resume after e.
+func (b *eventBus) ResumeToken(e blogEvent) string {
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], b.epoch)
+	binary.BigEndian.PutUint64(raw[8:], e.Seq)
+	return base64.RawURLEncoding.EncodeToString(raw[:])
+}
+
+func decodeResumeToken(token string) (epoch, seq uint64, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != 16 {
+		return 0, 0, errInvalidResumeToken
+	}
+	return binary.BigEndian.Uint64(raw[:8]), binary.BigEndian.Uint64(raw[8:]), nil
+}
+
+// WatchBlogs is an RPC for the Blog Service to stream changes to blogs as they happen
+func (s *server) WatchBlogs(req *blogpb.WatchBlogsRequest, stream blogpb.BlogService_WatchBlogsServer) error {
+	log.Println("Invoked RPC WatchBlogs...")
+	var blogID primitive.ObjectID
+	if req.GetBlogId() != "" {
+		var err error
+		blogID, err = primitive.ObjectIDFromHex(req.GetBlogId())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+		}
+	}
+	// visible returns the state of the blog of e that the watcher may see, or nil, and whether
+	// it is the state after the change. Watchers that could only see the blog before the change,
+	// like the readers of a blog that was unpublished, see it disappear.
+	visible := func(e blogEvent) (*blogItem, bool) {
+		if canSee(stream.Context(), &e.Blog) {
+			return &e.Blog, true
+		}
+		if e.Before != nil && canSee(stream.Context(), e.Before) {
+			return e.Before, false
+		}
+		return nil, false
+	}
+	match := func(data *blogItem) bool {
+		if req.GetAuthorId() != "" && data.AuthorID != req.GetAuthorId() {
+			return false
+		}
+		return blogID.IsZero() || data.ID == blogID
+	}
+
+	replay, sub, err := s.events.Subscribe(req.GetResumeToken())
+	switch err {
+	case nil:
+	case errInvalidResumeToken:
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse resume token: %v", err))
+	case errResumeTokenExpired:
+		return status.Errorf(codes.OutOfRange, "The events after the resume token are no longer available")
+	default:
+		return status.Errorf(codes.Internal, fmt.Sprintf("Error watching blogs: %v", err))
+	}
+	defer s.events.Unsubscribe(sub)
+
+	send := func(e blogEvent) error {
+		data, full := visible(e)
+		if data == nil || !match(data) {
+			return nil
+		}
+		res := &blogpb.WatchBlogsResponse{
+			Type:        e.Type,
+			Blog:        dataToBlogPb(data),
+			EventTime:   timestampOrNil(e.Time),
+			ResumeToken: s.events.ResumeToken(e),
+		}
+		if !full {
+			// Only tell that the blog is gone, its new state is none of the watcher's business
+			res.Type = blogpb.WatchBlogsResponse_DELETED
+			res.Blog = &blogpb.Blog{Id: data.ID.Hex()}
+		}
+		return stream.Send(res)
+	}
+	for _, e := range replay {
+		if err := send(e); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-sub.ch:
+			if !ok {
+				return status.Errorf(codes.Aborted, "Watcher fell behind, resume with the last resume token")
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
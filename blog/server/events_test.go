@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// retitle changes the title of the blog of f.
+func retitle(f *fixture, title string) error {
+	req := &blogpb.PatchBlogRequest{
+		Blog:       &blogpb.Blog{Id: f.blog.GetId(), Title: title},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	}
+	_, err := f.blogs.PatchBlog(f.as("alice"), req)
+	return err
+}
+
+// watchEvents watches the blog of f from resumeToken, and returns the first n events.
+func watchEvents(t *testing.T, f *fixture, resumeToken string, n int) []*blogpb.WatchBlogsResponse {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := f.blogs.WatchBlogs(ctx, &blogpb.WatchBlogsRequest{BlogId: f.blog.GetId(), ResumeToken: resumeToken})
+	if err != nil {
+		t.Fatalf("WatchBlogs: %v", err)
+	}
+	var events []*blogpb.WatchBlogsResponse
+	for len(events) < n {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("WatchBlogs after %v events: %v", len(events), err)
+		}
+		events = append(events, res)
+	}
+	return events
+}
+
+func TestWatchBlogsResume(t *testing.T) {
+	f := newFixture(t)
+	// The token before the first event replays all of them
+	first := watchEvents(t, f, f.srv.events.ResumeToken(blogEvent{}), 1)[0]
+	if first.GetType() != blogpb.WatchBlogsResponse_CREATED || first.GetBlog().GetTitle() != "Hello gRPC" {
+		t.Fatalf("got first event %v %q, want the creation of the blog", first.GetType(), first.GetBlog().GetTitle())
+	}
+
+	// Changes while the watcher is away are replayed in order
+	for _, title := range []string{"Second", "Third"} {
+		if err := retitle(f, title); err != nil {
+			t.Fatalf("PatchBlog: %v", err)
+		}
+	}
+	events := watchEvents(t, f, first.GetResumeToken(), 2)
+	for i, title := range []string{"Second", "Third"} {
+		if events[i].GetType() != blogpb.WatchBlogsResponse_UPDATED || events[i].GetBlog().GetTitle() != title {
+			t.Errorf("got event %v %v %q, want UPDATED %q", i, events[i].GetType(), events[i].GetBlog().GetTitle(), title)
+		}
+	}
+
+	// And resuming from the last event waits for the next change
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := f.blogs.WatchBlogs(ctx, &blogpb.WatchBlogsRequest{BlogId: f.blog.GetId(), ResumeToken: events[1].GetResumeToken()})
+	if err != nil {
+		t.Fatalf("WatchBlogs: %v", err)
+	}
+	go func() {
+		// The watch may start after the change, which the token replays then
+		time.Sleep(10 * time.Millisecond)
+		if err := retitle(f, "Fourth"); err != nil {
+			t.Errorf("PatchBlog: %v", err)
+		}
+	}()
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("WatchBlogs: %v", err)
+	}
+	if res.GetBlog().GetTitle() != "Fourth" {
+		t.Errorf("got title %q, want Fourth", res.GetBlog().GetTitle())
+	}
+}
+
+func TestWatchBlogsUnpublishedForAnonymous(t *testing.T) {
+	f := newFixture(t)
+	last := f.srv.events.ResumeToken(blogEvent{Seq: f.srv.events.seq})
+	if _, err := f.blogs.UnpublishBlog(f.as("alice"), &blogpb.UnpublishBlogRequest{BlogId: f.blog.GetId()}); err != nil {
+		t.Fatalf("UnpublishBlog: %v", err)
+	}
+	// The anonymous watcher only learns that the blog is gone, not the draft
+	res := watchEvents(t, f, last, 1)[0]
+	if res.GetType() != blogpb.WatchBlogsResponse_DELETED || res.GetBlog().GetId() != f.blog.GetId() {
+		t.Errorf("got event %v for %q, want DELETED for the blog", res.GetType(), res.GetBlog().GetId())
+	}
+	if res.GetBlog().GetTitle() != "" || res.GetBlog().GetAuthorId() != "" || res.GetBlog().GetStatus() != blogpb.Blog_UNKNOWN {
+		t.Errorf("got blog %v, want only the id", res.GetBlog())
+	}
+}
+
+func TestWatchBlogsExpiredToken(t *testing.T) {
+	f := newFixture(t)
+	token := f.srv.events.ResumeToken(blogEvent{Seq: 1})
+	// Push the event after the token out of the history
+	data := &blogItem{Title: "Noise"}
+	for i := 0; i < eventHistory; i++ {
+		f.srv.events.Publish(blogpb.WatchBlogsResponse_UPDATED, data, data, time.Now())
+	}
+	tokens := map[string]string{
+		"out of the history":   token,
+		"of another process":   newEventBus().ResumeToken(blogEvent{Seq: 1}),
+		"after the last event": f.srv.events.ResumeToken(blogEvent{Seq: 1 << 40}),
+	}
+	for name, token := range tokens {
+		stream, err := f.blogs.WatchBlogs(context.Background(), &blogpb.WatchBlogsRequest{ResumeToken: token})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.OutOfRange {
+			t.Errorf("token %v: got %v, want OutOfRange", name, err)
+		}
+	}
+}
+
+func TestEventBusDropsSlowSubscribers(t *testing.T) {
+	b := newEventBus()
+	_, sub, err := b.Subscribe("")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	data := &blogItem{Title: "Noise"}
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(blogpb.WatchBlogsResponse_UPDATED, data, data, time.Now())
+	}
+	n := 0
+	for range sub.ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("got %v events before the channel was closed, want %v", n, subscriberBuffer)
+	}
+	// Unsubscribing a dropped subscriber is fine
+	b.Unsubscribe(sub)
+}
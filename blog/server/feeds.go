@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSiteURL is the base URL of the public blog site, unless configured otherwise.
+	defaultSiteURL = "http://localhost:8080"
+	// feedSize is the number of blogs in a feed, the newest ones.
+	feedSize = 20
+	// feedTitle is the title of the site-wide feeds.
+	feedTitle = "Blogs"
+)
+
+// registerFeeds adds the RSS 2.0 and Atom feeds of the published blogs to mux, site-wide and per author:
+//
+//	GET /feed.rss
+//	GET /feed.atom
+//	GET /authors/{author}/feed.rss
+//	GET /authors/{author}/feed.atom
+func (s *server) registerFeeds(mux *http.ServeMux) {
+	mux.HandleFunc("GET /feed.rss", s.serveFeed(rssFormat))
+	mux.HandleFunc("GET /feed.atom", s.serveFeed(atomFormat))
+	mux.HandleFunc("GET /authors/{author}/feed.rss", s.serveFeed(rssFormat))
+	mux.HandleFunc("GET /authors/{author}/feed.atom", s.serveFeed(atomFormat))
+}
+
+// feed is the content of a feed, whatever its format.
+type feed struct {
+	Title       string
+	Description string
+	// Link is the page of the site that the feed follows, and Self the URL of the feed itself
+	// on the site, which is also the id of Atom feeds.
+	Link string
+	Self string
+	// Updated is the last time that any blog of the feed changed, zero if it has none.
+	Updated time.Time
+	Entries []feedEntry
+}
+
+type feedEntry struct {
+	// ID identifies the blog for good, unlike Link, which changes with the title.
+	ID string
+	// Version is the version of the blog, which changes with everything the entry shows of it.
+	Version   int64
+	Title     string
+	Link      string
+	Author    string
+	Tags      []string
+	Published time.Time
+	Updated   time.Time
+	// Content is the Markdown of the blog, which is only rendered when the feed is encoded.
+	Content string
+}
+
+// feedTimes keeps the last modification of every feed that the server served, so that
+// Last-Modified only moves forward, also when the newest blog leaves a feed.
+type feedTimes struct {
+	mu sync.Mutex
+	// start is when the server started, before which it cannot know what was served
+	start time.Time
+	feeds map[string]feedTime
+}
+
+type feedTime struct {
+	etag     string
+	modified time.Time
+}
+
+// newFeedTimes returns the feed times of a server that started at start.
+func newFeedTimes(start time.Time) *feedTimes {
+	return &feedTimes{start: start, feeds: map[string]feedTime{}}
+}
+
+// modified returns the last modification of the feed at path, which has the given ETag, and whose
+// blogs were last updated at updated. A feed that changed since it was last served is modified now,
+// and in a later second than before, which is the precision of HTTP dates.
+func (t *feedTimes) modified(path, etag string, updated, now time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last, ok := t.feeds[path]
+	if ok && last.etag == etag {
+		return last.modified
+	}
+	modified := latest(updated, t.start)
+	if ok {
+		modified = latest(modified, now, last.modified.Truncate(time.Second).Add(time.Second))
+	}
+	t.feeds[path] = feedTime{etag: etag, modified: modified}
+	return modified
+}
+
+// latest returns the latest of times.
+func latest(times ...time.Time) time.Time {
+	var newest time.Time
+	for _, t := range times {
+		if t.After(newest) {
+			newest = t
+		}
+	}
+	return newest
+}
+
+// feedFormat encodes feeds in one format.
+type feedFormat struct {
+	contentType string
+	encode      func(f *feed) interface{}
+}
+
+// serveFeed returns a handler that serves the feed of the author in the path, or the site-wide
+// feed if there is none, in format. The ETag is a checksum of the blogs in the feed and their
+// versions, so that it also changes when a blog leaves the feed, and Last-Modified is the last
+// update of the blogs in it, or when the server saw the ETag change, if that is later. Both are
+// known before the feed is rendered, which conditional requests for an unchanged feed skip.
+func (s *server) serveFeed(format feedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Serve feed %v...\n", r.URL.Path)
+		f, err := s.loadFeed(r.Context(), r.PathValue("author"))
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		// The site URL, since the Host of the request is up to the client
+		f.Self = s.siteURL + r.URL.Path
+
+		etag := f.etag(format)
+		modified := s.feedTimes.modified(r.URL.Path, etag, f.Updated, s.now())
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "  ")
+		if err := enc.Encode(format.encode(f)); err != nil {
+			log.Printf("Error encoding feed: %v\n", err)
+			http.Error(w, "Cannot encode feed", http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "", modified, bytes.NewReader(buf.Bytes()))
+	}
+}
+
+// etag returns the ETag of the feed in format, from what the feed shows but without rendering it.
+func (f *feed) etag(format feedFormat) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %q %q\n", format.contentType, f.Title, f.Description, f.Link, f.Self)
+	for _, e := range f.Entries {
+		fmt.Fprintf(h, "%q %v %v %q\n", e.ID, e.Version, e.Updated.UnixMilli(), e.Author)
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified reports whether the client of r already has the feed with the given ETag and
+// last modification. As in RFC 9110, If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// loadFeed returns the feed of the newest published blogs of author, or of all authors if author is empty.
+// The returned error is a gRPC status.
+func (s *server) loadFeed(ctx context.Context, author string) (*feed, error) {
+	f := &feed{Title: feedTitle, Description: "The newest published blogs", Link: s.siteURL + "/"}
+	if author != "" {
+		data, err := s.store.ReadAuthor(ctx, author)
+		if err != nil {
+			return nil, storeError(err, authorResource(author))
+		}
+		f.Title = authorName(data) + " - " + feedTitle
+		f.Description = "The newest published blogs of " + authorName(data)
+		f.Link = s.siteURL + "/authors/" + url.PathEscape(author)
+	}
+
+	names := map[string]string{}
+	q := listQuery{
+		Filter:  blogFilter{AuthorID: author, Statuses: []blogStatus{statusPublished}},
+		OrderBy: orderNewestFirst,
+		Limit:   feedSize,
+	}
+	var entries []*blogItem
+	err := s.store.List(ctx, q, func(data *blogItem) error {
+		entries = append(entries, data)
+		return nil
+	})
+	if err != nil {
+		return nil, storeError(err, blogs)
+	}
+
+	for _, data := range entries {
+		name, ok := names[data.AuthorID]
+		if !ok {
+			name = data.AuthorID
+			if a, err := s.store.ReadAuthor(ctx, data.AuthorID); err == nil {
+				name = authorName(a)
+			}
+			names[data.AuthorID] = name
+		}
+		e := s.feedEntry(data, name)
+		if e.Updated.After(f.Updated) {
+			f.Updated = e.Updated
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f, nil
+}
+
+// feedEntry returns the entry of a published blog in a feed, by the author with the given name.
+func (s *server) feedEntry(data *blogItem, author string) feedEntry {
+	published := data.PublishTime
+	if published.IsZero() {
+		// Blogs from before the statuses were published when created
+		published = data.CreateTime
+	}
+	updated := data.UpdateTime
+	if updated.Before(published) {
+		updated = published
+	}
+	path := data.slug()
+	if path == "" {
+		path = data.ID.Hex()
+	}
+	return feedEntry{
+		ID:        s.tagURI(data),
+		Version:   data.Version,
+		Title:     data.Title,
+		Link:      s.siteURL + "/blogs/" + url.PathEscape(path),
+		Author:    author,
+		Tags:      data.Tags,
+		Published: published,
+		Updated:   updated,
+		Content:   data.Content,
+	}
+}
+
+// tagURI returns an id of data that does not change with its title or slug, as in RFC 4151.
+func (s *server) tagURI(data *blogItem) string {
+	host := "localhost"
+	if u, err := url.Parse(s.siteURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	return "tag:" + host + "," + data.CreateTime.UTC().Format("2006-01-02") + ":blogs/" + data.ID.Hex()
+}
+
+// authorName returns the name of an author to show to readers.
+func authorName(data *authorItem) string {
+	if data.DisplayName != "" {
+		return data.DisplayName
+	}
+	return data.ID
+}
+
+var rssFormat = feedFormat{
+	contentType: "application/rss+xml; charset=utf-8",
+	encode: func(f *feed) interface{} {
+		ch := rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Description,
+			Self:        atomLink{Rel: "self", Type: "application/rss+xml", Href: f.Self},
+		}
+		if !f.Updated.IsZero() {
+			ch.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+		}
+		for _, e := range f.Entries {
+			ch.Items = append(ch.Items, rssItem{
+				Title:       e.Title,
+				Link:        e.Link,
+				Description: renderContent(e.Content).GetHtml(),
+				Creator:     e.Author,
+				Categories:  e.Tags,
+				GUID:        rssGUID{ID: e.ID},
+				PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+			})
+		}
+		return &rss{Version: "2.0", Channel: ch}
+	},
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string `xml:"title"`
+	Link          string `xml:"link"`
+	Description   string `xml:"description"`
+	LastBuildDate string `xml:"lastBuildDate,omitempty"`
+	// Self is the recommended atom:link to the feed itself
+	Self  atomLink  `xml:"http://www.w3.org/2005/Atom link"`
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	// Creator is the dc:creator of the item, as the author element of RSS must be an email address
+	Creator    string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories []string `xml:"category"`
+	GUID       rssGUID  `xml:"guid"`
+	PubDate    string   `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+var atomFormat = feedFormat{
+	contentType: "application/atom+xml; charset=utf-8",
+	encode: func(f *feed) interface{} {
+		updated := f.Updated
+		if updated.IsZero() {
+			// Atom requires a date, even for a feed without entries
+			updated = time.Unix(0, 0)
+		}
+		res := &atomFeed{
+			Title:    f.Title,
+			Subtitle: f.Description,
+			ID:       f.Self,
+			Updated:  updated.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Rel: "alternate", Type: "text/html", Href: f.Link},
+				{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+			},
+		}
+		for _, e := range f.Entries {
+			categories := make([]atomCategory, len(e.Tags))
+			for i, tag := range e.Tags {
+				categories[i] = atomCategory{Term: tag}
+			}
+			res.Entries = append(res.Entries, atomEntry{
+				Title:      e.Title,
+				ID:         e.ID,
+				Link:       atomLink{Rel: "alternate", Type: "text/html", Href: e.Link},
+				Published:  e.Published.UTC().Format(time.RFC3339),
+				Updated:    e.Updated.UTC().Format(time.RFC3339),
+				Author:     atomPerson{Name: e.Author},
+				Categories: categories,
+				Content:    atomText{Type: "html", Body: renderContent(e.Content).GetHtml()},
+			})
+		}
+		return res
+	},
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+)
+
+// getFeed gets path from the HTTP handler of the server of f, with the given request headers.
+func getFeed(f *fixture, path string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	f.http.ServeHTTP(w, r)
+	return w
+}
+
+func TestRSSFeed(t *testing.T) {
+	f := newFixture(t)
+	w := getFeed(f, "/feed.rss", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %v, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/rss+xml; charset=utf-8" {
+		t.Errorf("got content type %q", got)
+	}
+	var feed rss
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	// The draft and the trashed blog are not in the feed
+	items := feed.Channel.Items
+	if len(items) != 1 {
+		t.Fatalf("got %v items, want only the published blog", len(items))
+	}
+	item := items[0]
+	if item.Title != f.blog.GetTitle() || item.Link != defaultSiteURL+"/blogs/"+f.blog.GetSlug() || item.Creator != "alice" {
+		t.Errorf("got item %+v, want the published blog", item)
+	}
+	if _, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil {
+		t.Errorf("got pubDate %q: %v", item.PubDate, err)
+	}
+	if item.Description != renderContent(f.blog.GetContent()).GetHtml() {
+		t.Errorf("got description %q, want the rendered content", item.Description)
+	}
+}
+
+func TestAtomFeedOfAuthor(t *testing.T) {
+	f := newFixture(t)
+	var feed atomFeed
+	w := getFeed(f, "/authors/alice/feed.atom", nil)
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != f.blog.GetTitle() {
+		t.Errorf("got entries %+v, want the published blog of alice", feed.Entries)
+	}
+	if e := feed.Entries[0]; e.Author.Name != "alice" || e.Content.Type != "html" {
+		t.Errorf("got entry %+v", e)
+	}
+	if _, err := time.Parse(time.RFC3339, feed.Updated); err != nil {
+		t.Errorf("got updated %q: %v", feed.Updated, err)
+	}
+
+	feed = atomFeed{}
+	w = getFeed(f, "/authors/bob/feed.atom", nil)
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if w.Code != http.StatusOK || len(feed.Entries) != 0 {
+		t.Errorf("got status %v and entries %+v, want none for bob", w.Code, feed.Entries)
+	}
+
+	if w := getFeed(f, "/authors/nobody/feed.atom", nil); w.Code != http.StatusNotFound {
+		t.Errorf("got status %v for a missing author, want 404", w.Code)
+	}
+	f.store.err = errDown
+	if w := getFeed(f, "/feed.atom", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %v with the store down, want 503", w.Code)
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	f := newFixture(t)
+	first := getFeed(f, "/feed.atom", nil)
+	etag, modified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q, want both", etag, modified)
+	}
+	if w := getFeed(f, "/feed.atom", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("got status %v for the same ETag, want 304", w.Code)
+	}
+	if w := getFeed(f, "/feed.atom", map[string]string{"If-Modified-Since": modified}); w.Code != http.StatusNotModified {
+		t.Errorf("got status %v since the last modification, want 304", w.Code)
+	}
+
+	// If-None-Match takes precedence
+	if w := getFeed(f, "/feed.atom", map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": modified}); w.Code != http.StatusOK {
+		t.Errorf("got status %v for another ETag, want 200", w.Code)
+	}
+
+	// A blog that leaves the feed changes the ETag
+	if _, err := f.blogs.UnpublishBlog(f.as("alice"), &blogpb.UnpublishBlogRequest{BlogId: f.blog.GetId()}); err != nil {
+		t.Fatalf("UnpublishBlog: %v", err)
+	}
+	w := getFeed(f, "/feed.atom", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("got status %v and ETag %q after the change, want 200 and a new ETag", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestFeedLastModifiedMovesForward(t *testing.T) {
+	f := newFixture(t)
+	// The draft is published before the blog is changed, so that the blog is the newest in the feed
+	if _, err := f.blogs.PublishBlog(f.as("alice"), &blogpb.PublishBlogRequest{BlogId: f.draft.GetId()}); err != nil {
+		t.Fatalf("PublishBlog: %v", err)
+	}
+	if err := retitle(f, "Hello again"); err != nil {
+		t.Fatalf("PatchBlog: %v", err)
+	}
+	modified := getFeed(f, "/feed.atom", nil).Header().Get("Last-Modified")
+
+	// Without the newest blog, the blogs of the feed were last updated before, but the feed changed after
+	if _, err := f.blogs.UnpublishBlog(f.as("alice"), &blogpb.UnpublishBlogRequest{BlogId: f.blog.GetId()}); err != nil {
+		t.Fatalf("UnpublishBlog: %v", err)
+	}
+	w := getFeed(f, "/feed.atom", map[string]string{"If-Modified-Since": modified})
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %v since the last modification before the change, want 200", w.Code)
+	}
+	before, _ := http.ParseTime(modified)
+	after, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil || !after.After(before) {
+		t.Errorf("got Last-Modified %q after %q, want a later one", w.Header().Get("Last-Modified"), modified)
+	}
+	if w := getFeed(f, "/feed.atom", map[string]string{"If-Modified-Since": w.Header().Get("Last-Modified")}); w.Code != http.StatusNotModified {
+		t.Errorf("got status %v since the new last modification, want 304", w.Code)
+	}
+}
+
+func TestFeedLinksAreOnTheSite(t *testing.T) {
+	f := newFixture(t)
+	r := httptest.NewRequest(http.MethodGet, "/feed.atom", nil)
+	r.Host = "attacker.example"
+	w := httptest.NewRecorder()
+	f.http.ServeHTTP(w, r)
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := defaultSiteURL + "/feed.atom"
+	if feed.ID != want {
+		t.Errorf("got id %q, want %q", feed.ID, want)
+	}
+	for _, link := range feed.Links {
+		if link.Rel == "self" && link.Href != want {
+			t.Errorf("got self link %q, want %q", link.Href, want)
+		}
+	}
+}
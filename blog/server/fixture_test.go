@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/auth"
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"github.com/andreasatle/grpc-go-course/validate"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMain(m *testing.M) {
+	// Every RPC logs that it was invoked
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+var testSecret = []byte("test secret")
+
+var (
+	// errDown is how MongoDB reports that it cannot be reached.
+	errDown = mongo.CommandError{Message: "connection refused", Labels: []string{"NetworkError"}}
+	// errBroken is an unexpected error of the store.
+	errBroken = errors.New("disk on fire")
+)
+
+// failingStore is a store that fails with err, once it is set. It only fails the calls that
+// the RPCs start with, which is enough for them to fail, and passes the others on to Store.
+type failingStore struct {
+	Store
+	err error
+	// racedSlugs are reported as free by ReadBySlug once, as if another blog took them right after
+	racedSlugs map[string]bool
+}
+
+func (s *failingStore) Read(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.Read(ctx, id)
+}
+
+func (s *failingStore) ReadBySlug(ctx context.Context, slug string) (*blogItem, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.racedSlugs[slug] {
+		delete(s.racedSlugs, slug)
+		return nil, errNotFound
+	}
+	return s.Store.ReadBySlug(ctx, slug)
+}
+
+func (s *failingStore) List(ctx context.Context, q listQuery, fn func(*blogItem) error) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Store.List(ctx, q, fn)
+}
+
+func (s *failingStore) CountTags(ctx context.Context) (map[string]int64, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.CountTags(ctx)
+}
+
+func (s *failingStore) CreateAuthor(ctx context.Context, item *authorItem) (*authorItem, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.CreateAuthor(ctx, item)
+}
+
+func (s *failingStore) ReadAuthor(ctx context.Context, id string) (*authorItem, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.ReadAuthor(ctx, id)
+}
+
+func (s *failingStore) ListAuthors(ctx context.Context, fn func(*authorItem) error) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Store.ListAuthors(ctx, fn)
+}
+
+func (s *failingStore) AttachmentUsage(ctx context.Context, ownerID string) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.Store.AttachmentUsage(ctx, ownerID)
+}
+
+// fixture is a blog server on an in-memory connection, with some data:
+// the authors alice, bob and admin, a published blog of alice with a comment on it,
+// a draft of alice, and a published blog of bob in the trash.
+type fixture struct {
+	store    *failingStore
+	srv      *server
+	blogs    blogpb.BlogServiceClient
+	comments blogpb.CommentServiceClient
+	authors  blogpb.AuthorServiceClient
+	// http serves the feeds and the gateway, as the HTTP listener does
+	http http.Handler
+
+	blog    *blogpb.Blog
+	draft   *blogpb.Blog
+	trashed *blogpb.Blog
+	comment *blogpb.Comment
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{store: &failingStore{Store: newMemoryStore()}}
+	srv := newServer(f.store, nil)
+	blobs, err := newBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("newBlobStore: %v", err)
+	}
+	srv.blobs = blobs
+	f.srv = srv
+
+	lis := bufconn.Listen(1 << 20)
+	authn := newAuthenticator(testSecret)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authn.Unary, validate.Unary),
+		grpc.ChainStreamInterceptor(authn.Stream, validate.Stream),
+	)
+	blogpb.RegisterBlogServiceServer(s, srv)
+	blogpb.RegisterCommentServiceServer(s, &commentServer{srv})
+	blogpb.RegisterAuthorServiceServer(s, &authorServer{srv})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	f.blogs = blogpb.NewBlogServiceClient(conn)
+	f.comments = blogpb.NewCommentServiceClient(conn)
+	f.authors = blogpb.NewAuthorServiceClient(conn)
+	if f.http, err = srv.httpHandler(conn); err != nil {
+		t.Fatalf("httpHandler: %v", err)
+	}
+
+	for _, id := range []string{"alice", "bob", "admin"} {
+		if _, err := f.authors.CreateAuthor(f.as(id), &blogpb.CreateAuthorRequest{Author: &blogpb.Author{Id: id}}); err != nil {
+			t.Fatalf("CreateAuthor: %v", err)
+		}
+	}
+	blog := &blogpb.Blog{Title: "Hello gRPC", Content: "# Intro\n\nStreaming *all* the things", Tags: []string{"go"}, Status: blogpb.Blog_PUBLISHED}
+	created, err := f.blogs.CreateBlog(f.as("alice"), &blogpb.CreateBlogRequest{Blog: blog})
+	if err != nil {
+		t.Fatalf("CreateBlog: %v", err)
+	}
+	f.blog = created.GetBlog()
+	created, err = f.blogs.CreateBlog(f.as("alice"), &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{Title: "Work in progress"}})
+	if err != nil {
+		t.Fatalf("CreateBlog: %v", err)
+	}
+	f.draft = created.GetBlog()
+	created, err = f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{Title: "Old news", Status: blogpb.Blog_PUBLISHED}})
+	if err != nil {
+		t.Fatalf("CreateBlog: %v", err)
+	}
+	f.trashed = created.GetBlog()
+	if _, err := f.blogs.DeleteBlog(f.as("bob"), &blogpb.DeleteBlogRequest{BlogId: f.trashed.GetId()}); err != nil {
+		t.Fatalf("DeleteBlog: %v", err)
+	}
+	comment := &blogpb.Comment{BlogId: f.blog.GetId(), Content: "Nice"}
+	commented, err := f.comments.CreateComment(f.as("bob"), &blogpb.CreateCommentRequest{Comment: comment})
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	f.comment = commented.GetComment()
+	return f
+}
+
+// as returns a context that calls the server as the given subject with the given roles.
+func (f *fixture) as(subject string, roles ...string) context.Context {
+	token, err := auth.NewSigner(testSecret).Sign(auth.Claims{Subject: subject, Roles: roles, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		panic(err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+// asUser returns the context for the calls of the table tests as the given subject with the given roles.
+func asUser(subject string, roles ...string) func(f *fixture) context.Context {
+	return func(f *fixture) context.Context { return f.as(subject, roles...) }
+}
+
+// anonymous is the context for the calls of the table tests without a token.
+func anonymous(f *fixture) context.Context {
+	return context.Background()
+}
+
+// missingID is a well-formed id that no blog, comment or revision has.
+var missingID = primitive.NewObjectID().Hex()
+
+// drain receives the messages of a server stream into m until the stream ends,
+// and returns the error of the stream, or nil if it ended normally.
+func drain(stream grpc.ClientStream, err error, m proto.Message) error {
+	if err != nil {
+		return err
+	}
+	for {
+		err := stream.RecvMsg(m)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// rpcTest is a call of an RPC against a fresh fixture, with the status code it should end with.
+type rpcTest struct {
+	name string
+	// storeErr fails the calls of the store that the RPC starts with, if set, see failingStore
+	storeErr error
+	call     func(f *fixture) error
+	want     codes.Code
+}
+
+func runRPCTests(t *testing.T, tests []rpcTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.store.err = tt.storeErr
+			err := tt.call(f)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("got code %v (%v), want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// maxGatewayBodySize is the size of the largest JSON body of a request, the same as the message limit of gRPC.
+const maxGatewayBodySize = 4 << 20
+
+// gatewayRoute serves an RPC over HTTP with JSON, as bound by its google.api.http annotation.
+type gatewayRoute struct {
+	conn grpc.ClientConnInterface
+	// fullMethod is the gRPC name of the RPC, like "/blog.BlogService/ReadBlog"
+	fullMethod string
+	method     protoreflect.MethodDescriptor
+	// body is the request field that the JSON body is decoded into, "*" for the whole request, or empty for no body
+	body string
+	// params maps the wildcards of the pattern in the mux to the request fields that they set
+	params map[string]string
+}
+
+// dialAddr returns the address for the gateway to dial the gRPC server listening at addr. Listeners on
+// all interfaces, like 0.0.0.0, are dialed on the loopback interface of the same IP version.
+func dialAddr(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || !tcp.IP.IsUnspecified() {
+		return addr.String()
+	}
+	loopback := net.IPv6loopback
+	if tcp.IP.To4() != nil {
+		loopback = net.IPv4(127, 0, 0, 1)
+	}
+	return (&net.TCPAddr{IP: loopback, Port: tcp.Port, Zone: tcp.Zone}).String()
+}
+
+// registerGateway adds to mux the HTTP bindings of the RPCs of service that have a google.api.http
+// annotation, which call the RPCs on conn. Like grpc-gateway, but in the process of the server:
+//
+//   - path parameters like {blog.id} and, unless the body is "*", query parameters set request fields
+//   - the body is decoded from JSON into the field named by the annotation, or the whole request for "*"
+//   - the response is encoded as JSON, and server streams as newline-delimited JSON
+//   - the Authorization header is passed on to the RPC
+//   - errors are a google.rpc.Status as JSON, with the HTTP status of the gRPC code
+func registerGateway(mux *http.ServeMux, conn grpc.ClientConnInterface, service protoreflect.ServiceDescriptor) error {
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		m := methods.Get(i)
+		rule, ok := proto.GetExtension(m.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		if m.IsStreamingClient() {
+			return fmt.Errorf("%v: client streams cannot be served over HTTP", m.FullName())
+		}
+		for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+			verb, template := httpPattern(r)
+			if verb == "" {
+				return fmt.Errorf("%v: unsupported HTTP pattern %v", m.FullName(), r)
+			}
+			pattern, params, err := muxPattern(template)
+			if err != nil {
+				return fmt.Errorf("%v: %v", m.FullName(), err)
+			}
+			route := &gatewayRoute{
+				conn:       conn,
+				fullMethod: fmt.Sprintf("/%v/%v", service.FullName(), m.Name()),
+				method:     m,
+				body:       r.GetBody(),
+				params:     params,
+			}
+			mux.Handle(verb+" "+pattern, route)
+		}
+	}
+	return nil
+}
+
+// httpPattern returns the HTTP method and the path template of rule, or empty strings for custom patterns.
+func httpPattern(rule *annotations.HttpRule) (string, string) {
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch
+	}
+	return "", ""
+}
+
+// muxPattern translates a path template like "/v1/blogs/{blog.id}" into a pattern of http.ServeMux,
+// whose wildcards must be identifiers, and returns the request fields of its wildcards.
+// Only whole segments of literals and single field paths are supported.
+func muxPattern(template string) (string, map[string]string, error) {
+	if !strings.HasPrefix(template, "/") || strings.Contains(template, ":") {
+		return "", nil, fmt.Errorf("unsupported path template %q", template)
+	}
+	params := map[string]string{}
+	segments := strings.Split(template[1:], "/")
+	for i, seg := range segments {
+		if !strings.HasPrefix(seg, "{") {
+			if strings.ContainsAny(seg, "{}*") {
+				return "", nil, fmt.Errorf("unsupported path template %q", template)
+			}
+			continue
+		}
+		field := strings.TrimSuffix(strings.TrimPrefix(seg, "{"), "}")
+		if field == "" || strings.ContainsAny(field, "{}=*") {
+			return "", nil, fmt.Errorf("unsupported path template %q", template)
+		}
+		name := fmt.Sprintf("p%d", i)
+		params[name] = field
+		segments[i] = "{" + name + "}"
+	}
+	return "/" + strings.Join(segments, "/"), params, nil
+}
+
+func (g *gatewayRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Invoked HTTP %v %v...\n", r.Method, r.URL.Path)
+	req, err := g.request(w, r)
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	ctx := r.Context()
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", auth)
+	}
+
+	if !g.method.IsStreamingServer() {
+		res := newMessage(g.method.Output())
+		if err := g.conn.Invoke(ctx, g.fullMethod, req, res); err != nil {
+			writeGatewayError(w, err)
+			return
+		}
+		body, err := protojson.Marshal(res)
+		if err != nil {
+			writeGatewayError(w, status.Errorf(codes.Internal, fmt.Sprintf("Cannot encode response: %v", err)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+		return
+	}
+
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, g.fullMethod)
+	if err == nil {
+		err = stream.SendMsg(req)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	for sent := false; ; sent = true {
+		res := newMessage(g.method.Output())
+		err := stream.RecvMsg(res)
+		if err == io.EOF {
+			if !sent {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(http.StatusOK)
+			}
+			return
+		}
+		if err != nil && !sent {
+			writeGatewayError(w, err)
+			return
+		}
+		if err != nil {
+			// The status line has been sent already, so the error is the last line of the stream
+			line, _ := protojson.Marshal(status.Convert(err).Proto())
+			w.Write([]byte(`{"error":` + string(line) + "}\n"))
+			return
+		}
+		line, err := protojson.Marshal(res)
+		if err != nil {
+			log.Printf("Error encoding stream message: %v\n", err)
+			return
+		}
+		if !sent {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Write(append(line, '\n'))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// request decodes the request of the RPC from r. The returned error is a gRPC status.
+func (g *gatewayRoute) request(w http.ResponseWriter, r *http.Request) (proto.Message, error) {
+	req := newMessage(g.method.Input())
+	msg := req.ProtoReflect()
+	if g.body != "" {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGatewayBodySize))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot read body: %v", err))
+		}
+		target := req
+		if g.body != "*" {
+			fd := msg.Descriptor().Fields().ByName(protoreflect.Name(g.body))
+			if fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return nil, status.Errorf(codes.Internal, fmt.Sprintf("Body is not a message field: %v", g.body))
+			}
+			target = msg.Mutable(fd).Message().Interface()
+		}
+		if err := protojson.Unmarshal(body, target); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse body: %v", err))
+		}
+	}
+	if g.body != "*" {
+		for key, values := range r.URL.Query() {
+			if err := setField(msg, key, values); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Invalid query parameter: %v", err))
+			}
+		}
+	}
+	// The path has the last word, whatever the body and the query say
+	for name, field := range g.params {
+		if err := setField(msg, field, []string{r.PathValue(name)}); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Invalid path parameter: %v", err))
+		}
+	}
+	return req, nil
+}
+
+// newMessage returns a new message of the generated type of md.
+func newMessage(md protoreflect.MessageDescriptor) proto.Message {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		// The generated code registers all the types of the services
+		panic(err)
+	}
+	return mt.New().Interface()
+}
+
+// setField sets the field of msg at path, like "blog.id" or "blog.tags", to values. Field
+// names are the proto names or the JSON names. Repeated fields take every value.
+func setField(msg protoreflect.Message, path string, values []string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fields := msg.Descriptor().Fields()
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			return fmt.Errorf("unknown field %q", path)
+		}
+		if fd.IsMap() {
+			return fmt.Errorf("map field %q cannot be set from a parameter", path)
+		}
+		if i < len(names)-1 {
+			if fd.Message() == nil || fd.IsList() {
+				return fmt.Errorf("%q is not a message field", strings.Join(names[:i+1], "."))
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+		if fd.IsList() {
+			list := msg.Mutable(fd).List()
+			for _, s := range values {
+				v, err := parseValue(fd, s, list.NewElement())
+				if err != nil {
+					return fmt.Errorf("%v: %v", path, err)
+				}
+				list.Append(v)
+			}
+			return nil
+		}
+		if len(values) != 1 {
+			return fmt.Errorf("%v takes one value, not %v", path, len(values))
+		}
+		v, err := parseValue(fd, values[0], msg.NewField(fd))
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+// parseValue parses s as a value of fd. Messages, like timestamps, are parsed from their
+// JSON string form into zero, which is a new value of the field.
+func parseValue(fd protoreflect.FieldDescriptor, s string, zero protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.BytesKind:
+		b, err := base64.URLEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.StdEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if v := fd.Enum().Values().ByName(protoreflect.Name(s)); v != nil {
+			return protoreflect.ValueOfEnum(v.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown value %q of %v", s, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if err := protojson.Unmarshal([]byte(strconv.Quote(s)), zero.Message().Interface()); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return zero, nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %v", fd.Kind())
+}
+
+// writeGatewayError writes err, which is a gRPC status, as the JSON of a google.rpc.Status,
+// with the HTTP status of its code.
+func writeGatewayError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	if st.Code() == codes.Internal || st.Code() == codes.Unknown {
+		log.Printf("Error serving http: %v\n", err)
+	}
+	body, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		writeHTTPError(w, st.Err())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromCode(st.Code()))
+	w.Write(body)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/auth"
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// bearer returns the Authorization header of subject, as f.as puts it in the metadata.
+func bearer(f *fixture, subject string) string {
+	md, _ := metadata.FromOutgoingContext(f.as(subject))
+	return md.Get("authorization")[0]
+}
+
+// call sends a request with body to the HTTP handler of f as subject, anonymous if empty.
+func call(f *fixture, subject, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if subject != "" {
+		r.Header.Set("Authorization", bearer(f, subject))
+	}
+	w := httptest.NewRecorder()
+	f.http.ServeHTTP(w, r)
+	return w
+}
+
+// decode decodes the JSON response of w into m, and fails unless w has the status code want.
+func decode(t *testing.T, w *httptest.ResponseRecorder, want int, m proto.Message) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("got status %v (%v), want %v", w.Code, w.Body, want)
+	}
+	if err := protojson.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("Unmarshal %q: %v", w.Body, err)
+	}
+}
+
+func TestGatewayCRUD(t *testing.T) {
+	f := newFixture(t)
+	var created blogpb.CreateBlogResponse
+	decode(t, call(f, "alice", http.MethodPost, "/v1/blogs", `{"title": "Over HTTP", "content": "No *gRPC* here", "tags": ["http"]}`), http.StatusOK, &created)
+	id := created.GetBlog().GetId()
+	if id == "" || created.GetBlog().GetAuthorId() != "alice" || created.GetBlog().GetStatus() != blogpb.Blog_DRAFT {
+		t.Fatalf("got blog %v, want a draft of alice", created.GetBlog())
+	}
+
+	var read blogpb.ReadBlogResponse
+	decode(t, call(f, "alice", http.MethodGet, "/v1/blogs/"+id+"?render_content=true&includeAuthor=true", ""), http.StatusOK, &read)
+	if read.GetBlog().GetTitle() != "Over HTTP" || read.GetRendered().GetHtml() == "" || read.GetAuthor().GetId() != "alice" {
+		t.Errorf("got %v, want the blog with its author and rendered content", &read)
+	}
+
+	// The id of the path wins over the one in the body
+	var updated blogpb.UpdateBlogResponse
+	body := `{"blog": {"id": "` + missingID + `", "authorId": "alice", "title": "Over HTTP/2", "content": "Still no gRPC"}, "expectedVersion": "1"}`
+	decode(t, call(f, "alice", http.MethodPut, "/v1/blogs/"+id, body), http.StatusOK, &updated)
+	if updated.GetBlog().GetId() != id || updated.GetBlog().GetTitle() != "Over HTTP/2" || updated.GetBlog().GetVersion() != 2 {
+		t.Errorf("got blog %v, want the updated blog", updated.GetBlog())
+	}
+
+	decode(t, call(f, "alice", http.MethodDelete, "/v1/blogs/"+id, ""), http.StatusOK, &blogpb.DeleteBlogResponse{})
+	var st spb.Status
+	decode(t, call(f, "alice", http.MethodGet, "/v1/blogs/"+id, ""), http.StatusNotFound, &st)
+	if codes.Code(st.GetCode()) != codes.NotFound || len(st.GetDetails()) == 0 {
+		t.Errorf("got status %v, want NotFound with details", &st)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, subject, method, path, body string
+		want                              int
+	}{
+		{"anonymous", "", http.MethodPost, "/v1/blogs", `{"title": "Nope"}`, http.StatusUnauthorized},
+		{"bad json", "alice", http.MethodPost, "/v1/blogs", `{"title": `, http.StatusBadRequest},
+		{"unknown field", "alice", http.MethodPost, "/v1/blogs", `{"headline": "Nope"}`, http.StatusBadRequest},
+		{"invalid blog", "alice", http.MethodPost, "/v1/blogs", `{"title": ""}`, http.StatusBadRequest},
+		{"bad id", "", http.MethodGet, "/v1/blogs/nope", "", http.StatusBadRequest},
+		{"missing", "", http.MethodGet, "/v1/blogs/" + missingID, "", http.StatusNotFound},
+		{"unknown query parameter", "", http.MethodGet, "/v1/blogs/" + missingID + "?nope=1", "", http.StatusBadRequest},
+		{"bad query value", "", http.MethodGet, "/v1/blogs?page_size=many", "", http.StatusBadRequest},
+		{"another author", "bob", http.MethodDelete, "/v1/blogs/{blog}", "", http.StatusForbidden},
+		{"wrong method", "alice", http.MethodPatch, "/v1/blogs", "", http.StatusMethodNotAllowed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			w := call(f, tt.subject, tt.method, strings.Replace(tt.path, "{blog}", f.blog.GetId(), 1), tt.body)
+			if w.Code != tt.want {
+				t.Errorf("got status %v (%v), want %v", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestGatewayListBlog(t *testing.T) {
+	f := newFixture(t)
+	for _, title := range []string{"Second", "Third"} {
+		blog := &blogpb.Blog{Title: title, Status: blogpb.Blog_PUBLISHED}
+		if _, err := f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: blog}); err != nil {
+			t.Fatalf("CreateBlog: %v", err)
+		}
+	}
+	list := func(subject, query string) []*blogpb.Blog {
+		t.Helper()
+		w := call(f, subject, http.MethodGet, "/v1/blogs"+query, "")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("got status %v and content type %q (%v)", w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+		var blogs []*blogpb.Blog
+		lines := bufio.NewScanner(bytes.NewReader(w.Body.Bytes()))
+		for lines.Scan() {
+			var res blogpb.ListBlogResponse
+			if err := protojson.Unmarshal(lines.Bytes(), &res); err != nil {
+				t.Fatalf("Unmarshal %q: %v", lines.Text(), err)
+			}
+			blogs = append(blogs, res.GetBlog())
+		}
+		return blogs
+	}
+	titles := func(blogs []*blogpb.Blog) string {
+		var s []string
+		for _, b := range blogs {
+			s = append(s, b.GetTitle())
+		}
+		return strings.Join(s, ", ")
+	}
+
+	if got := titles(list("", "")); got != "Hello gRPC, Second, Third" {
+		t.Errorf("got %v, want the published blogs", got)
+	}
+	if got := titles(list("", "?orderBy=NEWEST_FIRST&page_size=2")); got != "Third, Second" {
+		t.Errorf("got %v, want the two newest", got)
+	}
+	if got := titles(list("", "?author_id=bob&titlePrefix=T")); got != "Third" {
+		t.Errorf("got %v, want the blogs of bob starting with T", got)
+	}
+	after := f.blog.GetCreateTime().AsTime().Add(-time.Second).Format(time.RFC3339Nano)
+	if got := titles(list("alice", "?include_drafts=true&created_after="+after)); got != "Hello gRPC, Work in progress, Second, Third" {
+		t.Errorf("got %v, want the drafts of alice too", got)
+	}
+	if got := list("", "?author_id=nobody"); len(got) != 0 {
+		t.Errorf("got %v, want no blogs", titles(got))
+	}
+
+	// Errors before the first blog have the HTTP status of the error
+	if w := call(f, "", http.MethodGet, "/v1/blogs?include_drafts=true", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v for drafts of nobody, want 401", w.Code)
+	}
+}
+
+func TestMuxPattern(t *testing.T) {
+	pattern, params, err := muxPattern("/v1/blogs/{blog.id}/revisions/{version}")
+	if err != nil || pattern != "/v1/blogs/{p2}/revisions/{p4}" || params["p2"] != "blog.id" || params["p4"] != "version" {
+		t.Errorf("got %q, %v, %v", pattern, params, err)
+	}
+	for _, template := range []string{"v1/blogs", "/v1/{name=blogs/*}", "/v1/blogs/*", "/v1/blogs:search", "/v1/blogs/x{id}"} {
+		if _, _, err := muxPattern(template); err == nil {
+			t.Errorf("%q: got no error for an unsupported template", template)
+		}
+	}
+}
+
+func TestDialAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"0.0.0.0:50051":   "127.0.0.1:50051",
+		"[::]:50051":      "[::1]:50051",
+		"10.0.0.7:50051":  "10.0.0.7:50051",
+		"[fe80::1]:50051": "[fe80::1]:50051",
+	} {
+		tcp, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatalf("ResolveTCPAddr: %v", err)
+		}
+		if got := dialAddr(tcp); got != want {
+			t.Errorf("dialAddr(%v): got %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// The gateway must not let anonymous callers act as admins, whatever the headers say.
+func TestGatewayPassesOnlyTheToken(t *testing.T) {
+	f := newFixture(t)
+	r := httptest.NewRequest(http.MethodDelete, "/v1/blogs/"+f.blog.GetId(), nil)
+	r.Header.Set("X-Roles", auth.AdminRole)
+	w := httptest.NewRecorder()
+	f.http.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v, want 401", w.Code)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpHandler returns the handler of the plain HTTP listener, which serves the
+// clients that do not speak gRPC: the feeds, and the JSON gateway to the RPCs on conn.
+func (s *server) httpHandler(conn grpc.ClientConnInterface) (http.Handler, error) {
+	mux := http.NewServeMux()
+	s.registerFeeds(mux)
+	if err := registerGateway(mux, conn, blogpb.File_blog_blogpb_blog_proto.Services().ByName("BlogService")); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// httpStatusFromCode returns the HTTP status code for a gRPC status code, as mapped
+// by google.rpc.Code.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// Client Closed Request, as used by nginx
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// writeHTTPError writes err, which is a gRPC status, as a plain text HTTP error.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	if st.Code() == codes.Internal || st.Code() == codes.Unknown {
+		log.Printf("Error serving http: %v\n", err)
+	}
+	http.Error(w, st.Message(), httpStatusFromCode(st.Code()))
+}
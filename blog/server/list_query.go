@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// listOrder is the order in which a BlogStore lists blogs.
+// Every order has the blog id as the final tie breaker, so that pages are stable.
+type listOrder int
+
+const (
+	orderOldestFirst listOrder = iota
+	orderNewestFirst
+	orderByTitle
+	orderByAuthor
+)
+
+// blogFilter restricts a listing to blogs matching all of its non-zero fields.
+type blogFilter struct {
+	AuthorID      string
+	TitlePrefix   string
+	TitleContains string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Tag restricts the listing to blogs with this normalized tag.
+	Tag string
+	// AttachmentID restricts the listing to blogs that reference this attachment.
+	AttachmentID primitive.ObjectID
+	// Trashed selects the blogs in the trash instead of the live ones.
+	Trashed bool
+	// DeletedBefore restricts trashed blogs to those deleted before this time.
+	DeletedBefore time.Time
+	// Statuses restricts the listing to blogs with one of these statuses.
+	Statuses []blogStatus
+	// DraftsOf adds the drafts and scheduled blogs of this author to the blogs selected by Statuses.
+	DraftsOf string
+	// PublishedBefore restricts the listing to blogs with a publish time before this time.
+	PublishedBefore time.Time
+}
+
+// listQuery selects a page of blogs from a BlogStore.
+type listQuery struct {
+	Filter  blogFilter
+	OrderBy listOrder
+	// After skips all blogs up to and including the cursor position, unless it is the zero cursor.
+	After pageCursor
+	// Limit is the maximum number of blogs to return, zero means no limit.
+	Limit int
+}
+
+// match reports whether item passes the filter. It is used by the stores
+// that cannot translate the filter into a backend query.
+func (f *blogFilter) match(item *blogItem) bool {
+	if item.trashed() != f.Trashed {
+		return false
+	}
+	if !f.DeletedBefore.IsZero() && !item.DeleteTime.Before(f.DeletedBefore) {
+		return false
+	}
+	if f.AuthorID != "" && item.AuthorID != f.AuthorID {
+		return false
+	}
+	if len(f.Statuses) > 0 && !f.matchStatus(item) {
+		return false
+	}
+	if !f.PublishedBefore.IsZero() && !item.PublishTime.Before(f.PublishedBefore) {
+		return false
+	}
+	if f.Tag != "" && !hasTag(item, f.Tag) {
+		return false
+	}
+	if !f.AttachmentID.IsZero() && !hasAttachment(item, f.AttachmentID) {
+		return false
+	}
+	if f.TitlePrefix != "" && !strings.HasPrefix(item.Title, f.TitlePrefix) {
+		return false
+	}
+	if f.TitleContains != "" && !strings.Contains(strings.ToLower(item.Title), strings.ToLower(f.TitleContains)) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && item.CreateTime.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !item.CreateTime.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// matchStatus reports whether item has one of the statuses of the filter, or is a draft of DraftsOf.
+func (f *blogFilter) matchStatus(item *blogItem) bool {
+	status := item.status()
+	for _, s := range f.Statuses {
+		if status == s {
+			return true
+		}
+	}
+	return f.DraftsOf != "" && item.AuthorID == f.DraftsOf && (status == statusDraft || status == statusScheduled)
+}
+
+// sortKey returns the value of the field that o sorts on, besides the id.
+func (o listOrder) sortKey(item *blogItem) string {
+	switch o {
+	case orderByTitle:
+		return item.Title
+	case orderByAuthor:
+		return item.AuthorID
+	}
+	return ""
+}
+
+// less reports whether a comes before b in the order o.
+func (o listOrder) less(a, b *blogItem) bool {
+	if o == orderNewestFirst {
+		return compareIDs(a.ID, b.ID) > 0
+	}
+	if ka, kb := o.sortKey(a), o.sortKey(b); ka != kb {
+		return ka < kb
+	}
+	return compareIDs(a.ID, b.ID) < 0
+}
+
+// isAfter reports whether item comes after the cursor c in the order o.
+func (o listOrder) isAfter(item *blogItem, c pageCursor) bool {
+	if c.After.IsZero() {
+		return true
+	}
+	pos := &blogItem{ID: c.After}
+	switch o {
+	case orderByTitle:
+		pos.Title = c.Key
+	case orderByAuthor:
+		pos.AuthorID = c.Key
+	}
+	return o.less(pos, item)
+}
+
+// cursor returns the cursor positioned at item in the order o.
+func (o listOrder) cursor(item *blogItem) pageCursor {
+	return pageCursor{After: item.ID, Key: o.sortKey(item)}
+}
+
+// id identifies the filter and order of q, so that a page token cannot be
+// used with a different query than the one it was created for.
+func (q *listQuery) id() string {
+	f := q.Filter
+	h := sha256.New()
+	fmt.Fprintf(h, "%d|%q|%q|%q|%d|%d|%q|%t|%d|%q|%q|%d", q.OrderBy, f.AuthorID, f.TitlePrefix, f.TitleContains,
+		f.CreatedAfter.UnixNano(), f.CreatedBefore.UnixNano(), f.Tag, f.Trashed, f.DeletedBefore.UnixNano(),
+		f.Statuses, f.DraftsOf, f.PublishedBefore.UnixNano())
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12])
+}
+
+func compareIDs(a, b primitive.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newListFixture returns a fixture with more published blogs to list, after the one of the fixture.
+func newListFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := newFixture(t)
+	for _, b := range []struct{ author, title string }{
+		{"bob", "apple pie"},
+		{"bob", "Banana"},
+		{"alice", "Cherry"},
+		{"bob", "Banana"},
+	} {
+		blog := &blogpb.Blog{Title: b.title, Status: blogpb.Blog_PUBLISHED}
+		if _, err := f.blogs.CreateBlog(f.as(b.author), &blogpb.CreateBlogRequest{Blog: blog}); err != nil {
+			t.Fatalf("CreateBlog: %v", err)
+		}
+	}
+	return f
+}
+
+// listAllTitles returns the titles of all blogs that ListBlog lists for req, in pages of two.
+func listAllTitles(t *testing.T, f *fixture, req *blogpb.ListBlogRequest) []string {
+	t.Helper()
+	req.PageSize = 2
+	var all []string
+	for {
+		titles, next, err := listBlogPage(f, req)
+		if err != nil {
+			t.Fatalf("ListBlog(%v): %v", req, err)
+		}
+		all = append(all, titles...)
+		if next == "" {
+			return all
+		}
+		req.PageToken = next
+	}
+}
+
+func TestListBlogFilters(t *testing.T) {
+	f := newListFixture(t)
+	past := timestamppb.New(time.Now().Add(-time.Hour))
+	future := timestamppb.New(time.Now().Add(time.Hour))
+	tests := []struct {
+		name string
+		req  *blogpb.ListBlogRequest
+		want []string
+	}{
+		{"all", &blogpb.ListBlogRequest{}, []string{"Hello gRPC", "apple pie", "Banana", "Cherry", "Banana"}},
+		{"author", &blogpb.ListBlogRequest{AuthorId: "alice"}, []string{"Hello gRPC", "Cherry"}},
+		{"title prefix", &blogpb.ListBlogRequest{TitlePrefix: "Ba"}, []string{"Banana", "Banana"}},
+		{"title prefix is case sensitive", &blogpb.ListBlogRequest{TitlePrefix: "ba"}, nil},
+		{"title contains", &blogpb.ListBlogRequest{TitleContains: "PIE"}, []string{"apple pie"}},
+		{"author and title", &blogpb.ListBlogRequest{AuthorId: "bob", TitleContains: "an"}, []string{"Banana", "Banana"}},
+		{"created before", &blogpb.ListBlogRequest{CreatedBefore: past}, nil},
+		{"created after", &blogpb.ListBlogRequest{CreatedAfter: future}, nil},
+		{"created between", &blogpb.ListBlogRequest{CreatedAfter: past, CreatedBefore: future, AuthorId: "alice"}, []string{"Hello gRPC", "Cherry"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listAllTitles(t, f, tt.req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListBlogCreatedWithinASecond(t *testing.T) {
+	f := newFixture(t)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var times []time.Time
+	for i, title := range []string{"Early", "Late"} {
+		now := start.Add(time.Duration(100+800*i) * time.Millisecond)
+		f.srv.now = func() time.Time { return now }
+		blog := &blogpb.Blog{Title: title, Status: blogpb.Blog_PUBLISHED}
+		if _, err := f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: blog}); err != nil {
+			t.Fatalf("CreateBlog: %v", err)
+		}
+		times = append(times, now)
+	}
+	// The filter compares the stored creation times, which are finer than the seconds in the ids
+	middle := timestamppb.New(start.Add(500 * time.Millisecond))
+	tests := []struct {
+		name string
+		req  *blogpb.ListBlogRequest
+		want []string
+	}{
+		{"created before", &blogpb.ListBlogRequest{AuthorId: "bob", CreatedBefore: middle}, []string{"Early"}},
+		{"created after", &blogpb.ListBlogRequest{AuthorId: "bob", CreatedAfter: middle}, []string{"Late"}},
+		{"created after is inclusive", &blogpb.ListBlogRequest{AuthorId: "bob", CreatedAfter: timestamppb.New(times[1])}, []string{"Late"}},
+		{"created before is exclusive", &blogpb.ListBlogRequest{AuthorId: "bob", CreatedBefore: timestamppb.New(times[1])}, []string{"Early"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listAllTitles(t, f, tt.req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListBlogOrders(t *testing.T) {
+	f := newListFixture(t)
+	tests := []struct {
+		order blogpb.ListBlogRequest_OrderBy
+		want  []string
+	}{
+		{blogpb.ListBlogRequest_OLDEST_FIRST, []string{"Hello gRPC", "apple pie", "Banana", "Cherry", "Banana"}},
+		{blogpb.ListBlogRequest_NEWEST_FIRST, []string{"Banana", "Cherry", "Banana", "apple pie", "Hello gRPC"}},
+		// Titles compare byte by byte, and the pages continue between the blogs with the same title
+		{blogpb.ListBlogRequest_TITLE, []string{"Banana", "Banana", "Cherry", "Hello gRPC", "apple pie"}},
+		{blogpb.ListBlogRequest_AUTHOR, []string{"Hello gRPC", "Cherry", "apple pie", "Banana", "Banana"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order.String(), func(t *testing.T) {
+			if got := listAllTitles(t, f, &blogpb.ListBlogRequest{OrderBy: tt.order}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, _, err := listBlogPage(f, &blogpb.ListBlogRequest{OrderBy: 42})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unknown order: got %v, want InvalidArgument", err)
+	}
+}
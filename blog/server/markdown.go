@@ -0,0 +1,762 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+)
+
+// tocEntry is a heading in the table of contents of a rendered blog.
+type tocEntry struct {
+	Level  int
+	Text   string
+	Anchor string
+}
+
+// renderer renders Markdown to HTML. It supports headings, paragraphs, block quotes,
+// lists, fenced code blocks, thematic breaks, emphasis, code spans, links, images and
+// autolinks. Raw HTML is escaped, so it shows up as text, and links and images only
+// keep URLs with a safe scheme, so the output can be embedded in a page as is.
+type renderer struct {
+	// anchors counts the headings that got each anchor, to keep the anchors unique
+	anchors map[string]int
+	toc     []tocEntry
+	// inLink is set while rendering the text of a link, which cannot contain links
+	inLink bool
+	// depth is the number of nested inline elements being rendered
+	depth int
+}
+
+// maxNesting is the depth of nested inline elements, like images in links in emphasis,
+// beyond which the text is not parsed any further, to bound the rendering time.
+const maxNesting = 16
+
+// renderMarkdown renders src as sanitized HTML, and returns it with the table of contents.
+// Every heading gets an id attribute, which is the anchor of its table of contents entry.
+func renderMarkdown(src string) (string, []tocEntry) {
+	r := &renderer{anchors: map[string]int{}}
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	return r.blocks(strings.Split(src, "\n"), false), r.toc
+}
+
+// blocks renders lines as a sequence of blocks. In a tight list, paragraphs are not wrapped in <p>.
+func (r *renderer) blocks(lines []string, tight bool) string {
+	var out []string
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case fence(line) != "":
+			var s string
+			s, i = r.codeBlock(lines, i)
+			out = append(out, s)
+		case headingLevel(line) > 0:
+			out = append(out, r.heading(line))
+			i++
+		case isThematicBreak(line):
+			out = append(out, "<hr>")
+			i++
+		case isQuote(line):
+			var quoted []string
+			for ; i < len(lines) && isQuote(lines[i]); i++ {
+				quoted = append(quoted, unquote(lines[i]))
+			}
+			out = append(out, "<blockquote>\n"+r.blocks(quoted, false)+"\n</blockquote>")
+		case listItem(line).ok:
+			var s string
+			s, i = r.list(lines, i)
+			out = append(out, s)
+		default:
+			para := []string{strings.TrimSpace(line)}
+			for i++; i < len(lines) && !startsBlock(lines[i]); i++ {
+				para = append(para, strings.TrimLeft(lines[i], " \t"))
+			}
+			text := r.inline(strings.TrimRight(strings.Join(para, "\n"), " \t"))
+			if tight {
+				out = append(out, text)
+			} else {
+				out = append(out, "<p>"+text+"</p>")
+			}
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// startsBlock reports whether line ends a paragraph.
+func startsBlock(line string) bool {
+	return isBlank(line) || fence(line) != "" || headingLevel(line) > 0 ||
+		isThematicBreak(line) || isQuote(line) || listItem(line).ok
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indent returns the width of the leading white space of line, with tabs counting as four spaces.
+func indent(line string) int {
+	n := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// dedent removes up to n columns of leading white space from line.
+func dedent(line string, n int) string {
+	i := 0
+	for col := 0; i < len(line) && col < n; i++ {
+		switch line[i] {
+		case ' ':
+			col++
+		case '\t':
+			col += 4
+		default:
+			return line[i:]
+		}
+	}
+	return line[i:]
+}
+
+// fence returns the code fence that line opens or closes, or "" if it is not a fence.
+func fence(line string) string {
+	if indent(line) > 3 {
+		return ""
+	}
+	s := strings.TrimLeft(line, " ")
+	for _, c := range []string{"`", "~"} {
+		n := len(s) - len(strings.TrimLeft(s, c))
+		if n >= 3 && !(c == "`" && strings.Contains(s[n:], "`")) {
+			return s[:n]
+		}
+	}
+	return ""
+}
+
+func (r *renderer) codeBlock(lines []string, i int) (string, int) {
+	open := fence(lines[i])
+	info := strings.TrimSpace(strings.TrimLeft(lines[i], " ")[len(open):])
+	var code []string
+	for i++; i < len(lines); i++ {
+		if f := fence(lines[i]); f != "" && f[0] == open[0] && len(f) >= len(open) && isBlank(strings.TrimLeft(lines[i], " ")[len(f):]) {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+
+	class := ""
+	if lang := strings.Fields(info); len(lang) > 0 && isLanguage(lang[0]) {
+		class = fmt.Sprintf(` class="language-%s"`, lang[0])
+	}
+	body := html.EscapeString(strings.Join(code, "\n"))
+	if len(code) > 0 {
+		body += "\n"
+	}
+	return fmt.Sprintf("<pre><code%s>%s</code></pre>", class, body), i
+}
+
+// isLanguage reports whether the info string of a code fence is safe to use as a class name.
+func isLanguage(s string) bool {
+	for _, c := range s {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("+-_.#", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// headingLevel returns the level of the ATX heading on line, or 0 if it is not a heading.
+func headingLevel(line string) int {
+	if indent(line) > 3 {
+		return 0
+	}
+	s := strings.TrimLeft(line, " ")
+	n := len(s) - len(strings.TrimLeft(s, "#"))
+	if n < 1 || n > 6 || (len(s) > n && s[n] != ' ' && s[n] != '\t') {
+		return 0
+	}
+	return n
+}
+
+func (r *renderer) heading(line string) string {
+	level := headingLevel(line)
+	text := strings.TrimSpace(strings.TrimLeft(line, " ")[level:])
+	// A closing sequence of #s is not part of the heading
+	if trimmed := strings.TrimRight(text, "#"); trimmed == "" || strings.HasSuffix(trimmed, " ") {
+		text = strings.TrimSpace(trimmed)
+	}
+
+	content := r.inline(text)
+	plain := plainText(content)
+	anchor := r.anchor(plain)
+	r.toc = append(r.toc, tocEntry{Level: level, Text: plain, Anchor: anchor})
+	return fmt.Sprintf(`<h%d id="%s">%s</h%d>`, level, anchor, content, level)
+}
+
+// anchor returns a unique anchor for a heading with the given text, like "getting-started".
+// Later headings with the same text get "getting-started-1", "getting-started-2" and so on.
+func (r *renderer) anchor(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(c)
+		case unicode.IsSpace(c) || c == '-' || c == '_':
+			dash = true
+		}
+	}
+	base := b.String()
+	if base == "" {
+		base = "section"
+	}
+
+	anchor := base
+	for r.anchors[anchor] > 0 {
+		anchor = fmt.Sprintf("%s-%d", base, r.anchors[base])
+		r.anchors[base]++
+	}
+	r.anchors[anchor]++
+	return anchor
+}
+
+// plainText returns the text of rendered HTML, without the tags.
+func plainText(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, c := range s {
+		switch {
+		case c == '<':
+			inTag = true
+		case c == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(c)
+		}
+	}
+	return html.UnescapeString(b.String())
+}
+
+// isThematicBreak reports whether line is a horizontal rule, like "---" or "* * *".
+func isThematicBreak(line string) bool {
+	if indent(line) > 3 {
+		return false
+	}
+	s := strings.Join(strings.Fields(line), "")
+	if len(s) < 3 {
+		return false
+	}
+	for _, c := range []string{"-", "*", "_"} {
+		if strings.Trim(s, c) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isQuote(line string) bool {
+	return indent(line) <= 3 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+// unquote removes the block quote marker from line.
+func unquote(line string) string {
+	s := strings.TrimPrefix(strings.TrimLeft(line, " "), ">")
+	return strings.TrimPrefix(s, " ")
+}
+
+// listMarker is the marker of a list item, like "-" or "3.".
+type listMarker struct {
+	ok      bool
+	ordered bool
+	// delim is the bullet of unordered lists, and the "." or ")" after the number of ordered ones
+	delim byte
+	start int
+	// width is the indentation of the content of the item
+	width int
+	// content is the rest of the line after the marker
+	content string
+}
+
+// listItem returns the list marker at the start of line, if there is one.
+func listItem(line string) listMarker {
+	pad := indent(line)
+	if pad > 3 {
+		return listMarker{}
+	}
+	s := strings.TrimLeft(line, " ")
+	var m listMarker
+	n := 0
+	switch {
+	case s != "" && strings.ContainsRune("-*+", rune(s[0])):
+		m.delim = s[0]
+		n = 1
+	default:
+		for n < len(s) && n < 9 && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		if n == 0 || n == len(s) || (s[n] != '.' && s[n] != ')') {
+			return listMarker{}
+		}
+		m.ordered = true
+		m.delim = s[n]
+		m.start, _ = strconv.Atoi(s[:n])
+		n++
+	}
+	switch {
+	case n == len(s):
+		m.width = pad + n + 1
+	case s[n] == ' ' || s[n] == '\t':
+		spaces := indent(s[n:])
+		if spaces > 4 {
+			// The content is indented code, the item itself starts after one space
+			spaces = 1
+		}
+		m.width = pad + n + spaces
+		m.content = dedent(s[n:], spaces)
+	default:
+		return listMarker{}
+	}
+	m.ok = true
+	return m
+}
+
+func (r *renderer) list(lines []string, i int) (string, int) {
+	first := listItem(lines[i])
+	var items [][]string
+	tight := true
+	blank := false
+	width := 0
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		m := listItem(line)
+		switch {
+		case isBlank(line):
+			blank = true
+			continue
+		case len(items) > 0 && indent(line) >= width:
+			if blank {
+				// Only a blank line between blocks of the item itself makes the list loose,
+				// not one inside a nested list
+				if indent(line) == width {
+					tight = false
+				}
+				items[len(items)-1] = append(items[len(items)-1], "")
+			}
+			items[len(items)-1] = append(items[len(items)-1], dedent(line, width))
+			blank = false
+			continue
+		case m.ok && m.ordered == first.ordered && m.delim == first.delim && !isThematicBreak(line):
+			if blank {
+				tight = false
+			}
+			items = append(items, []string{m.content})
+			width = m.width
+			blank = false
+			continue
+		case !blank && !startsBlock(line):
+			// A lazy continuation of the paragraph in the last item
+			items[len(items)-1] = append(items[len(items)-1], line)
+			continue
+		}
+		break
+	}
+
+	var b strings.Builder
+	switch {
+	case !first.ordered:
+		b.WriteString("<ul>\n")
+	case first.start != 1:
+		fmt.Fprintf(&b, "<ol start=\"%d\">\n", first.start)
+	default:
+		b.WriteString("<ol>\n")
+	}
+	for _, item := range items {
+		b.WriteString("<li>")
+		b.WriteString(r.blocks(item, tight))
+		b.WriteString("</li>\n")
+	}
+	if first.ordered {
+		b.WriteString("</ol>")
+	} else {
+		b.WriteString("</ul>")
+	}
+	return b.String(), i
+}
+
+// inline renders the inline elements of a block of text.
+func (r *renderer) inline(text string) string {
+	if r.depth >= maxNesting {
+		return html.EscapeString(text)
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+
+	t := newInlineText(text)
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(punctuation, text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+			continue
+		case c == '\n':
+			if strings.HasSuffix(text[:i], "  ") {
+				b.WriteString("<br>")
+			}
+		case c == '`':
+			if code, n := t.codeSpan(i); n > 0 {
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n
+				continue
+			}
+			// Not a code span, the whole run of backticks is text
+			n := t.run(i)
+			b.WriteString(text[i : i+n])
+			i += n
+			continue
+		case c == '!' && strings.HasPrefix(text[i+1:], "["):
+			if l, n := parseLink(text[i+1:], t.brackets[i+1]-i-1); n > 0 {
+				alt := plainText(r.inline(l.label))
+				if src, ok := safeURL(l.dest); ok {
+					fmt.Fprintf(&b, `<img src="%s" alt="%s"%s>`, html.EscapeString(src), html.EscapeString(alt), titleAttr(l.title))
+				} else {
+					b.WriteString(html.EscapeString(alt))
+				}
+				i += 1 + n
+				continue
+			}
+		case c == '[' && !r.inLink:
+			if l, n := parseLink(text[i:], t.brackets[i]-i); n > 0 {
+				r.inLink = true
+				label := r.inline(l.label)
+				r.inLink = false
+				if href, ok := safeURL(l.dest); ok {
+					fmt.Fprintf(&b, `<a href="%s"%s rel="nofollow">%s</a>`, html.EscapeString(href), titleAttr(l.title), label)
+				} else {
+					b.WriteString(label)
+				}
+				i += n
+				continue
+			}
+		case c == '<' && !r.inLink:
+			if end := strings.IndexAny(text[i+1:], "<> \n"); end > 0 && text[i+1+end] == '>' {
+				if href, ok := safeURL(text[i+1 : i+1+end]); ok && strings.Contains(href, ":") {
+					fmt.Fprintf(&b, `<a href="%s" rel="nofollow">%s</a>`, html.EscapeString(href), html.EscapeString(text[i+1:i+1+end]))
+					i += end + 2
+					continue
+				}
+			}
+		case c == '*' || c == '_':
+			if s, n := r.emphasis(t, i); n > 0 {
+				b.WriteString(s)
+				i += n
+				continue
+			}
+			// Not emphasis, the whole run of delimiters is text
+			n := t.run(i)
+			b.WriteString(text[i : i+n])
+			i += n
+			continue
+		}
+		b.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// punctuation are the characters that can be escaped with a backslash.
+const punctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// inlineText is a block of text that inline renders, with what is known of its delimiters.
+// A delimiter that is not closed would otherwise be looked for up to the end of the text,
+// for each opening one, which takes quadratic time for text like "[[[[" or "*a *a *a".
+type inlineText struct {
+	text string
+	// brackets has the index of the matching ']' of every '[' in text, and -1 for
+	// the other bytes and the brackets that are not closed
+	brackets []int
+	// unclosed has, for runs of delimiters like "**" or "``", an index of text
+	// from which on there is no closing run
+	unclosed map[string]int
+}
+
+func newInlineText(text string) *inlineText {
+	t := &inlineText{text: text, brackets: make([]int, len(text)), unclosed: map[string]int{}}
+	var open []int
+	for i := 0; i < len(text); i++ {
+		t.brackets[i] = -1
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) {
+				i++
+				t.brackets[i] = -1
+			}
+		case '[':
+			open = append(open, i)
+		case ']':
+			if len(open) > 0 {
+				t.brackets[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return t
+}
+
+// run returns the length of the run of the same delimiter at text[i].
+func (t *inlineText) run(i int) int {
+	s := t.text[i:]
+	return len(s) - len(strings.TrimLeft(s, s[:1]))
+}
+
+// closed reports whether the run of delimiters delim, which ends at text[i], may have a closing run.
+func (t *inlineText) closed(delim string, i int) bool {
+	from, ok := t.unclosed[delim]
+	return !ok || i < from
+}
+
+// codeSpan returns the code span at text[i], as codeSpan does.
+func (t *inlineText) codeSpan(i int) (string, int) {
+	delim := t.text[i : i+t.run(i)]
+	if !t.closed(delim, i+len(delim)) {
+		return "", 0
+	}
+	code, n := codeSpan(t.text[i:])
+	if n == 0 {
+		t.unclosed[delim] = i + len(delim)
+	}
+	return code, n
+}
+
+// codeSpan returns the content of the code span at the start of s, and its length in s.
+// The length is 0 if there is no closing run of backticks.
+func codeSpan(s string) (string, int) {
+	n := len(s) - len(strings.TrimLeft(s, "`"))
+	for i := n; i < len(s); {
+		j := strings.Index(s[i:], s[:n])
+		if j < 0 {
+			return "", 0
+		}
+		j += i
+		end := j + n
+		if end < len(s) && s[end] == '`' || s[j-1] == '`' {
+			// A longer run of backticks
+			i = end + len(s[end:]) - len(strings.TrimLeft(s[end:], "`"))
+			continue
+		}
+		code := strings.ReplaceAll(s[n:j], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+			code = code[1 : len(code)-1]
+		}
+		return code, end
+	}
+	return "", 0
+}
+
+// emphasis renders the emphasis opened by the run of delimiters at text[i], and returns
+// it with the number of bytes of text it consumed. It consumes nothing if the run is not closed.
+func (r *renderer) emphasis(t *inlineText, i int) (string, int) {
+	text := t.text
+	c := text[i]
+	run := t.run(i)
+	if run > 3 || i+run == len(text) || isSpaceByte(text[i+run]) {
+		return "", 0
+	}
+	// Underscores inside words are not emphasis, as in snake_case
+	if c == '_' && i > 0 && isWordByte(text[i-1]) {
+		return "", 0
+	}
+	delim := text[i : i+run]
+	if !t.closed(delim, i+run) {
+		return "", 0
+	}
+
+	for k := i + run; k < len(text); {
+		if text[k] == '`' {
+			if _, n := t.codeSpan(k); n > 0 {
+				k += n
+				continue
+			}
+		}
+		if text[k] != c {
+			k++
+			continue
+		}
+		n := len(text[k:]) - len(strings.TrimLeft(text[k:], string(c)))
+		closes := n == run && !isSpaceByte(text[k-1]) &&
+			!(c == '_' && k+n < len(text) && isWordByte(text[k+n]))
+		if !closes {
+			k += n
+			continue
+		}
+
+		inner := r.inline(text[i+run : k])
+		switch run {
+		case 1:
+			inner = "<em>" + inner + "</em>"
+		case 2:
+			inner = "<strong>" + inner + "</strong>"
+		default:
+			inner = "<em><strong>" + inner + "</strong></em>"
+		}
+		return inner, k + n - i
+	}
+	t.unclosed[delim] = i + run
+	return "", 0
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// link is an inline link or image, like [label](dest "title").
+type link struct {
+	label, dest, title string
+}
+
+// maxParens is the depth of nested parentheses that a link destination may have, as in CommonMark.
+// It keeps the destinations of links that are not closed from being scanned to the end of the text
+// for every one of them.
+const maxParens = 32
+
+// parseLink parses the link at the start of s, whose label ends with the ']' at s[end],
+// and returns it with its length in s. The length is 0 if s does not start with a link.
+func parseLink(s string, end int) (link, int) {
+	var l link
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return l, 0
+	}
+	l.label = s[1:end]
+
+	i := end + 2
+	skipSpaces := func() {
+		for i < len(s) && isSpaceByte(s[i]) {
+			i++
+		}
+	}
+	skipSpaces()
+	if i < len(s) && s[i] == '<' {
+		j := strings.IndexAny(s[i+1:], "<>\n")
+		if j < 0 || s[i+1+j] != '>' {
+			return l, 0
+		}
+		l.dest = s[i+1 : i+1+j]
+		i += j + 2
+	} else {
+		start, parens := i, 0
+		for ; i < len(s) && !isSpaceByte(s[i]); i++ {
+			if s[i] == '(' {
+				parens++
+				if parens > maxParens {
+					return l, 0
+				}
+			} else if s[i] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		l.dest = s[start:i]
+	}
+
+	skipSpaces()
+	if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+		j := strings.IndexByte(s[i+1:], s[i])
+		if j < 0 {
+			return l, 0
+		}
+		l.title = s[i+1 : i+1+j]
+		i += j + 2
+		skipSpaces()
+	}
+	if i >= len(s) || s[i] != ')' {
+		return l, 0
+	}
+	return l, i + 1
+}
+
+func titleAttr(title string) string {
+	if title == "" {
+		return ""
+	}
+	return fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+}
+
+// safeSchemes are the URL schemes that links and images may use.
+// URLs without a scheme are relative, and always safe.
+var safeSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// safeURL returns url without the white space and control characters that browsers
+// ignore, and whether it is safe to link to, unlike for example "javascript:alert(1)".
+func safeURL(url string) (string, bool) {
+	url = strings.Map(func(c rune) rune {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, url)
+	if i := strings.IndexAny(url, ":/?#"); i >= 0 && url[i] == ':' {
+		return url, safeSchemes[strings.ToLower(url[:i])]
+	}
+	return url, true
+}
+
+// tocHTML renders the table of contents as nested lists of links to the headings.
+func tocHTML(toc []tocEntry) string {
+	var b strings.Builder
+	// The levels of the open lists
+	var levels []int
+	for _, e := range toc {
+		for len(levels) > 0 && levels[len(levels)-1] > e.Level {
+			b.WriteString("</li></ul>")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) > 0 && levels[len(levels)-1] == e.Level {
+			b.WriteString("</li>")
+		} else {
+			b.WriteString("<ul>")
+			levels = append(levels, e.Level)
+		}
+		fmt.Fprintf(&b, `<li><a href="#%s">%s</a>`, e.Anchor, html.EscapeString(e.Text))
+	}
+	for range levels {
+		b.WriteString("</li></ul>")
+	}
+	return b.String()
+}
+
+// renderContent renders the Markdown content of a blog for the clients.
+func renderContent(content string) *blogpb.RenderedContent {
+	body, toc := renderMarkdown(content)
+	res := &blogpb.RenderedContent{Html: body, TocHtml: tocHTML(toc)}
+	for _, e := range toc {
+		res.Toc = append(res.Toc, &blogpb.TocEntry{Level: int32(e.Level), Text: e.Text, Anchor: e.Anchor})
+	}
+	return res
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"emphasis", "*em* and **strong** and ***both***", "<p><em>em</em> and <strong>strong</strong> and <em><strong>both</strong></em></p>"},
+		{"nested emphasis", "*a **b** c* _a *b* c_", "<p><em>a <strong>b</strong> c</em> <em>a <em>b</em> c</em></p>"},
+		{"unclosed emphasis", "*not closed and snake_case_name", "<p>*not closed and snake_case_name</p>"},
+		{"code span", "`code *not em*` *em `code*` still*", "<p><code>code *not em*</code> <em>em <code>code*</code> still</em></p>"},
+		{"unclosed code span", "```x`` and `y`", "<p>```x`` and <code>y</code></p>"},
+		{"link", `[link](http://example.com "T") and [nested [a]](b(c)d)`, `<p><a href="http://example.com" title="T" rel="nofollow">link</a> and <a href="b(c)d" rel="nofollow">nested [a]</a></p>`},
+		{"image in link", "[![alt *text*](a.png)](/a)", `<p><a href="/a" rel="nofollow"><img src="a.png" alt="alt text"></a></p>`},
+		{"escapes", `\*not em\* \[not](a link)`, "<p>*not em* [not](a link)</p>"},
+		{"script", "<script>alert(1)</script> & \"quotes\"", "<p>&lt;script&gt;alert(1)&lt;/script&gt; &amp; &#34;quotes&#34;</p>"},
+		{"script in code", "```\n<script>alert(1)</script>\n```", "<pre><code>&lt;script&gt;alert(1)&lt;/script&gt;\n</code></pre>"},
+		{"javascript link", "[click](javascript:alert(1)) [click](JavaScript:alert(1)) [click](java\x01script:alert(1))", "<p>click click click</p>"},
+		{"javascript image", "![bad](javascript:alert(1)) <javascript:alert(1)>", "<p>bad &lt;javascript:alert(1)&gt;</p>"},
+		{"quoted attributes", `[a](/"onmouseover="alert(1) 'x" onclick="alert(2)') ![x" onerror="alert(3)](a.png)`,
+			`<p><a href="/&#34;onmouseover=&#34;alert(1)" title="x&#34; onclick=&#34;alert(2)" rel="nofollow">a</a> <img src="a.png" alt="x&#34; onerror=&#34;alert(3)"></p>`},
+		{"heading", "# Hello *gRPC*", `<h1 id="hello-grpc">Hello <em>gRPC</em></h1>`},
+		{"list", "- one\n- two", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := renderMarkdown(tt.src); got != tt.want {
+				t.Errorf("got\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Delimiters that are not closed must not make the rendering time quadratic in the length of the content.
+func TestRenderMarkdownWorstCase(t *testing.T) {
+	for _, src := range []string{
+		strings.Repeat("*a ", 33000),
+		strings.Repeat("_a ", 33000),
+		strings.Repeat("**a *b ", 15000),
+		strings.Repeat("[", 100000),
+		strings.Repeat("[a", 50000) + strings.Repeat("]", 50000),
+		strings.Repeat("![", 50000) + strings.Repeat("](a)", 50000),
+		strings.Repeat("[a](", 30000),
+		strings.Repeat("[a](<", 25000),
+		strings.Repeat("[a](b \"", 15000),
+		strings.Repeat("`a ``b ", 15000),
+	} {
+		start := time.Now()
+		renderMarkdown(src)
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("rendering %q... took %v", src[:10], d)
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore is a Store that keeps everything in memory.
+// It is used for local development and tests, where no MongoDB is available.
+type memoryStore struct {
+	mu    sync.RWMutex
+	blogs map[primitive.ObjectID]*blogItem
+	// revisions of each blog, oldest first
+	revisions   map[primitive.ObjectID][]revisionItem
+	comments    map[primitive.ObjectID]*commentItem
+	authors     map[string]*authorItem
+	attachments map[primitive.ObjectID]*attachmentItem
+	// slugs maps the current and old slugs of the blogs to their ids
+	slugs map[string]primitive.ObjectID
+	// views maps viewKeys to *counter. The views are the hot path, so they are
+	// counted in sharded counters outside of mu.
+	views sync.Map
+	// reactions are the reactions of the users, with when they were made, and
+	// reactionCounts the counts of each reaction to each blog. Both are guarded by mu,
+	// which the check that a user reacts only once needs anyway.
+	reactions      map[reactionKey]time.Time
+	reactionCounts map[primitive.ObjectID]map[string]int64
+}
+
+// viewKey identifies a view counter of the memory store.
+type viewKey struct {
+	blogID primitive.ObjectID
+	// hour is the Unix hour of the views in the counter, or 0 for the counter of all views
+	hour int64
+}
+
+// reactionKey identifies a reaction of a user to a blog.
+type reactionKey struct {
+	blogID   primitive.ObjectID
+	userID   string
+	reaction string
+}
+
+// newMemoryStore returns an empty in-memory Store.
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		blogs:          map[primitive.ObjectID]*blogItem{},
+		revisions:      map[primitive.ObjectID][]revisionItem{},
+		comments:       map[primitive.ObjectID]*commentItem{},
+		authors:        map[string]*authorItem{},
+		attachments:    map[primitive.ObjectID]*attachmentItem{},
+		slugs:          map[string]primitive.ObjectID{},
+		reactions:      map[reactionKey]time.Time{},
+		reactionCounts: map[primitive.ObjectID]map[string]int64{},
+	}
+}
+
+func (m *memoryStore) Create(ctx context.Context, item *blogItem) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created := *item
+	created.ID = primitive.NewObjectID()
+	created.Version = 1
+	if m.slugTaken(&created) {
+		return nil, errSlugExists
+	}
+	m.blogs[created.ID] = &created
+	m.indexSlugs(nil, &created)
+	res := created
+	return &res, nil
+}
+
+func (m *memoryStore) CreateMany(ctx context.Context, items []*blogItem) ([]*blogItem, []error) {
+	created := make([]*blogItem, len(items))
+	errs := make([]error, len(items))
+	for i, item := range items {
+		created[i], errs[i] = m.Create(ctx, item)
+	}
+	return created, errs
+}
+
+func (m *memoryStore) Insert(ctx context.Context, items []*blogItem) []error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]error, len(items))
+	for i, item := range items {
+		if _, ok := m.blogs[item.ID]; ok {
+			errs[i] = errBlogExists
+			continue
+		}
+		if m.slugTaken(item) {
+			errs[i] = errSlugExists
+			continue
+		}
+		inserted := *item
+		m.blogs[item.ID] = &inserted
+		m.indexSlugs(nil, &inserted)
+	}
+	return errs
+}
+
+func (m *memoryStore) Read(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.blogs[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	res := *data
+	return &res, nil
+}
+
+func (m *memoryStore) ReadBySlug(ctx context.Context, slug string) (*blogItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.slugs[slug]
+	if !ok {
+		return nil, errNotFound
+	}
+	res := *m.blogs[id]
+	return &res, nil
+}
+
+func (m *memoryStore) Update(ctx context.Context, item *blogItem) (*blogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.blogs[item.ID]
+	if !ok {
+		return nil, errNotFound
+	}
+	if stored.Version != item.Version {
+		return nil, errVersionMismatch
+	}
+	if m.slugTaken(item) {
+		return nil, errSlugExists
+	}
+	updated := *item
+	updated.Version++
+	m.blogs[item.ID] = &updated
+	m.indexSlugs(stored, &updated)
+	res := updated
+	return &res, nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, id primitive.ObjectID, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.blogs[id]
+	if !ok {
+		return errNotFound
+	}
+	if version != 0 && stored.Version != version {
+		return errVersionMismatch
+	}
+	delete(m.blogs, id)
+	m.indexSlugs(stored, nil)
+	delete(m.revisions, id)
+	for cid, c := range m.comments {
+		if c.BlogID == id {
+			delete(m.comments, cid)
+		}
+	}
+	m.views.Range(func(key, _ interface{}) bool {
+		if key.(viewKey).blogID == id {
+			m.views.Delete(key)
+		}
+		return true
+	})
+	for key := range m.reactions {
+		if key.blogID == id {
+			delete(m.reactions, key)
+		}
+	}
+	delete(m.reactionCounts, id)
+	return nil
+}
+
+// slugTaken reports whether a slug of item belongs to another blog. m.mu must be held.
+func (m *memoryStore) slugTaken(item *blogItem) bool {
+	for _, slug := range item.Slugs {
+		if id, ok := m.slugs[slug]; ok && id != item.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// indexSlugs replaces the slugs of the blog old with those of the blog new in m.slugs,
+// either may be nil. m.mu must be held for writing.
+func (m *memoryStore) indexSlugs(old, new *blogItem) {
+	if old != nil {
+		for _, slug := range old.Slugs {
+			delete(m.slugs, slug)
+		}
+	}
+	if new != nil {
+		for _, slug := range new.Slugs {
+			m.slugs[slug] = new.ID
+		}
+	}
+}
+
+func (m *memoryStore) List(ctx context.Context, q listQuery, fn func(*blogItem) error) error {
+	// Take a snapshot, so that fn may call back into the store.
+	m.mu.RLock()
+	items := make([]blogItem, 0, len(m.blogs))
+	for _, data := range m.blogs {
+		if !q.Filter.match(data) || !q.OrderBy.isAfter(data, q.After) {
+			continue
+		}
+		items = append(items, *data)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return q.OrderBy.less(&items[i], &items[j])
+	})
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+	for i := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) CountTags(ctx context.Context) (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, data := range m.blogs {
+		if data.trashed() || data.status() != statusPublished {
+			continue
+		}
+		for _, tag := range data.Tags {
+			counts[tag]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *memoryStore) AddRevision(ctx context.Context, rev *revisionItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := *rev
+	added.ID = primitive.NewObjectID()
+	revs := m.revisions[rev.BlogID]
+	// Keep the revisions sorted, even if two edits record them out of order
+	i := sort.Search(len(revs), func(i int) bool { return revs[i].Version >= rev.Version })
+	if i < len(revs) && revs[i].Version == rev.Version {
+		return fmt.Errorf("duplicate revision %v of blog %v", rev.Version, rev.BlogID.Hex())
+	}
+	revs = append(revs, revisionItem{})
+	copy(revs[i+1:], revs[i:])
+	revs[i] = added
+	m.revisions[rev.BlogID] = revs
+	return nil
+}
+
+func (m *memoryStore) ReadRevision(ctx context.Context, blogID primitive.ObjectID, version int64) (*revisionItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rev := range m.revisions[blogID] {
+		if rev.Version == version {
+			res := rev
+			return &res, nil
+		}
+	}
+	return nil, errRevisionNotFound
+}
+
+func (m *memoryStore) ListRevisions(ctx context.Context, blogID primitive.ObjectID, fn func(*revisionItem) error) error {
+	m.mu.RLock()
+	revs := append([]revisionItem(nil), m.revisions[blogID]...)
+	m.mu.RUnlock()
+
+	for i := range revs {
+		if err := fn(&revs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) CreateComment(ctx context.Context, item *commentItem) (*commentItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created := *item
+	created.ID = primitive.NewObjectID()
+	m.comments[created.ID] = &created
+	res := created
+	return &res, nil
+}
+
+func (m *memoryStore) InsertComment(ctx context.Context, item *commentItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.comments[item.ID]; ok {
+		return errCommentExists
+	}
+	inserted := *item
+	m.comments[item.ID] = &inserted
+	return nil
+}
+
+func (m *memoryStore) ReadComment(ctx context.Context, id primitive.ObjectID) (*commentItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.comments[id]
+	if !ok {
+		return nil, errCommentNotFound
+	}
+	res := *c
+	return &res, nil
+}
+
+func (m *memoryStore) UpdateComment(ctx context.Context, item *commentItem) (*commentItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.comments[item.ID]; !ok {
+		return nil, errCommentNotFound
+	}
+	updated := *item
+	m.comments[item.ID] = &updated
+	res := updated
+	return &res, nil
+}
+
+func (m *memoryStore) DeleteComments(ctx context.Context, ids []primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.comments, id)
+	}
+	return nil
+}
+
+func (m *memoryStore) ListComments(ctx context.Context, blogID primitive.ObjectID, fn func(*commentItem) error) error {
+	m.mu.RLock()
+	var items []commentItem
+	for _, c := range m.comments {
+		if c.BlogID == blogID {
+			items = append(items, *c)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return compareIDs(items[i].ID, items[j].ID) < 0
+	})
+	for i := range items {
+		if err := fn(&items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) CreateAuthor(ctx context.Context, item *authorItem) (*authorItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.authors[item.ID]; ok {
+		return nil, errAuthorExists
+	}
+	created := *item
+	m.authors[item.ID] = &created
+	res := created
+	return &res, nil
+}
+
+func (m *memoryStore) ReadAuthor(ctx context.Context, id string) (*authorItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.authors[id]
+	if !ok {
+		return nil, errAuthorNotFound
+	}
+	res := *a
+	return &res, nil
+}
+
+func (m *memoryStore) UpdateAuthor(ctx context.Context, item *authorItem) (*authorItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.authors[item.ID]; !ok {
+		return nil, errAuthorNotFound
+	}
+	updated := *item
+	m.authors[item.ID] = &updated
+	res := updated
+	return &res, nil
+}
+
+func (m *memoryStore) ListAuthors(ctx context.Context, fn func(*authorItem) error) error {
+	m.mu.RLock()
+	items := make([]authorItem, 0, len(m.authors))
+	for _, a := range m.authors {
+		items = append(items, *a)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	for i := range items {
+		if err := fn(&items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) CreateAttachment(ctx context.Context, item *attachmentItem) (*attachmentItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created := *item
+	created.ID = primitive.NewObjectID()
+	m.attachments[created.ID] = &created
+	res := created
+	return &res, nil
+}
+
+func (m *memoryStore) ReadAttachment(ctx context.Context, id primitive.ObjectID) (*attachmentItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.attachments[id]
+	if !ok {
+		return nil, errAttachmentNotFound
+	}
+	res := *a
+	return &res, nil
+}
+
+func (m *memoryStore) FindAttachment(ctx context.Context, ownerID, sha256, contentType string) (*attachmentItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, a := range m.attachments {
+		if a.OwnerID == ownerID && a.SHA256 == sha256 && a.ContentType == contentType {
+			res := *a
+			return &res, nil
+		}
+	}
+	return nil, errAttachmentNotFound
+}
+
+func (m *memoryStore) AttachmentUsage(ctx context.Context, ownerID string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var usage int64
+	for _, a := range m.attachments {
+		if a.OwnerID == ownerID {
+			usage += a.Size
+		}
+	}
+	return usage, nil
+}
+
+// viewCounter returns the view counter with the given key, which is created if needed.
+func (m *memoryStore) viewCounter(key viewKey) *counter {
+	if c, ok := m.views.Load(key); ok {
+		return c.(*counter)
+	}
+	c, _ := m.views.LoadOrStore(key, &counter{})
+	return c.(*counter)
+}
+
+func (m *memoryStore) AddView(ctx context.Context, blogID primitive.ObjectID, t time.Time) (int64, error) {
+	m.viewCounter(viewKey{blogID: blogID, hour: unixHour(t)}).Add(1)
+	total := m.viewCounter(viewKey{blogID: blogID})
+	total.Add(1)
+	return total.Value(), nil
+}
+
+// unixHour returns the number of whole hours from the Unix epoch until t.
+func unixHour(t time.Time) int64 {
+	return t.Unix() / 3600
+}
+
+func (m *memoryStore) AddReaction(ctx context.Context, item *reactionItem) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := reactionKey{blogID: item.BlogID, userID: item.UserID, reaction: item.Reaction}
+	if _, ok := m.reactions[key]; ok {
+		return false, nil
+	}
+	m.reactions[key] = item.CreateTime
+	counts := m.reactionCounts[item.BlogID]
+	if counts == nil {
+		counts = map[string]int64{}
+		m.reactionCounts[item.BlogID] = counts
+	}
+	counts[item.Reaction]++
+	return true, nil
+}
+
+func (m *memoryStore) RemoveReaction(ctx context.Context, blogID primitive.ObjectID, userID, reaction string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := reactionKey{blogID: blogID, userID: userID, reaction: reaction}
+	if _, ok := m.reactions[key]; !ok {
+		return false, nil
+	}
+	delete(m.reactions, key)
+	counts := m.reactionCounts[blogID]
+	if counts[reaction]--; counts[reaction] == 0 {
+		delete(counts, reaction)
+	}
+	return true, nil
+}
+
+func (m *memoryStore) ReadCounts(ctx context.Context, blogID primitive.ObjectID) (*countsItem, error) {
+	res := &countsItem{}
+	if c, ok := m.views.Load(viewKey{blogID: blogID}); ok {
+		res.Views = c.(*counter).Value()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if counts := m.reactionCounts[blogID]; len(counts) > 0 {
+		res.Reactions = map[string]int64{}
+		for reaction, n := range counts {
+			res.Reactions[reaction] = n
+		}
+	}
+	return res, nil
+}
+
+func (m *memoryStore) TopBlogs(ctx context.Context, metric engagementMetric, start, end time.Time, fn func(blogCount) error) error {
+	counts := map[primitive.ObjectID]int64{}
+	switch metric {
+	case metricViews:
+		first, last := unixHour(firstHourIn(start)), unixHour(firstHourIn(end))
+		m.views.Range(func(k, c interface{}) bool {
+			key := k.(viewKey)
+			if key.hour != 0 && key.hour >= first && key.hour < last {
+				counts[key.blogID] += c.(*counter).Value()
+			}
+			return true
+		})
+	case metricReactions:
+		m.mu.RLock()
+		for key, t := range m.reactions {
+			if !t.Before(start) && t.Before(end) {
+				counts[key.blogID]++
+			}
+		}
+		m.mu.RUnlock()
+	default:
+		return fmt.Errorf("unknown metric: %v", metric)
+	}
+
+	top := make([]blogCount, 0, len(counts))
+	for id, n := range counts {
+		if n > 0 {
+			top = append(top, blogCount{BlogID: id, Count: n})
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return compareIDs(top[i].BlogID, top[j].BlogID) < 0
+	})
+	for _, bc := range top {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryStoreBlogs(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryStore()
+
+	created, err := m.Create(ctx, &blogItem{AuthorID: "alice", Title: "First", Slugs: []string{"first"}, Version: 7})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if created.ID.IsZero() || created.Version != 1 {
+		t.Errorf("created %+v, want a new id and version 1", created)
+	}
+	if _, err := m.Create(ctx, &blogItem{AuthorID: "bob", Title: "First", Slugs: []string{"first"}}); err != errSlugExists {
+		t.Errorf("Create with a taken slug: got %v, want errSlugExists", err)
+	}
+
+	read, err := m.Read(ctx, created.ID)
+	if err != nil || !reflect.DeepEqual(read, created) {
+		t.Errorf("Read: got %+v, %v, want %+v", read, err, created)
+	}
+	// The store keeps its own copy
+	read.Title = "Changed"
+	if again, _ := m.Read(ctx, created.ID); again.Title != "First" {
+		t.Errorf("Read after changing the result: got title %q, want First", again.Title)
+	}
+
+	edit := *created
+	edit.Title = "Second"
+	edit.Slugs = []string{"first", "second"}
+	updated, err := m.Update(ctx, &edit)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.Version != 2 || updated.Title != "Second" {
+		t.Errorf("updated %+v, want version 2 and the new title", updated)
+	}
+	if _, err := m.Update(ctx, &edit); err != errVersionMismatch {
+		t.Errorf("Update of an old version: got %v, want errVersionMismatch", err)
+	}
+	bySlug, err := m.ReadBySlug(ctx, "first")
+	if err != nil || bySlug.ID != created.ID {
+		t.Errorf("ReadBySlug of the old slug: got %+v, %v, want the blog", bySlug, err)
+	}
+
+	if err := m.AddRevision(ctx, &revisionItem{BlogID: created.ID, Version: 2}); err != nil {
+		t.Fatalf("AddRevision: %v", err)
+	}
+	if _, err := m.CreateComment(ctx, &commentItem{BlogID: created.ID, AuthorID: "bob"}); err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	if err := m.Delete(ctx, created.ID, 1); err != errVersionMismatch {
+		t.Errorf("Delete of an old version: got %v, want errVersionMismatch", err)
+	}
+	if err := m.Delete(ctx, created.ID, 2); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := m.Read(ctx, created.ID); err != errNotFound {
+		t.Errorf("Read after Delete: got %v, want errNotFound", err)
+	}
+	if _, err := m.ReadBySlug(ctx, "second"); err != errNotFound {
+		t.Errorf("ReadBySlug after Delete: got %v, want errNotFound", err)
+	}
+	if _, err := m.Update(ctx, &edit); err != errNotFound {
+		t.Errorf("Update after Delete: got %v, want errNotFound", err)
+	}
+	if _, err := m.ReadRevision(ctx, created.ID, 2); err != errRevisionNotFound {
+		t.Errorf("ReadRevision after Delete: got %v, want errRevisionNotFound", err)
+	}
+	n := 0
+	m.ListComments(ctx, created.ID, func(*commentItem) error { n++; return nil })
+	if n != 0 {
+		t.Errorf("got %v comments after Delete, want none", n)
+	}
+	if err := m.Delete(ctx, created.ID, 0); err != errNotFound {
+		t.Errorf("Delete twice: got %v, want errNotFound", err)
+	}
+}
+
+func TestMemoryStoreListInIDOrder(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryStore()
+	var ids []primitive.ObjectID
+	for _, title := range []string{"a", "b", "c"} {
+		created, err := m.Create(ctx, &blogItem{Title: title})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		ids = append(ids, created.ID)
+	}
+	tests := []struct {
+		name string
+		q    listQuery
+		want []primitive.ObjectID
+	}{
+		{"all", listQuery{}, ids},
+		{"after", listQuery{After: pageCursor{After: ids[0]}}, ids[1:]},
+		{"limit", listQuery{Limit: 2}, ids[:2]},
+		{"after the last", listQuery{After: pageCursor{After: ids[2]}}, nil},
+	}
+	for _, tt := range tests {
+		var got []primitive.ObjectID
+		err := m.List(ctx, tt.q, func(data *blogItem) error {
+			got = append(got, data.ID)
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("List %v: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreInsert(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryStore()
+	id := primitive.NewObjectID()
+	errs := m.Insert(ctx, []*blogItem{
+		{ID: id, AuthorID: "alice", Version: 5, Slugs: []string{"kept"}},
+		{ID: id, AuthorID: "alice", Slugs: []string{"other"}},
+		{ID: primitive.NewObjectID(), AuthorID: "bob", Slugs: []string{"kept"}},
+	})
+	if want := []error{nil, errBlogExists, errSlugExists}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("Insert: got %v, want %v", errs, want)
+	}
+	// Inserted blogs keep their id and version
+	if data, err := m.Read(ctx, id); err != nil || data.Version != 5 {
+		t.Errorf("Read: got %+v, %v, want version 5", data, err)
+	}
+}
+
+func TestMemoryStoreRevisionsInOrder(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryStore()
+	blogID := primitive.NewObjectID()
+	for _, version := range []int64{2, 3, 1} {
+		if err := m.AddRevision(ctx, &revisionItem{BlogID: blogID, Version: version}); err != nil {
+			t.Fatalf("AddRevision: %v", err)
+		}
+	}
+	if err := m.AddRevision(ctx, &revisionItem{BlogID: blogID, Version: 2}); err == nil {
+		t.Errorf("AddRevision of a duplicate version succeeded")
+	}
+	var versions []int64
+	m.ListRevisions(ctx, blogID, func(rev *revisionItem) error {
+		versions = append(versions, rev.Version)
+		return nil
+	})
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("got revisions %v, want %v", versions, want)
+	}
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore is a Store backed by MongoDB collections.
+type mongoStore struct {
+	collection  *mongo.Collection
+	revisions   *mongo.Collection
+	comments    *mongo.Collection
+	authors     *mongo.Collection
+	attachments *mongo.Collection
+	// counters has the counts of each blog, views the views of each blog by the hour,
+	// and reactions the reactions of the users
+	counters  *mongo.Collection
+	views     *mongo.Collection
+	reactions *mongo.Collection
+}
+
+// slugIndex is the name of the unique index on the slugs of the blogs.
+const slugIndex = "slugs_unique"
+
+// newMongoStore returns a Store using collections in db, and creates the indexes it needs.
+func newMongoStore(ctx context.Context, db *mongo.Database) (*mongoStore, error) {
+	m := &mongoStore{
+		collection:  db.Collection("blog"),
+		revisions:   db.Collection("blog_revisions"),
+		comments:    db.Collection("blog_comments"),
+		authors:     db.Collection("authors"),
+		attachments: db.Collection("blog_attachments"),
+		counters:    db.Collection("blog_counters"),
+		views:       db.Collection("blog_views"),
+		reactions:   db.Collection("blog_reactions"),
+	}
+	_, err := m.revisions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tags", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Blogs from before the slugs have none, and must not collide on a missing field
+	_, err = m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "slugs", Value: 1}},
+		Options: options.Index().SetName(slugIndex).SetUnique(true).
+			SetPartialFilterExpression(bson.M{"slugs": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.comments.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.attachments.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "sha256", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.views.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "hour", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "hour", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A user reacts at most once with each reaction
+	_, err = m.reactions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "reaction", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "create_time", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *mongoStore) Create(ctx context.Context, item *blogItem) (*blogItem, error) {
+	created := *item
+	created.Version = 1
+	mongoRes, err := m.collection.InsertOne(ctx, &created)
+	if slugConflict(err) {
+		return nil, errSlugExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that we retrieve an id
+	oid, ok := mongoRes.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, fmt.Errorf("error converting oid")
+	}
+	created.ID = oid
+	return &created, nil
+}
+
+func (m *mongoStore) CreateMany(ctx context.Context, items []*blogItem) ([]*blogItem, []error) {
+	created := make([]*blogItem, len(items))
+	errs := make([]error, len(items))
+	docs := make([]interface{}, len(items))
+	for i, item := range items {
+		// The ids are assigned here, so that they are known for the blogs that fail
+		c := *item
+		c.ID = primitive.NewObjectID()
+		c.Version = 1
+		created[i] = &c
+		docs[i] = &c
+	}
+
+	// Unordered, so that one failed blog does not stop the rest of the batch
+	_, err := m.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	switch {
+	case err == nil:
+	case errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil:
+		for _, we := range bulkErr.WriteErrors {
+			created[we.Index] = nil
+			errs[we.Index] = we
+			if slugConflict(we) {
+				errs[we.Index] = errSlugExists
+			}
+		}
+	default:
+		// We cannot tell which blogs made it
+		for i := range created {
+			created[i] = nil
+			errs[i] = err
+		}
+	}
+	return created, errs
+}
+
+func (m *mongoStore) Insert(ctx context.Context, items []*blogItem) []error {
+	errs := make([]error, len(items))
+	docs := make([]interface{}, len(items))
+	for i, item := range items {
+		docs[i] = item
+	}
+
+	_, err := m.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	switch {
+	case err == nil:
+	case errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil:
+		for _, we := range bulkErr.WriteErrors {
+			switch {
+			case slugConflict(we):
+				errs[we.Index] = errSlugExists
+			case mongo.IsDuplicateKeyError(we):
+				errs[we.Index] = errBlogExists
+			default:
+				errs[we.Index] = we
+			}
+		}
+	default:
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func (m *mongoStore) Read(ctx context.Context, id primitive.ObjectID) (*blogItem, error) {
+	data := &blogItem{}
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(data)
+	if err == mongo.ErrNoDocuments {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mongoStore) ReadBySlug(ctx context.Context, slug string) (*blogItem, error) {
+	data := &blogItem{}
+	err := m.collection.FindOne(ctx, bson.M{"slugs": slug}).Decode(data)
+	if err == mongo.ErrNoDocuments {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// slugConflict reports whether err is a violation of the unique index on the slugs.
+func slugConflict(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), slugIndex)
+}
+
+func (m *mongoStore) Update(ctx context.Context, item *blogItem) (*blogItem, error) {
+	updated := *item
+	updated.Version++
+	res, err := m.collection.ReplaceOne(ctx, versionFilter(item.ID, item.Version), &updated)
+	if slugConflict(err) {
+		return nil, errSlugExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, m.missingOrMismatch(ctx, item.ID)
+	}
+	return &updated, nil
+}
+
+func (m *mongoStore) Delete(ctx context.Context, id primitive.ObjectID, version int64) error {
+	filter := bson.M{"_id": id}
+	if version != 0 {
+		filter = versionFilter(id, version)
+	}
+	res, err := m.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return m.missingOrMismatch(ctx, id)
+	}
+	_, err = m.revisions.DeleteMany(ctx, bson.M{"blog_id": id})
+	if err != nil {
+		return err
+	}
+	_, err = m.comments.DeleteMany(ctx, bson.M{"blog_id": id})
+	if err != nil {
+		return err
+	}
+	for _, c := range []*mongo.Collection{m.views, m.reactions} {
+		if _, err := c.DeleteMany(ctx, bson.M{"blog_id": id}); err != nil {
+			return err
+		}
+	}
+	_, err = m.counters.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// missingOrMismatch tells why a write filtered on id and version did not match any blog.
+func (m *mongoStore) missingOrMismatch(ctx context.Context, id primitive.ObjectID) error {
+	n, err := m.collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotFound
+	}
+	return errVersionMismatch
+}
+
+// versionFilter matches the blog with the given id and version.
+// Blogs written before versions were introduced have no version field, and count as version 0.
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "$or": bson.A{
+			bson.M{"version": 0},
+			bson.M{"version": bson.M{"$exists": false}},
+		}}
+	}
+	return bson.M{"_id": id, "version": version}
+}
+
+func (m *mongoStore) List(ctx context.Context, q listQuery, fn func(*blogItem) error) error {
+	filter := mongoFilter(q)
+	opts := options.Find().SetSort(mongoSort(q.OrderBy))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := m.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		data := &blogItem{}
+		if err := cursor.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m *mongoStore) CountTags(ctx context.Context) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"delete_time": bson.M{"$exists": false}, "status": mongoStatuses(statusPublished)}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := m.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	counts := map[string]int64{}
+	for cursor.Next(ctx) {
+		var row struct {
+			Tag   string `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		counts[row.Tag] = row.Count
+	}
+	return counts, cursor.Err()
+}
+
+func (m *mongoStore) AddRevision(ctx context.Context, rev *revisionItem) error {
+	_, err := m.revisions.InsertOne(ctx, rev)
+	return err
+}
+
+func (m *mongoStore) ReadRevision(ctx context.Context, blogID primitive.ObjectID, version int64) (*revisionItem, error) {
+	rev := &revisionItem{}
+	err := m.revisions.FindOne(ctx, bson.M{"blog_id": blogID, "version": version}).Decode(rev)
+	if err == mongo.ErrNoDocuments {
+		return nil, errRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+func (m *mongoStore) ListRevisions(ctx context.Context, blogID primitive.ObjectID, fn func(*revisionItem) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	cursor, err := m.revisions.Find(ctx, bson.M{"blog_id": blogID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		rev := &revisionItem{}
+		if err := cursor.Decode(rev); err != nil {
+			return err
+		}
+		if err := fn(rev); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m *mongoStore) CreateComment(ctx context.Context, item *commentItem) (*commentItem, error) {
+	created := *item
+	created.ID = primitive.NewObjectID()
+	if _, err := m.comments.InsertOne(ctx, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (m *mongoStore) InsertComment(ctx context.Context, item *commentItem) error {
+	_, err := m.comments.InsertOne(ctx, item)
+	if mongo.IsDuplicateKeyError(err) {
+		return errCommentExists
+	}
+	return err
+}
+
+func (m *mongoStore) ReadComment(ctx context.Context, id primitive.ObjectID) (*commentItem, error) {
+	c := &commentItem{}
+	err := m.comments.FindOne(ctx, bson.M{"_id": id}).Decode(c)
+	if err == mongo.ErrNoDocuments {
+		return nil, errCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *mongoStore) UpdateComment(ctx context.Context, item *commentItem) (*commentItem, error) {
+	res, err := m.comments.ReplaceOne(ctx, bson.M{"_id": item.ID}, item)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, errCommentNotFound
+	}
+	return item, nil
+}
+
+func (m *mongoStore) DeleteComments(ctx context.Context, ids []primitive.ObjectID) error {
+	_, err := m.comments.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (m *mongoStore) ListComments(ctx context.Context, blogID primitive.ObjectID, fn func(*commentItem) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.comments.Find(ctx, bson.M{"blog_id": blogID}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		c := &commentItem{}
+		if err := cursor.Decode(c); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m *mongoStore) CreateAuthor(ctx context.Context, item *authorItem) (*authorItem, error) {
+	_, err := m.authors.InsertOne(ctx, item)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errAuthorExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (m *mongoStore) ReadAuthor(ctx context.Context, id string) (*authorItem, error) {
+	a := &authorItem{}
+	err := m.authors.FindOne(ctx, bson.M{"_id": id}).Decode(a)
+	if err == mongo.ErrNoDocuments {
+		return nil, errAuthorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (m *mongoStore) UpdateAuthor(ctx context.Context, item *authorItem) (*authorItem, error) {
+	res, err := m.authors.ReplaceOne(ctx, bson.M{"_id": item.ID}, item)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, errAuthorNotFound
+	}
+	return item, nil
+}
+
+func (m *mongoStore) ListAuthors(ctx context.Context, fn func(*authorItem) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.authors.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		a := &authorItem{}
+		if err := cursor.Decode(a); err != nil {
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// mongoFilter translates the filter and cursor of q into a MongoDB query.
+func mongoFilter(q listQuery) bson.M {
+	conds := bson.A{}
+	f := q.Filter
+	conds = append(conds, bson.M{"delete_time": bson.M{"$exists": f.Trashed}})
+	if !f.DeletedBefore.IsZero() {
+		conds = append(conds, bson.M{"delete_time": bson.M{"$lt": f.DeletedBefore}})
+	}
+	if f.AuthorID != "" {
+		conds = append(conds, bson.M{"author_id": f.AuthorID})
+	}
+	if f.Tag != "" {
+		conds = append(conds, bson.M{"tags": f.Tag})
+	}
+	if !f.AttachmentID.IsZero() {
+		conds = append(conds, bson.M{"attachment_ids": f.AttachmentID})
+	}
+	if len(f.Statuses) > 0 {
+		cond := bson.M{"status": mongoStatuses(f.Statuses...)}
+		if f.DraftsOf != "" {
+			drafts := bson.M{"author_id": f.DraftsOf, "status": mongoStatuses(statusDraft, statusScheduled)}
+			cond = bson.M{"$or": bson.A{cond, drafts}}
+		}
+		conds = append(conds, cond)
+	}
+	if !f.PublishedBefore.IsZero() {
+		conds = append(conds, bson.M{"publish_time": bson.M{"$lt": f.PublishedBefore}})
+	}
+	if f.TitlePrefix != "" {
+		conds = append(conds, bson.M{"title": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(f.TitlePrefix)}})
+	}
+	if f.TitleContains != "" {
+		conds = append(conds, bson.M{"title": primitive.Regex{Pattern: regexp.QuoteMeta(f.TitleContains), Options: "i"}})
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, bson.M{"create_time": bson.M{"$gte": f.CreatedAfter}})
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, bson.M{"create_time": bson.M{"$lt": f.CreatedBefore}})
+	}
+
+	if c := q.After; !c.After.IsZero() {
+		switch q.OrderBy {
+		case orderNewestFirst:
+			conds = append(conds, bson.M{"_id": bson.M{"$lt": c.After}})
+		case orderByTitle, orderByAuthor:
+			field := mongoSortField(q.OrderBy)
+			conds = append(conds, bson.M{"$or": bson.A{
+				bson.M{field: bson.M{"$gt": c.Key}},
+				bson.M{field: c.Key, "_id": bson.M{"$gt": c.After}},
+			}})
+		default:
+			conds = append(conds, bson.M{"_id": bson.M{"$gt": c.After}})
+		}
+	}
+
+	return bson.M{"$and": conds}
+}
+
+// mongoStatuses returns the condition on the status field that matches the given statuses.
+func mongoStatuses(statuses ...blogStatus) bson.M {
+	in := bson.A{}
+	for _, s := range statuses {
+		in = append(in, s)
+		if s == statusPublished {
+			// Blogs from before the statuses have none, null also matches a missing field
+			in = append(in, nil)
+		}
+	}
+	return bson.M{"$in": in}
+}
+
+// mongoSort returns the MongoDB sort document for the order o.
+func mongoSort(o listOrder) bson.D {
+	switch o {
+	case orderNewestFirst:
+		return bson.D{{Key: "_id", Value: -1}}
+	case orderByTitle, orderByAuthor:
+		return bson.D{{Key: mongoSortField(o), Value: 1}, {Key: "_id", Value: 1}}
+	}
+	return bson.D{{Key: "_id", Value: 1}}
+}
+
+func mongoSortField(o listOrder) string {
+	if o == orderByAuthor {
+		return "author_id"
+	}
+	return "title"
+}
+
+func (m *mongoStore) CreateAttachment(ctx context.Context, item *attachmentItem) (*attachmentItem, error) {
+	created := *item
+	created.ID = primitive.NewObjectID()
+	if _, err := m.attachments.InsertOne(ctx, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (m *mongoStore) ReadAttachment(ctx context.Context, id primitive.ObjectID) (*attachmentItem, error) {
+	return m.findAttachment(ctx, bson.M{"_id": id})
+}
+
+func (m *mongoStore) FindAttachment(ctx context.Context, ownerID, sha256, contentType string) (*attachmentItem, error) {
+	return m.findAttachment(ctx, bson.M{"owner_id": ownerID, "sha256": sha256, "content_type": contentType})
+}
+
+func (m *mongoStore) findAttachment(ctx context.Context, filter bson.M) (*attachmentItem, error) {
+	a := &attachmentItem{}
+	err := m.attachments.FindOne(ctx, filter).Decode(a)
+	if err == mongo.ErrNoDocuments {
+		return nil, errAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (m *mongoStore) AttachmentUsage(ctx context.Context, ownerID string) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"owner_id": ownerID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "usage": bson.M{"$sum": "$size"}}}},
+	}
+	cursor, err := m.attachments.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	var row struct {
+		Usage int64 `bson:"usage"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&row); err != nil {
+			return 0, err
+		}
+	}
+	return row.Usage, cursor.Err()
+}
+
+func (m *mongoStore) AddView(ctx context.Context, blogID primitive.ObjectID, t time.Time) (int64, error) {
+	// Both updates are atomic, and upserts on a unique index are retried by the server when they race
+	_, err := m.views.UpdateOne(ctx,
+		bson.M{"blog_id": blogID, "hour": t.Truncate(time.Hour)},
+		bson.M{"$inc": bson.M{"count": 1}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return 0, err
+	}
+	counts := &countsItem{}
+	err = m.counters.FindOneAndUpdate(ctx,
+		bson.M{"_id": blogID},
+		bson.M{"$inc": bson.M{"views": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(counts)
+	if err != nil {
+		return 0, err
+	}
+	return counts.Views, nil
+}
+
+func (m *mongoStore) AddReaction(ctx context.Context, item *reactionItem) (bool, error) {
+	// The upsert inserts the reaction only if the user does not have it yet, the fields of
+	// the filter are set on insert too. Only the call that inserted it counts it.
+	res, err := m.reactions.UpdateOne(ctx,
+		bson.M{"blog_id": item.BlogID, "user_id": item.UserID, "reaction": item.Reaction},
+		bson.M{"$setOnInsert": bson.M{"create_time": item.CreateTime}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent upsert of the same reaction inserted it first
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if res.UpsertedCount == 0 {
+		return false, nil
+	}
+	return true, m.incReaction(ctx, item.BlogID, item.Reaction, 1)
+}
+
+func (m *mongoStore) RemoveReaction(ctx context.Context, blogID primitive.ObjectID, userID, reaction string) (bool, error) {
+	res, err := m.reactions.DeleteOne(ctx, bson.M{"blog_id": blogID, "user_id": userID, "reaction": reaction})
+	if err != nil {
+		return false, err
+	}
+	if res.DeletedCount == 0 {
+		return false, nil
+	}
+	return true, m.incReaction(ctx, blogID, reaction, -1)
+}
+
+// incReaction adds delta to the count of a reaction to a blog. The validation keeps
+// the reactions free of "." and "$", so that they can be field names.
+func (m *mongoStore) incReaction(ctx context.Context, blogID primitive.ObjectID, reaction string, delta int64) error {
+	_, err := m.counters.UpdateOne(ctx,
+		bson.M{"_id": blogID},
+		bson.M{"$inc": bson.M{"reactions." + reaction: delta}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (m *mongoStore) ReadCounts(ctx context.Context, blogID primitive.ObjectID) (*countsItem, error) {
+	counts := &countsItem{}
+	err := m.counters.FindOne(ctx, bson.M{"_id": blogID}).Decode(counts)
+	if err == mongo.ErrNoDocuments {
+		return counts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Reactions that were all taken back stay with a count of zero
+	for reaction, n := range counts.Reactions {
+		if n <= 0 {
+			delete(counts.Reactions, reaction)
+		}
+	}
+	return counts, nil
+}
+
+func (m *mongoStore) TopBlogs(ctx context.Context, metric engagementMetric, start, end time.Time, fn func(blogCount) error) error {
+	var collection *mongo.Collection
+	var match, count bson.M
+	switch metric {
+	case metricViews:
+		collection = m.views
+		match = bson.M{"hour": bson.M{"$gte": firstHourIn(start), "$lt": firstHourIn(end)}}
+		count = bson.M{"$sum": "$count"}
+	case metricReactions:
+		collection = m.reactions
+		match = bson.M{"create_time": bson.M{"$gte": start, "$lt": end}}
+		count = bson.M{"$sum": 1}
+	default:
+		return fmt.Errorf("unknown metric: %v", metric)
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$blog_id", "count": count}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var bc blogCount
+		if err := cursor.Decode(&bc); err != nil {
+			return err
+		}
+		if err := fn(bc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pageCursor is the position in a listing where the next page starts.
+type pageCursor struct {
+	// After is the id of the last blog on the previous page.
+	After primitive.ObjectID `json:"a"`
+	// Key is the sort key of the last blog on the previous page, if the order has one.
+	Key string `json:"k,omitempty"`
+	// Query identifies the filter and order the cursor was created for.
+	Query string `json:"q,omitempty"`
+}
+
+// errInvalidPageToken is returned when a page token is malformed or has been tampered with.
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageTokenCodec turns page cursors into opaque tokens and back.
+// The tokens are signed with an HMAC, so clients cannot forge or modify them.
+type pageTokenCodec struct {
+	key []byte
+}
+
+// newPageTokenCodec returns a codec signing with key. If key is empty a random key is used,
+// which means that tokens do not survive a server restart.
+func newPageTokenCodec(key []byte) *pageTokenCodec {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &pageTokenCodec{key: key}
+}
+
+// Encode returns the token for cursor.
+func (c *pageTokenCodec) Encode(cursor pageCursor) string {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, c.sign(payload)...))
+}
+
+// Decode verifies token and returns the cursor it contains.
+func (c *pageTokenCodec) Decode(token string) (pageCursor, error) {
+	var cursor pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < sha256.Size {
+		return cursor, errInvalidPageToken
+	}
+	payload, mac := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	if !hmac.Equal(mac, c.sign(payload)) {
+		return cursor, errInvalidPageToken
+	}
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return cursor, errInvalidPageToken
+	}
+	return cursor, nil
+}
+
+func (c *pageTokenCodec) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, c.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPageTokenRoundTrip(t *testing.T) {
+	c := newPageTokenCodec([]byte("key"))
+	cursors := []pageCursor{
+		{After: primitive.NewObjectID()},
+		{After: primitive.NewObjectID(), Key: "Hello, \"gRPC\"", Query: "abc"},
+	}
+	for _, cursor := range cursors {
+		got, err := c.Decode(c.Encode(cursor))
+		if err != nil || got != cursor {
+			t.Errorf("Decode(Encode(%+v)) = %+v, %v", cursor, got, err)
+		}
+	}
+}
+
+func TestPageTokenTampered(t *testing.T) {
+	c := newPageTokenCodec([]byte("key"))
+	token := c.Encode(pageCursor{After: primitive.NewObjectID(), Key: "title"})
+
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	tokens := map[string]string{
+		"tampered":   string(tampered),
+		"truncated":  token[:len(token)-1],
+		"other key":  newPageTokenCodec([]byte("other")).Encode(pageCursor{After: primitive.NewObjectID()}),
+		"not base64": "not a token!",
+		"too short":  "YWJj",
+		"empty":      "",
+	}
+	for name, token := range tokens {
+		if _, err := c.Decode(token); err != errInvalidPageToken {
+			t.Errorf("%v: got %v, want errInvalidPageToken", name, err)
+		}
+	}
+}
+
+// listBlogPage returns the titles of one page of ListBlog, and the token of the next page.
+func listBlogPage(f *fixture, req *blogpb.ListBlogRequest) ([]string, string, error) {
+	stream, err := f.blogs.ListBlog(context.Background(), req)
+	if err != nil {
+		return nil, "", err
+	}
+	var titles []string
+	next := ""
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return titles, next, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		titles = append(titles, res.GetBlog().GetTitle())
+		next = res.GetNextPageToken()
+	}
+}
+
+func TestListBlogPages(t *testing.T) {
+	f := newFixture(t)
+	want := []string{f.blog.GetTitle()}
+	for i := 1; i <= 4; i++ {
+		title := fmt.Sprintf("Blog %v", i)
+		blog := &blogpb.Blog{Title: title, Status: blogpb.Blog_PUBLISHED}
+		if _, err := f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: blog}); err != nil {
+			t.Fatalf("CreateBlog: %v", err)
+		}
+		want = append(want, title)
+	}
+
+	var got []string
+	req := &blogpb.ListBlogRequest{PageSize: 2}
+	for pages := 1; ; pages++ {
+		titles, next, err := listBlogPage(f, req)
+		if err != nil {
+			t.Fatalf("ListBlog: %v", err)
+		}
+		got = append(got, titles...)
+		if next == "" {
+			if pages != 3 {
+				t.Errorf("got %v pages, want 3", pages)
+			}
+			break
+		}
+		req.PageToken = next
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+
+	// A token only continues the listing it was created for
+	_, next, err := listBlogPage(f, &blogpb.ListBlogRequest{PageSize: 2})
+	if err != nil {
+		t.Fatalf("ListBlog: %v", err)
+	}
+	_, _, err = listBlogPage(f, &blogpb.ListBlogRequest{PageSize: 2, PageToken: next, AuthorId: "bob"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("token of another filter: got %v, want InvalidArgument", err)
+	}
+	_, _, err = listBlogPage(f, &blogpb.ListBlogRequest{PageSize: 2, PageToken: next, OrderBy: blogpb.ListBlogRequest_TITLE})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("token of another order: got %v, want InvalidArgument", err)
+	}
+	_, _, err = listBlogPage(f, &blogpb.ListBlogRequest{PageSize: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("negative page size: got %v, want InvalidArgument", err)
+	}
+	_, _, err = listBlogPage(f, &blogpb.ListBlogRequest{PageToken: "not a token!"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad token: got %v, want InvalidArgument", err)
+	}
+}
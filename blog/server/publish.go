@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var statusToPb = map[blogStatus]blogpb.Blog_Status{
+	statusDraft:     blogpb.Blog_DRAFT,
+	statusScheduled: blogpb.Blog_SCHEDULED,
+	statusPublished: blogpb.Blog_PUBLISHED,
+	statusArchived:  blogpb.Blog_ARCHIVED,
+}
+
+var statusFromPb = map[blogpb.Blog_Status]blogStatus{
+	blogpb.Blog_DRAFT:     statusDraft,
+	blogpb.Blog_SCHEDULED: statusScheduled,
+	blogpb.Blog_PUBLISHED: statusPublished,
+	blogpb.Blog_ARCHIVED:  statusArchived,
+}
+
+// canSee reports whether the caller in ctx may see the blog. Published blogs are public,
+// the others are only seen by their author and admins.
+func canSee(ctx context.Context, data *blogItem) bool {
+	if data.status() == statusPublished {
+		return true
+	}
+	caller := callerID(ctx)
+	return caller != "" && caller == data.AuthorID || isAdmin(ctx)
+}
+
+// onlyVisible restricts f to the published blogs, and the drafts and scheduled blogs of author, if set.
+func onlyVisible(f *blogFilter, author string) {
+	f.Statuses = []blogStatus{statusPublished}
+	f.DraftsOf = author
+}
+
+// publication returns the status and publish time of a new blog, as requested in blog.
+// Blogs scheduled for a time that has passed are published right away.
+func publication(blog *blogpb.Blog, now time.Time) (blogStatus, time.Time) {
+	switch blog.GetStatus() {
+	case blogpb.Blog_PUBLISHED:
+		return statusPublished, now
+	case blogpb.Blog_SCHEDULED:
+		if t := blog.GetPublishTime().AsTime(); t.After(now) {
+			return statusScheduled, t
+		}
+		return statusPublished, now
+	}
+	return statusDraft, time.Time{}
+}
+
+// PublishBlog is an RPC for the Blog Service to publish a blog now, or to schedule it for later
+func (s *server) PublishBlog(ctx context.Context, req *blogpb.PublishBlogRequest) (*blogpb.PublishBlogResponse, error) {
+	log.Println("Invoked RPC PublishBlog...")
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+
+	data, err := s.updateBlog(ctx, oid, req.GetExpectedVersion(), func(data *blogItem) error {
+		now := s.now()
+		if t := req.GetPublishTime(); t != nil && t.AsTime().After(now) {
+			data.Status, data.PublishTime = statusScheduled, t.AsTime()
+			return nil
+		}
+		if data.status() == statusPublished {
+			return status.Errorf(codes.FailedPrecondition, fmt.Sprintf("Blog is already published: %v", req.GetBlogId()))
+		}
+		data.Status, data.PublishTime = statusPublished, now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.recordRevision(ctx, data)
+	return &blogpb.PublishBlogResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+// UnpublishBlog is an RPC for the Blog Service to turn a blog back into a draft, or to archive it
+func (s *server) UnpublishBlog(ctx context.Context, req *blogpb.UnpublishBlogRequest) (*blogpb.UnpublishBlogResponse, error) {
+	log.Println("Invoked RPC UnpublishBlog...")
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+
+	target := statusDraft
+	if req.GetArchive() {
+		target = statusArchived
+	}
+	data, err := s.updateBlog(ctx, oid, req.GetExpectedVersion(), func(data *blogItem) error {
+		if data.status() == target {
+			return status.Errorf(codes.FailedPrecondition, fmt.Sprintf("Blog is already %v: %v", target, req.GetBlogId()))
+		}
+		// Archived blogs keep the time they were published
+		if target == statusDraft || data.status() == statusScheduled {
+			data.PublishTime = time.Time{}
+		}
+		data.Status = target
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.recordRevision(ctx, data)
+	return &blogpb.UnpublishBlogResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+// publishScheduled publishes the scheduled blogs whose publish time has come.
+// It returns the number of published blogs.
+func (s *server) publishScheduled(ctx context.Context) (int, error) {
+	now := s.now()
+	q := listQuery{Filter: blogFilter{Statuses: []blogStatus{statusScheduled}, PublishedBefore: now.Add(time.Nanosecond)}}
+	var due []*blogItem
+	err := s.store.List(ctx, q, func(data *blogItem) error {
+		due = append(due, data)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, data := range due {
+		before := *data
+		data.Status = statusPublished
+		data.UpdateTime = now
+		// The version check skips blogs that were changed in the meantime, they are due again on the next run
+		data, err := s.store.Update(ctx, data)
+		if err == errNotFound || err == errVersionMismatch {
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+		published++
+		s.index.Add(data)
+		// Without a caller in ctx, the revision has no editor
+		s.recordRevision(ctx, data)
+		s.events.Publish(blogpb.WatchBlogsResponse_UPDATED, &before, data, now)
+	}
+	return published, nil
+}
+
+// runScheduler calls publishScheduled every interval, until ctx is canceled.
+func (s *server) runScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.publishScheduled(ctx)
+			if err != nil {
+				log.Printf("Error publishing scheduled blogs: %v\n", err)
+			}
+			if n > 0 {
+				log.Printf("Published %v scheduled blogs\n", n)
+			}
+		}
+	}
+}
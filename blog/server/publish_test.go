@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/auth"
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestPublishBlog(t *testing.T) {
+	publish := func(subject string, req func(f *fixture) *blogpb.PublishBlogRequest) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.PublishBlog(f.as(subject), req(f))
+			return err
+		}
+	}
+	fresh := func(f *fixture) *blogpb.PublishBlogRequest {
+		return &blogpb.PublishBlogRequest{BlogId: f.draft.GetId()}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: publish("alice", fresh), want: codes.OK},
+		{name: "schedule", call: publish("alice", func(f *fixture) *blogpb.PublishBlogRequest {
+			return &blogpb.PublishBlogRequest{BlogId: f.draft.GetId(), PublishTime: timestamppb.New(time.Now().Add(time.Hour))}
+		}), want: codes.OK},
+		{name: "already published", call: publish("alice", func(f *fixture) *blogpb.PublishBlogRequest {
+			return &blogpb.PublishBlogRequest{BlogId: f.blog.GetId()}
+		}), want: codes.FailedPrecondition},
+		{name: "draft of another author", call: publish("bob", fresh), want: codes.NotFound},
+		{name: "admin", call: func(f *fixture) error {
+			_, err := f.blogs.PublishBlog(f.as("admin", auth.AdminRole), fresh(f))
+			return err
+		}, want: codes.OK},
+		{name: "anonymous", call: func(f *fixture) error {
+			_, err := f.blogs.PublishBlog(context.Background(), fresh(f))
+			return err
+		}, want: codes.Unauthenticated},
+		{name: "bad id", call: publish("alice", func(f *fixture) *blogpb.PublishBlogRequest {
+			return &blogpb.PublishBlogRequest{BlogId: "nope"}
+		}), want: codes.InvalidArgument},
+		{name: "missing", call: publish("alice", func(f *fixture) *blogpb.PublishBlogRequest {
+			return &blogpb.PublishBlogRequest{BlogId: missingID}
+		}), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: publish("alice", func(f *fixture) *blogpb.PublishBlogRequest {
+			return &blogpb.PublishBlogRequest{BlogId: f.blog.GetId()}
+		}), want: codes.Unavailable},
+	})
+}
+
+func TestUnpublishBlog(t *testing.T) {
+	unpublish := func(subject string, archive bool) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.UnpublishBlog(f.as(subject), &blogpb.UnpublishBlogRequest{BlogId: f.blog.GetId(), Archive: archive})
+			return err
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: unpublish("alice", false), want: codes.OK},
+		{name: "archive", call: unpublish("alice", true), want: codes.OK},
+		{name: "already a draft", call: func(f *fixture) error {
+			_, err := f.blogs.UnpublishBlog(f.as("alice"), &blogpb.UnpublishBlogRequest{BlogId: f.draft.GetId()})
+			return err
+		}, want: codes.FailedPrecondition},
+		{name: "not the owner", call: unpublish("bob", false), want: codes.PermissionDenied},
+		{name: "bad id", call: func(f *fixture) error {
+			_, err := f.blogs.UnpublishBlog(f.as("alice"), &blogpb.UnpublishBlogRequest{BlogId: "nope"})
+			return err
+		}, want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: unpublish("alice", false), want: codes.Unavailable},
+	})
+}
+
+func TestDraftsAreOnlySeenByTheirAuthor(t *testing.T) {
+	f := newFixture(t)
+	draft := f.draft
+
+	read := func(ctx context.Context) codes.Code {
+		_, err := f.blogs.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: draft.GetId()})
+		return status.Code(err)
+	}
+	if got := read(context.Background()); got != codes.NotFound {
+		t.Errorf("anonymous ReadBlog of a draft: got %v, want NotFound", got)
+	}
+	if got := read(f.as("bob")); got != codes.NotFound {
+		t.Errorf("ReadBlog of a draft of another author: got %v, want NotFound", got)
+	}
+	if got := read(f.as("alice")); got != codes.OK {
+		t.Errorf("ReadBlog of an own draft: got %v, want OK", got)
+	}
+
+	list := func(ctx context.Context, req *blogpb.ListBlogRequest) []string {
+		t.Helper()
+		stream, err := f.blogs.ListBlog(ctx, req)
+		if err != nil {
+			t.Fatalf("ListBlog: %v", err)
+		}
+		var titles []string
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				break
+			}
+			titles = append(titles, res.GetBlog().GetTitle())
+		}
+		return titles
+	}
+	if got := fmt.Sprint(list(context.Background(), &blogpb.ListBlogRequest{})); got != "[Hello gRPC]" {
+		t.Errorf("ListBlog: got %v, want only the published blog", got)
+	}
+	if got := fmt.Sprint(list(f.as("alice"), &blogpb.ListBlogRequest{IncludeDrafts: true})); got != "[Hello gRPC Work in progress]" {
+		t.Errorf("ListBlog with drafts of alice: got %v, want the published blog and the draft", got)
+	}
+	if got := fmt.Sprint(list(f.as("bob"), &blogpb.ListBlogRequest{IncludeDrafts: true})); got != "[Hello gRPC]" {
+		t.Errorf("ListBlog with drafts of bob: got %v, want only the published blog", got)
+	}
+}
+
+func TestSchedulerPublishesDueBlogs(t *testing.T) {
+	f := newFixture(t)
+	srv := newServer(f.store, nil)
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+
+	draft := f.draft
+	res, err := f.blogs.PublishBlog(f.as("alice"), &blogpb.PublishBlogRequest{BlogId: draft.GetId(), PublishTime: timestamppb.New(now.Add(time.Hour))})
+	if err != nil {
+		t.Fatalf("PublishBlog: %v", err)
+	}
+	if res.GetBlog().GetStatus() != blogpb.Blog_SCHEDULED {
+		t.Fatalf("got status %v, want SCHEDULED", res.GetBlog().GetStatus())
+	}
+
+	if n, err := srv.publishScheduled(context.Background()); n != 0 || err != nil {
+		t.Fatalf("publishScheduled before the publish time: got %v, %v, want nothing published", n, err)
+	}
+	now = now.Add(2 * time.Hour)
+	if n, err := srv.publishScheduled(context.Background()); n != 1 || err != nil {
+		t.Fatalf("publishScheduled after the publish time: got %v, %v, want 1 blog published", n, err)
+	}
+	read, err := f.blogs.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: draft.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog of the published blog: %v", err)
+	}
+	if read.GetBlog().GetStatus() != blogpb.Blog_PUBLISHED {
+		t.Errorf("got status %v, want PUBLISHED", read.GetBlog().GetStatus())
+	}
+	rev, err := f.blogs.GetBlogRevision(context.Background(), &blogpb.GetBlogRevisionRequest{BlogId: draft.GetId(), Version: read.GetBlog().GetVersion()})
+	if err != nil {
+		t.Fatalf("GetBlogRevision of the published version: %v", err)
+	}
+	if rev.GetRevision().GetEditor() != "" {
+		t.Errorf("got editor %q, want none for the scheduler", rev.GetRevision().GetEditor())
+	}
+}
+
+func TestPublishingRecordsRevisions(t *testing.T) {
+	f := newFixture(t)
+	ctx := f.as("alice")
+	id := f.draft.GetId()
+	if _, err := f.blogs.PublishBlog(ctx, &blogpb.PublishBlogRequest{BlogId: id}); err != nil {
+		t.Fatalf("PublishBlog: %v", err)
+	}
+	if _, err := f.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogRequest{BlogId: id, Archive: true}); err != nil {
+		t.Fatalf("UnpublishBlog: %v", err)
+	}
+	res, err := f.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogRequest{BlogId: id})
+	if err != nil {
+		t.Fatalf("UnpublishBlog: %v", err)
+	}
+
+	stream, err := f.blogs.ListBlogRevisions(ctx, &blogpb.ListBlogRevisionsRequest{BlogId: id})
+	if err != nil {
+		t.Fatalf("ListBlogRevisions: %v", err)
+	}
+	var versions []int64
+	for {
+		rev, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		versions = append(versions, rev.GetRevision().GetVersion())
+		if rev.GetRevision().GetEditor() != "alice" {
+			t.Errorf("got editor %q of version %v, want alice", rev.GetRevision().GetEditor(), rev.GetRevision().GetVersion())
+		}
+	}
+	if got, want := fmt.Sprint(versions), "[1 2 3 4]"; got != want || res.GetBlog().GetVersion() != 4 {
+		t.Errorf("got revisions %v of version %v, want %v", got, res.GetBlog().GetVersion(), want)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListBlogRevisions is an RPC for the Blog Service to list the edit history of a blog
+func (s *server) ListBlogRevisions(req *blogpb.ListBlogRevisionsRequest, stream blogpb.BlogService_ListBlogRevisionsServer) error {
+	log.Println("Invoked RPC ListBlogRevisions...")
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	if _, err := s.readBlog(stream.Context(), oid, false); err != nil {
+		return storeError(err, blogResource(req.GetBlogId()))
+	}
+
+	err = s.store.ListRevisions(stream.Context(), oid, func(rev *revisionItem) error {
+		return stream.Send(&blogpb.ListBlogRevisionsResponse{Revision: revisionToPb(rev)})
+	})
+	if err != nil {
+		return storeError(err, blogResource(req.GetBlogId()))
+	}
+	return nil
+}
+
+// GetBlogRevision is an RPC for the Blog Service to read one revision of a blog
+func (s *server) GetBlogRevision(ctx context.Context, req *blogpb.GetBlogRevisionRequest) (*blogpb.GetBlogRevisionResponse, error) {
+	log.Println("Invoked RPC GetBlogRevision...")
+	rev, err := s.readRevision(ctx, req.GetBlogId(), req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.GetBlogRevisionResponse{Revision: revisionToPb(rev)}, nil
+}
+
+// RevertBlog is an RPC for the Blog Service to restore the title, content and author of an old revision
+func (s *server) RevertBlog(ctx context.Context, req *blogpb.RevertBlogRequest) (*blogpb.RevertBlogResponse, error) {
+	log.Println("Invoked RPC RevertBlog...")
+	rev, err := s.readRevision(ctx, req.GetBlogId(), req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.updateBlog(ctx, rev.BlogID, req.GetExpectedVersion(), func(data *blogItem) error {
+		data.AuthorID = rev.AuthorID
+		data.Title = rev.Title
+		data.Content = rev.Content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.recordRevision(ctx, data)
+
+	return &blogpb.RevertBlogResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+// readRevision returns the revision of the live blog with the given id and version.
+// The returned error is a gRPC status.
+func (s *server) readRevision(ctx context.Context, blogID string, version int64) (*revisionItem, error) {
+	oid, err := primitive.ObjectIDFromHex(blogID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	if _, err := s.readBlog(ctx, oid, false); err != nil {
+		return nil, storeError(err, blogResource(blogID))
+	}
+
+	rev, err := s.store.ReadRevision(ctx, oid, version)
+	if err != nil {
+		return nil, storeError(err, revisionResource(blogID, version))
+	}
+	return rev, nil
+}
+
+// recordRevision stores a snapshot of data as written by the authenticated caller in ctx.
+// Every write that bumps the version records one, so that each version has its revision.
+// The blog itself has already been written, so a failure is only logged.
+func (s *server) recordRevision(ctx context.Context, data *blogItem) {
+	rev := &revisionItem{
+		BlogID:     data.ID,
+		Version:    data.Version,
+		AuthorID:   data.AuthorID,
+		Title:      data.Title,
+		Content:    data.Content,
+		Editor:     callerID(ctx),
+		CreateTime: data.UpdateTime,
+	}
+	if err := s.store.AddRevision(ctx, rev); err != nil {
+		log.Printf("Error recording revision %v of blog %v: %v\n", data.Version, data.ID.Hex(), err)
+	}
+}
+
+func revisionToPb(rev *revisionItem) *blogpb.BlogRevision {
+	return &blogpb.BlogRevision{
+		BlogId:     rev.BlogID.Hex(),
+		Version:    rev.Version,
+		AuthorId:   rev.AuthorID,
+		Title:      rev.Title,
+		Content:    rev.Content,
+		Editor:     rev.Editor,
+		CreateTime: timestampOrNil(rev.CreateTime),
+	}
+}
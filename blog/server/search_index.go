@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// titleWeight is how much more a match in the title counts than a match in the content.
+const titleWeight = 3.0
+
+// snippetWords is the number of words around the first match that a content snippet shows.
+const snippetWords = 24
+
+// posting is the number of occurrences of a term in one blog.
+type posting struct {
+	title, content int
+}
+
+// searchHit is a blog matching a search, with its relevance score.
+type searchHit struct {
+	ID    primitive.ObjectID
+	Score float64
+}
+
+// searchIndex is an in-memory inverted index over the title and content of all blogs.
+// It is kept up to date by the server on every write, so it works with any BlogStore.
+type searchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[primitive.ObjectID]posting
+	// lengths is the number of indexed terms in each blog.
+	lengths map[primitive.ObjectID]int
+	// terms is the set of terms in each blog, used to remove a blog from the index.
+	terms map[primitive.ObjectID][]string
+}
+
+// newSearchIndex returns an empty search index.
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: map[string]map[primitive.ObjectID]posting{},
+		lengths:  map[primitive.ObjectID]int{},
+		terms:    map[primitive.ObjectID][]string{},
+	}
+}
+
+// Build adds every blog in store to the index.
+func (x *searchIndex) Build(ctx context.Context, store BlogStore) error {
+	return store.List(ctx, listQuery{}, func(data *blogItem) error {
+		x.Add(data)
+		return nil
+	})
+}
+
+// Add indexes item, replacing any previous version of it.
+func (x *searchIndex) Add(item *blogItem) {
+	counts := map[string]posting{}
+	length := 0
+	for _, t := range analyze(item.Title) {
+		p := counts[t.term]
+		p.title++
+		counts[t.term] = p
+		length++
+	}
+	for _, t := range analyze(item.Content) {
+		p := counts[t.term]
+		p.content++
+		counts[t.term] = p
+		length++
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(item.ID)
+	terms := make([]string, 0, len(counts))
+	for term, p := range counts {
+		if x.postings[term] == nil {
+			x.postings[term] = map[primitive.ObjectID]posting{}
+		}
+		x.postings[term][item.ID] = p
+		terms = append(terms, term)
+	}
+	x.lengths[item.ID] = length
+	x.terms[item.ID] = terms
+}
+
+// Remove drops the blog with the given id from the index.
+func (x *searchIndex) Remove(id primitive.ObjectID) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+func (x *searchIndex) remove(id primitive.ObjectID) {
+	for _, term := range x.terms[id] {
+		delete(x.postings[term], id)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+	delete(x.terms, id)
+	delete(x.lengths, id)
+}
+
+// Search returns up to limit blogs containing any of the terms of query,
+// best match first. Blogs are scored with tf-idf, normalized by blog length,
+// where title matches weigh more than content matches.
+func (x *searchIndex) Search(query string, limit int) []searchHit {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	scores := map[primitive.ObjectID]float64{}
+	seen := map[string]bool{}
+	n := float64(len(x.lengths))
+	for _, t := range analyze(query) {
+		if seen[t.term] {
+			continue
+		}
+		seen[t.term] = true
+		docs := x.postings[t.term]
+		if len(docs) == 0 {
+			continue
+		}
+		idf := math.Log(1 + n/float64(len(docs)))
+		for id, p := range docs {
+			tf := titleWeight*float64(p.title) + float64(p.content)
+			scores[id] += tf * idf / math.Sqrt(float64(x.lengths[id]))
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, searchHit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return compareIDs(hits[i].ID, hits[j].ID) > 0
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// highlight returns text, HTML escaped, with every word matching one of the
+// terms wrapped in <em></em>. If maxWords is positive, only a window of that
+// many words around the first match is returned.
+func highlight(text string, terms map[string]bool, maxWords int) string {
+	tokens := analyze(text)
+	first := -1
+	for i, t := range tokens {
+		if terms[t.term] {
+			first = i
+			break
+		}
+	}
+
+	from, to := 0, len(text)
+	if maxWords > 0 && len(tokens) > maxWords {
+		lo := first - maxWords/2
+		if lo < 0 {
+			lo = 0
+		}
+		hi := lo + maxWords
+		if hi > len(tokens) {
+			hi = len(tokens)
+			lo = hi - maxWords
+		}
+		if lo > 0 {
+			from = tokens[lo].start
+		}
+		if hi < len(tokens) {
+			to = tokens[hi-1].end
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, t := range tokens {
+		if t.start < from || t.end > to || !terms[t.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:t.start]))
+		b.WriteString("<em>")
+		b.WriteString(html.EscapeString(text[t.start:t.end]))
+		b.WriteString("</em>")
+		pos = t.end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// queryTerms returns the set of analyzed terms in query.
+func queryTerms(query string) map[string]bool {
+	terms := map[string]bool{}
+	for _, t := range analyze(query) {
+		terms[t.term] = true
+	}
+	return terms
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStem(t *testing.T) {
+	for word, want := range map[string]string{
+		"posts":     "post",
+		"posting":   "post",
+		"posted":    "post",
+		"running":   "run",
+		"stories":   "story",
+		"classes":   "class",
+		"quickly":   "quick",
+		"status":    "status",
+		"less":      "less",
+		"ring":      "ring",
+		"streaming": "stream",
+	} {
+		if got := stem(word); got != want {
+			t.Errorf("stem(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestSearchIndexRanking(t *testing.T) {
+	x := newSearchIndex()
+	inTitle := &blogItem{ID: primitive.NewObjectID(), Title: "Streaming in gRPC", Content: "A short post"}
+	inContent := &blogItem{ID: primitive.NewObjectID(), Title: "A long post", Content: "All about streams, and more"}
+	other := &blogItem{ID: primitive.NewObjectID(), Title: "Unary calls", Content: "Nothing else"}
+	for _, item := range []*blogItem{inTitle, inContent, other} {
+		x.Add(item)
+	}
+
+	// Stemming matches "streams" and "streaming" with "streamed", and titles weigh more
+	hits := x.Search("streamed", 0)
+	if len(hits) != 2 || hits[0].ID != inTitle.ID || hits[1].ID != inContent.ID {
+		t.Fatalf("got hits %v, want the title match before the content match", hits)
+	}
+	if hits[0].Score <= hits[1].Score {
+		t.Errorf("got scores %v and %v, want a higher score for the title match", hits[0].Score, hits[1].Score)
+	}
+	if hits := x.Search("streamed", 1); len(hits) != 1 || hits[0].ID != inTitle.ID {
+		t.Errorf("got hits %v with a limit, want only the best match", hits)
+	}
+	// Stop words are not indexed
+	if hits := x.Search("the and of", 0); len(hits) != 0 {
+		t.Errorf("got hits %v for stop words, want none", hits)
+	}
+
+	// Updates replace the old terms, and removed blogs are not found anymore
+	inTitle.Title = "Unary in gRPC"
+	x.Add(inTitle)
+	if hits := x.Search("unary", 0); len(hits) != 2 {
+		t.Errorf("got hits %v after the update, want both unary blogs", hits)
+	}
+	x.Remove(other.ID)
+	if hits := x.Search("unary", 0); len(hits) != 1 || hits[0].ID != inTitle.ID {
+		t.Errorf("got hits %v after the removal, want only the updated blog", hits)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	terms := queryTerms("posted")
+	if got, want := highlight("Posting <posts>", terms, 0), "<em>Posting</em> &lt;<em>posts</em>&gt;"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	text := "one two three four five six seven post eight nine ten eleven twelve"
+	if got, want := highlight(text, terms, 4), "…six seven <em>post</em> eight…"; got != want {
+		t.Errorf("got snippet %q, want %q", got, want)
+	}
+	if got, want := highlight("post one two three four", terms, 3), "<em>post</em> one two…"; got != want {
+		t.Errorf("got snippet %q, want %q", got, want)
+	}
+}
+
+func TestSearchBlogsSnippets(t *testing.T) {
+	f := newFixture(t)
+	stream, err := f.blogs.SearchBlogs(f.as("alice"), &blogpb.SearchBlogsRequest{Query: "streams"})
+	if err != nil {
+		t.Fatalf("SearchBlogs: %v", err)
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("SearchBlogs: %v", err)
+	}
+	if res.GetBlog().GetId() != f.blog.GetId() || res.GetScore() <= 0 {
+		t.Errorf("got %v, want the blog with a score", res)
+	}
+	if got, want := res.GetContentSnippet(), "# Intro\n\n<em>Streaming</em> *all* the things"; got != want {
+		t.Errorf("got content snippet %q, want %q", got, want)
+	}
+	if got := res.GetTitleSnippet(); got != "Hello gRPC" {
+		t.Errorf("got title snippet %q, want the title without highlights", got)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("got %v, want only one hit", err)
+	}
+}
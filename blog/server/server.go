@@ -2,15 +2,18 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/andreasatle/grpc-go-course/blog/blogpb"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/andreasatle/grpc-go-course/validate"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -19,61 +22,113 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type blogItem struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	AuthorID string             `bson:"author_id"`
-	Content  string             `bson:"content"`
-	Title    string             `bson:"title"`
-}
+const (
+	// defaultPageSize is the page size of ListBlog when the client does not ask for one.
+	defaultPageSize = 50
+	// maxPageSize is the largest page size ListBlog returns.
+	maxPageSize = 1000
+	// defaultSearchLimit is the number of results of SearchBlogs when the client does not ask for a limit.
+	defaultSearchLimit = 20
+)
 
-var collection *mongo.Collection
+// server implements the BlogServiceServer interface.
+type server struct {
+	store      Store
+	pageTokens *pageTokenCodec
+	index      *searchIndex
+	events     *eventBus
+	// blobs has the content of the attachments
+	blobs *blobStore
+	// maxAttachmentSize is the size of the largest attachment, and attachmentQuota
+	// the total size of the attachments of an author, in bytes.
+	maxAttachmentSize int64
+	attachmentQuota   int64
+	// siteURL is the base URL of the public blog site, for the links in the feeds, without a trailing slash.
+	siteURL   string
+	feedTimes *feedTimes
+	// now returns the current time, with the precision that the stores keep.
+	now func() time.Time
+}
 
-// server implements the GreetServiceServer interface.
-// This is confusing, since there are more than one of GreetManyTimes in the auto-generated file.
-type server struct{}
+// newServer returns a blog server using store, signing page tokens with pageTokenKey.
+func newServer(store Store, pageTokenKey []byte) *server {
+	return &server{
+		store:             store,
+		pageTokens:        newPageTokenCodec(pageTokenKey),
+		index:             newSearchIndex(),
+		events:            newEventBus(),
+		maxAttachmentSize: defaultMaxAttachmentSize,
+		attachmentQuota:   defaultAttachmentQuota,
+		siteURL:           defaultSiteURL,
+		feedTimes:         newFeedTimes(time.Now().UTC()),
+		now: func() time.Time {
+			// MongoDB stores times with millisecond precision
+			return time.Now().UTC().Truncate(time.Millisecond)
+		},
+	}
+}
 
 // CreateBlog is an RPC for the Blog Service to create an entry in the database
-func (*server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
+func (s *server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest) (*blogpb.CreateBlogResponse, error) {
 	log.Println("Invoked RPC CreateBlog...")
 	// Get blog from request
 	blog := req.GetBlog()
-
-	// Create a database item
-	data := blogItem{
-		AuthorID: blog.GetAuthorId(),
-		Title:    blog.GetTitle(),
-		Content:  blog.GetContent(),
+	// The author is always the caller, whatever the request says
+	authorID := callerID(ctx)
+	if authorID == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "Missing token")
 	}
-
-	// Insert database item in MongoDB
-	mongoRes, err := collection.InsertOne(context.Background(), data)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, fmt.Sprintf("Internal error: %v\n", err))
+	if err := s.checkAuthor(ctx, authorID); err != nil {
+		return nil, err
 	}
 
-	// Check that we retrieve an id
-	oid, ok := mongoRes.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return nil, status.Errorf(codes.Internal, fmt.Sprintf("Error converting oid"))
+	// Create a database item, the timestamps are never taken from the client
+	now := s.now()
+	data := &blogItem{
+		AuthorID:      authorID,
+		Title:         blog.GetTitle(),
+		Content:       blog.GetContent(),
+		Tags:          normalizeTags(blog.GetTags()),
+		CreateTime:    now,
+		UpdateTime:    now,
+		AttachmentIDs: attachmentIDs(blog),
+	}
+	data.Status, data.PublishTime = publication(blog, now)
+	if err := s.checkAttachments(ctx, data, nil); err != nil {
+		return nil, err
 	}
 
-	// Return a response containing the full blog item
-	res := &blogpb.CreateBlogResponse{
-		Blog: &blogpb.Blog{
-			Id:       oid.Hex(),
-			AuthorId: blog.GetAuthorId(),
-			Title:    blog.GetTitle(),
-			Content:  blog.GetContent(),
-		},
+	// Insert database item in the store
+	for attempt := 1; ; attempt++ {
+		slug, err := s.uniqueSlug(ctx, data.ID, data.Title, nil)
+		if err != nil {
+			return nil, storeError(err, blogs)
+		}
+		data.Slugs = []string{slug}
+		created, err := s.store.Create(ctx, data)
+		if err == errSlugExists && attempt < slugAttempts {
+			// Another blog took the slug in the meantime, the next one is free
+			continue
+		}
+		if err != nil {
+			return nil, storeError(err, blogs)
+		}
+		data = created
+		break
 	}
+	s.index.Add(data)
+	s.recordRevision(ctx, data)
+	s.events.Publish(blogpb.WatchBlogsResponse_CREATED, nil, data, data.CreateTime)
 
-	return res, nil
+	// Return a response containing the full blog item
+	return &blogpb.CreateBlogResponse{Blog: dataToBlogPb(data)}, nil
 }
 
 // ReadBlog is an RPC for the Blog Service to read an entry from the database
-func (*server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
+func (s *server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blogpb.ReadBlogResponse, error) {
 	log.Println("Invoked RPC ReadBlog...")
 	// Get the blog_id from the request
 	blogID := req.GetBlogId()
@@ -83,28 +138,56 @@ func (*server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*blog
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
 	}
-	// prepare a database item
-	data := &blogItem{}
-	filter := bson.M{"_id": oid}
-	err = collection.FindOne(context.Background(), filter).Decode(data)
+	if req.GetIncludeDeleted() && callerID(ctx) == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "Missing token, which the trash needs")
+	}
+	data, err := s.readBlog(ctx, oid, req.GetIncludeDeleted())
 	if err != nil {
-		log.Printf("Error retrieving data from database: %v", err)
-		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Error retrieving data from database: %v", err))
+		return nil, storeError(err, blogResource(blogID))
 	}
 
-	res := &blogpb.ReadBlogResponse{
-		Blog: &blogpb.Blog{
-			Id:       oid.Hex(),
-			AuthorId: data.AuthorID,
-			Title:    data.Title,
-			Content:  data.Content,
-		},
+	blog, err := s.blogWithCounts(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	res := &blogpb.ReadBlogResponse{Blog: blog}
+	if req.GetIncludeAuthor() {
+		author, err := s.store.ReadAuthor(ctx, data.AuthorID)
+		switch err {
+		case nil:
+			res.Author = authorToPb(author)
+		case errAuthorNotFound:
+			// Blogs written before the author profiles were introduced
+		default:
+			return nil, storeError(err, authorResource(data.AuthorID))
+		}
+	}
+	if req.GetRenderContent() {
+		res.Rendered = renderContent(data.Content)
 	}
 	return res, nil
 }
 
+// RenderBlog is an RPC for the Blog Service to render the Markdown content of a blog as HTML
+func (s *server) RenderBlog(ctx context.Context, req *blogpb.RenderBlogRequest) (*blogpb.RenderBlogResponse, error) {
+	log.Println("Invoked RPC RenderBlog...")
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	data, err := s.readBlog(ctx, oid, false)
+	if err != nil {
+		return nil, storeError(err, blogResource(req.GetBlogId()))
+	}
+	blog, err := s.blogWithCounts(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.RenderBlogResponse{Blog: blog, Rendered: renderContent(data.Content)}, nil
+}
+
 // UpdateBlog is an RPC for the Blog Service to update an entry in the database
-func (*server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
+func (s *server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
 	log.Println("Invoked RPC UpdateBlog...")
 	// Get the blog_id from the request
 	blog := req.GetBlog()
@@ -114,116 +197,467 @@ func (*server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
 	}
-	// prepare a database item
-	data := &blogItem{}
-	filter := bson.M{"_id": oid}
-	err = collection.FindOne(context.Background(), filter).Decode(data)
+	data, err := s.updateBlog(ctx, oid, req.GetExpectedVersion(), func(data *blogItem) error {
+		data.AuthorID = blog.GetAuthorId()
+		data.Title = blog.GetTitle()
+		data.Content = blog.GetContent()
+		data.Tags = normalizeTags(blog.GetTags())
+		data.AttachmentIDs = attachmentIDs(blog)
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error retrieving data from database: %v", err)
 		return nil, err
 	}
+	s.recordRevision(ctx, data)
 
-	data.AuthorID = blog.GetAuthorId()
-	data.Title = blog.GetTitle()
-	data.Content = blog.GetContent()
+	return &blogpb.UpdateBlogResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+// patchPaths are the field mask paths PatchBlog accepts, with how to apply them.
+var patchPaths = map[string]func(data *blogItem, blog *blogpb.Blog){
+	"author_id":      func(data *blogItem, blog *blogpb.Blog) { data.AuthorID = blog.GetAuthorId() },
+	"title":          func(data *blogItem, blog *blogpb.Blog) { data.Title = blog.GetTitle() },
+	"content":        func(data *blogItem, blog *blogpb.Blog) { data.Content = blog.GetContent() },
+	"tags":           func(data *blogItem, blog *blogpb.Blog) { data.Tags = normalizeTags(blog.GetTags()) },
+	"attachment_ids": func(data *blogItem, blog *blogpb.Blog) { data.AttachmentIDs = attachmentIDs(blog) },
+}
+
+// PatchBlog is an RPC for the Blog Service to update some of the fields of an entry in the database
+func (s *server) PatchBlog(ctx context.Context, req *blogpb.PatchBlogRequest) (*blogpb.PatchBlogResponse, error) {
+	log.Println("Invoked RPC PatchBlog...")
+	blog := req.GetBlog()
 
-	_, err = collection.ReplaceOne(context.Background(), filter, data)
+	oid, err := primitive.ObjectIDFromHex(blog.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Empty update mask")
+	}
+	for _, path := range paths {
+		if _, ok := patchPaths[path]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot patch field: %q", path))
+		}
+	}
+
+	data, err := s.updateBlog(ctx, oid, req.GetExpectedVersion(), func(data *blogItem) error {
+		for _, path := range paths {
+			patchPaths[path](data, blog)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error updating data in database: %v", err)
 		return nil, err
 	}
+	s.recordRevision(ctx, data)
 
-	return &blogpb.UpdateBlogResponse{Blog: dataToBlogPb(data)}, nil
+	return &blogpb.PatchBlogResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+// updateBlog reads the blog with the given id, modifies it with apply and writes it back,
+// unless apply returns an error, which must be a gRPC status.
+// Only the author of the blog and admins may modify it, otherwise codes.PermissionDenied is returned.
+// If expectedVersion is non-zero, the blog must have that version, otherwise codes.Aborted is returned.
+func (s *server) updateBlog(ctx context.Context, oid primitive.ObjectID, expectedVersion int64, apply func(*blogItem) error) (*blogItem, error) {
+	data, err := s.readBlog(ctx, oid, false)
+	if err != nil {
+		return nil, storeError(err, blogResource(oid.Hex()))
+	}
+	return s.writeBlog(ctx, data, expectedVersion, apply)
 }
 
-func (*server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
+// writeBlog modifies data, as read from the store, with apply and writes it back, as updateBlog does.
+// It also writes the blogs in the trash, which updateBlog reports as not found.
+func (s *server) writeBlog(ctx context.Context, data *blogItem, expectedVersion int64, apply func(*blogItem) error) (*blogItem, error) {
+	oid := data.ID
+	if err := checkOwner(ctx, data); err != nil {
+		return nil, err
+	}
+
+	if expectedVersion != 0 && expectedVersion != data.Version {
+		return nil, status.Errorf(codes.Aborted, fmt.Sprintf("Blog has version %v, expected %v", data.Version, expectedVersion))
+	}
+
+	before := *data
+	if err := apply(data); err != nil {
+		return nil, err
+	}
+	if err := s.checkAttachments(ctx, data, before.AttachmentIDs); err != nil {
+		return nil, err
+	}
+	// Only a new author must have a profile, so that blogs of older authors stay editable
+	if data.AuthorID != before.AuthorID {
+		// and the blog must stay with the caller, unless an admin hands it over
+		if data.AuthorID != callerID(ctx) && !isAdmin(ctx) {
+			return nil, status.Errorf(codes.PermissionDenied, "Only admins can hand a blog over to another author")
+		}
+		if err := s.checkAuthor(ctx, data.AuthorID); err != nil {
+			return nil, err
+		}
+	}
+	data.UpdateTime = s.now()
+
+	// The store checks that nobody else has written the blog since we read it
+	slugs := data.Slugs
+	for attempt := 1; ; attempt++ {
+		if err := s.updateSlug(ctx, data, before.Title); err != nil {
+			return nil, storeError(err, blogResource(oid.Hex()))
+		}
+		updated, err := s.store.Update(ctx, data)
+		if err == errSlugExists && attempt < slugAttempts {
+			// Another blog took the new slug in the meantime, the next one is free
+			data.Slugs = slugs
+			continue
+		}
+		if err != nil {
+			return nil, storeError(err, blogResource(oid.Hex()))
+		}
+		data = updated
+		break
+	}
+	switch {
+	case data.trashed():
+		s.index.Remove(data.ID)
+		s.events.Publish(blogpb.WatchBlogsResponse_DELETED, &before, data, data.UpdateTime)
+	case before.trashed():
+		s.index.Add(data)
+		s.events.Publish(blogpb.WatchBlogsResponse_RESTORED, &before, data, data.UpdateTime)
+	default:
+		s.index.Add(data)
+		s.events.Publish(blogpb.WatchBlogsResponse_UPDATED, &before, data, data.UpdateTime)
+	}
+	return data, nil
+}
+
+// readBlog reads the blog with the given id. Blogs in the trash are reported
+// as not found, unless includeDeleted is set and the caller in ctx wrote them or
+// is an admin, and so are the blogs that the caller cannot see.
+func (s *server) readBlog(ctx context.Context, oid primitive.ObjectID, includeDeleted bool) (*blogItem, error) {
+	data, err := s.store.Read(ctx, oid)
+	if err != nil {
+		return nil, err
+	}
+	if data.trashed() && !(includeDeleted && ownsTrash(ctx, data)) || !canSee(ctx, data) {
+		return nil, errNotFound
+	}
+	return data, nil
+}
+
+// ownsTrash reports whether the caller in ctx can see data in the trash, as its author or an admin.
+func ownsTrash(ctx context.Context, data *blogItem) bool {
+	caller := callerID(ctx)
+	return caller != "" && caller == data.AuthorID || isAdmin(ctx)
+}
+
+func (s *server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
 	log.Println("Invoked RPC DeleteBlog...")
 	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
 	}
-	filter := bson.M{"_id": oid}
-	_, err = collection.DeleteOne(context.Background(), filter)
+	// Move the blog to the trash, the purger removes it for good later on
+	data, err := s.updateBlog(ctx, oid, req.GetExpectedVersion(), func(data *blogItem) error {
+		data.DeleteTime = s.now()
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error deleting data in database: %v", err)
 		return nil, err
 	}
+	s.recordRevision(ctx, data)
 	return &blogpb.DeleteBlogResponse{}, nil
 }
 
-func (*server) ListBlog(req *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
+func (s *server) ListBlog(req *blogpb.ListBlogRequest, stream blogpb.BlogService_ListBlogServer) error {
 	log.Println("Invoked RPC ListBlog...")
-	cursor, err := collection.Find(context.Background(), bson.D{})
+	q, err := listQueryFromRequest(req)
 	if err != nil {
-		return status.Errorf(codes.Internal, fmt.Sprintf("Unknown internal error I: %v", err))
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Invalid list request: %v", err))
+	}
+	var draftsOf string
+	if req.GetIncludeDrafts() {
+		if draftsOf = callerID(stream.Context()); draftsOf == "" {
+			return status.Errorf(codes.Unauthenticated, "Missing token, drafts are only listed for their author")
+		}
 	}
-	defer cursor.Close(context.Background())
-	data := &blogItem{}
-	for cursor.Next(context.Background()) {
-		err := cursor.Decode(data)
+	onlyVisible(&q.Filter, draftsOf)
+	return s.listPage(stream.Context(), q, req.GetPageSize(), req.GetPageToken(), func(data *blogItem, nextPageToken string) error {
+		return stream.Send(&blogpb.ListBlogResponse{Blog: dataToBlogPb(data), NextPageToken: nextPageToken})
+	})
+}
+
+// listPage lists one page of the blogs selected by q, starting at pageToken, and calls send for each of them.
+// The last call gets the token of the next page, if there is one. The returned error is a gRPC status.
+func (s *server) listPage(ctx context.Context, q listQuery, pageSize int32, pageToken string, send func(data *blogItem, nextPageToken string) error) error {
+	size := int(pageSize)
+	switch {
+	case size < 0:
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Negative page size: %v", size))
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+
+	if pageToken != "" {
+		var err error
+		q.After, err = s.pageTokens.Decode(pageToken)
 		if err != nil {
-			return status.Errorf(codes.Internal, fmt.Sprintf("Error decoding data: %v", err))
+			return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse page token: %v", err))
+		}
+		if q.After.Query != q.id() {
+			return status.Errorf(codes.InvalidArgument, "Page token does not match the filter and order of the request")
+		}
+	}
+
+	// Ask for one extra blog, to know if there is a next page
+	page := make([]*blogItem, 0, size+1)
+	q.Limit = size + 1
+	err := s.store.List(ctx, q, func(data *blogItem) error {
+		page = append(page, data)
+		return nil
+	})
+	if err != nil {
+		return storeError(err, blogs)
+	}
+
+	nextPageToken := ""
+	if len(page) > size {
+		page = page[:size]
+		cursor := q.OrderBy.cursor(page[size-1])
+		cursor.Query = q.id()
+		nextPageToken = s.pageTokens.Encode(cursor)
+	}
+	for i, data := range page {
+		token := ""
+		if i == len(page)-1 {
+			token = nextPageToken
+		}
+		if err := send(data, token); err != nil {
+			return err
 		}
-		stream.Send(&blogpb.ListBlogResponse{Blog: dataToBlogPb(data)})
 	}
-	if err := cursor.Err(); err != nil {
-		return status.Errorf(codes.Internal, fmt.Sprintf("Unknown internal error II: %v", err))
+	return nil
+}
+
+// SearchBlogs is an RPC for the Blog Service to search the title and content of all blogs
+func (s *server) SearchBlogs(req *blogpb.SearchBlogsRequest, stream blogpb.BlogService_SearchBlogsServer) error {
+	log.Println("Invoked RPC SearchBlogs...")
+	terms := queryTerms(req.GetQuery())
+	if len(terms) == 0 {
+		return status.Errorf(codes.InvalidArgument, "Query has no searchable words")
+	}
+	limit := int(req.GetLimit())
+	switch {
+	case limit < 0:
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("Negative limit: %v", limit))
+	case limit == 0:
+		limit = defaultSearchLimit
+	case limit > maxPageSize:
+		limit = maxPageSize
+	}
+
+	// The index has every blog, so the limit applies after leaving out the ones that the caller cannot see
+	sent := 0
+	for _, hit := range s.index.Search(req.GetQuery(), 0) {
+		if sent == limit {
+			break
+		}
+		data, err := s.readBlog(stream.Context(), hit.ID, false)
+		if err == errNotFound {
+			// In the trash, deleted since it was found, or not visible to the caller
+			continue
+		}
+		if err != nil {
+			return storeError(err, blogResource(hit.ID.Hex()))
+		}
+		res := &blogpb.SearchBlogsResponse{
+			Blog:           dataToBlogPb(data),
+			Score:          hit.Score,
+			TitleSnippet:   highlight(data.Title, terms, 0),
+			ContentSnippet: highlight(data.Content, terms, snippetWords),
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+		sent++
 	}
 	return nil
 }
 
+// listQueryFromRequest returns the filter and order of req as a listQuery.
+func listQueryFromRequest(req *blogpb.ListBlogRequest) (listQuery, error) {
+	q := listQuery{
+		Filter: blogFilter{
+			AuthorID:      req.GetAuthorId(),
+			TitlePrefix:   req.GetTitlePrefix(),
+			TitleContains: req.GetTitleContains(),
+		},
+	}
+	if ts := req.GetCreatedAfter(); ts != nil {
+		if err := ts.CheckValid(); err != nil {
+			return q, err
+		}
+		// The stores keep times with millisecond precision
+		q.Filter.CreatedAfter = ts.AsTime().Truncate(time.Millisecond)
+	}
+	if ts := req.GetCreatedBefore(); ts != nil {
+		if err := ts.CheckValid(); err != nil {
+			return q, err
+		}
+		q.Filter.CreatedBefore = ts.AsTime().Truncate(time.Millisecond)
+	}
+
+	switch req.GetOrderBy() {
+	case blogpb.ListBlogRequest_OLDEST_FIRST:
+		q.OrderBy = orderOldestFirst
+	case blogpb.ListBlogRequest_NEWEST_FIRST:
+		q.OrderBy = orderNewestFirst
+	case blogpb.ListBlogRequest_TITLE:
+		q.OrderBy = orderByTitle
+	case blogpb.ListBlogRequest_AUTHOR:
+		q.OrderBy = orderByAuthor
+	default:
+		return q, fmt.Errorf("unknown order: %v", req.GetOrderBy())
+	}
+	return q, nil
+}
+
 func dataToBlogPb(data *blogItem) *blogpb.Blog {
 	return &blogpb.Blog{
 		Id:       data.ID.Hex(),
 		AuthorId: data.AuthorID,
 		Title:    data.Title,
 		Content:  data.Content,
+		Version:  data.Version,
+		// Blogs written before the timestamps were introduced have none
+		CreateTime:    timestampOrNil(data.CreateTime),
+		UpdateTime:    timestampOrNil(data.UpdateTime),
+		DeleteTime:    timestampOrNil(data.DeleteTime),
+		Tags:          data.Tags,
+		Status:        statusToPb[data.status()],
+		PublishTime:   timestampOrNil(data.PublishTime),
+		Slug:          data.slug(),
+		OldSlugs:      oldSlugs(data),
+		AttachmentIds: hexIDs(data.AttachmentIDs),
 	}
 }
+
+// hexIDs returns ids in hexadecimal.
+func hexIDs(ids []primitive.ObjectID) []string {
+	var res []string
+	for _, id := range ids {
+		res = append(res, id.Hex())
+	}
+	return res
+}
+
+// timestampOrNil converts t to a protobuf timestamp, or nil if t is the zero time.
+func timestampOrNil(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
 func main() {
 	// Setup the logging, for if program crashes
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	// Setup MongoDB
-	log.Println("Create a context...")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer func() {
-		log.Println("Cancel Context...")
-		cancel()
-	}()
+	storeName := flag.String("store", "mongo", "storage backend: mongo or memory")
+	mongoURI := flag.String("mongo-uri", "mongodb://localhost:27017", "MongoDB connection string")
+	pageTokenKey := flag.String("page-token-key", "", "secret for signing page tokens, random if empty")
+	authSecret := flag.String("auth-secret", "", "secret for verifying the bearer tokens of the callers")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long deleted blogs stay in the trash")
+	purgeInterval := flag.Duration("purge-interval", time.Hour, "how often the trash is purged")
+	publishInterval := flag.Duration("publish-interval", time.Minute, "how often scheduled blogs are published when due")
+	attachmentDir := flag.String("attachment-dir", "attachments", "directory for the content of the attachments")
+	maxAttachmentSize := flag.Int64("max-attachment-size", defaultMaxAttachmentSize, "size of the largest attachment in bytes")
+	attachmentQuota := flag.Int64("attachment-quota", defaultAttachmentQuota, "total size of the attachments of an author in bytes")
+	httpAddr := flag.String("http-addr", "0.0.0.0:8080", "address of the HTTP listener for the feeds and the JSON gateway, empty for none")
+	siteURL := flag.String("site-url", defaultSiteURL, "base URL of the public blog site, for the links in the feeds")
+	flag.Parse()
+	if *authSecret == "" {
+		log.Fatalln("Missing -auth-secret, blogs cannot be written without it")
+	}
+
+	var store Store
+	switch *storeName {
+	case "memory":
+		log.Println("Using in-memory store...")
+		store = newMemoryStore()
+	case "mongo":
+		// Setup MongoDB
+		log.Println("Create a context...")
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer func() {
+			log.Println("Cancel Context...")
+			cancel()
+		}()
+
+		log.Println("Connecting to MongoDB...")
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(*mongoURI))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer func() {
+			log.Println("Shutting down MongoDB...")
+			client.Disconnect(context.TODO())
+		}()
 
-	log.Println("Connecting to MongoDB...")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+		store, err = newMongoStore(ctx, client.Database("mydb"))
+		if err != nil {
+			log.Fatalf("Error setting up MongoDB: %v\n", err)
+		}
+	default:
+		log.Fatalf("Unknown store: %v\n", *storeName)
+	}
+
+	srv := newServer(store, []byte(*pageTokenKey))
+	blobs, err := newBlobStore(*attachmentDir)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Error setting up attachment directory: %v\n", err)
+	}
+	srv.blobs = blobs
+	srv.maxAttachmentSize, srv.attachmentQuota = *maxAttachmentSize, *attachmentQuota
+	srv.siteURL = strings.TrimSuffix(*siteURL, "/")
+	log.Println("Building search index...")
+	if err := srv.index.Build(context.Background(), store); err != nil {
+		log.Fatalf("Error building search index: %v\n", err)
 	}
 
-	defer func() {
-		log.Println("Shutting down MongoDB...")
-		client.Disconnect(context.TODO())
-	}()
+	// Start the background jobs, they stop when main returns
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go srv.runPurger(background, *purgeInterval, *trashRetention)
+	go srv.runScheduler(background, *publishInterval)
 
-	collection = client.Database("mydb").Collection("blog")
 	// Start a tcp listener
 	log.Println("Listen to tcp...")
 	listener, err := net.Listen("tcp", "0.0.0.0:50051")
 	if err != nil {
-		log.Printf("Failed to listen at tcp: %v\n", err)
+		log.Fatalf("Failed to listen at tcp: %v\n", err)
 	}
 	defer func() {
 		log.Println("Close the tcp-listener...")
 		listener.Close()
 	}()
 
+	authn := newAuthenticator([]byte(*authSecret))
 	tls := false
-	opts := []grpc.ServerOption{}
+	opts := []grpc.ServerOption{
+		// Authenticate first, so that anonymous callers learn nothing from the validation
+		grpc.ChainUnaryInterceptor(authn.Unary, validate.Unary),
+		grpc.ChainStreamInterceptor(authn.Stream, validate.Stream),
+	}
 	if tls {
 		certFile := "tsl/server.crt"
 		keyFile := "tsl/server.key"
 
 		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
 		if err != nil {
-			log.Fatal("Error loading certificates: %v", err)
+			log.Fatalf("Error loading certificates: %v", err)
 			return
 		}
 		opts = append(opts, grpc.Creds(creds))
@@ -239,7 +673,9 @@ func main() {
 
 	log.Println("Starting Blog service")
 	// Register service
-	blogpb.RegisterBlogServiceServer(s, &server{})
+	blogpb.RegisterBlogServiceServer(s, srv)
+	blogpb.RegisterCommentServiceServer(s, &commentServer{srv})
+	blogpb.RegisterAuthorServiceServer(s, &authorServer{srv})
 	reflection.Register(s)
 
 	go func() {
@@ -250,6 +686,31 @@ func main() {
 		}
 	}()
 
+	if *httpAddr != "" {
+		// Readers subscribe to the feeds, and frontends and scripts call the gateway, without a gRPC client.
+		// The gateway calls the RPCs through the gRPC server, so that they are authenticated and validated the same way.
+		conn, err := grpc.Dial(dialAddr(listener.Addr()), grpc.WithInsecure())
+		if err != nil {
+			log.Fatalf("Error connecting the gateway: %v\n", err)
+		}
+		defer conn.Close()
+		handler, err := srv.httpHandler(conn)
+		if err != nil {
+			log.Fatalf("Error setting up the gateway: %v\n", err)
+		}
+		httpServer := &http.Server{Addr: *httpAddr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+		defer func() {
+			log.Println("Stop the http server...")
+			httpServer.Close()
+		}()
+		go func() {
+			log.Printf("Serve the feeds and the gateway with http at %v...\n", *httpAddr)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to serve http: %v\n", err)
+			}
+		}()
+	}
+
 	// Wait for Contol-C to exit
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt)
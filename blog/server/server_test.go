@@ -0,0 +1,961 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/auth"
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// badToken is a context with a token that is not signed with testSecret.
+var badToken = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer not.signed")
+
+func TestCreateBlog(t *testing.T) {
+	create := func(ctx context.Context) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{Title: "New"}})
+			return err
+		}
+	}
+	as := func(subject string) func(f *fixture) error {
+		return func(f *fixture) error { return create(f.as(subject))(f) }
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: as("alice"), want: codes.OK},
+		{name: "anonymous", call: create(context.Background()), want: codes.Unauthenticated},
+		{name: "bad token", call: create(badToken), want: codes.Unauthenticated},
+		{name: "no author profile", call: as("carol"), want: codes.FailedPrecondition},
+		{name: "store unavailable", storeErr: errDown, call: as("alice"), want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: as("alice"), want: codes.Internal},
+	})
+}
+
+func TestReadBlog(t *testing.T) {
+	readAs := func(ctx func(f *fixture) context.Context, req func(f *fixture) *blogpb.ReadBlogRequest) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.ReadBlog(ctx(f), req(f))
+			return err
+		}
+	}
+	read := func(req func(f *fixture) *blogpb.ReadBlogRequest) func(f *fixture) error {
+		return readAs(anonymous, req)
+	}
+	trashed := func(f *fixture) *blogpb.ReadBlogRequest {
+		return &blogpb.ReadBlogRequest{BlogId: f.trashed.GetId(), IncludeDeleted: true}
+	}
+	live := read(func(f *fixture) *blogpb.ReadBlogRequest {
+		return &blogpb.ReadBlogRequest{BlogId: f.blog.GetId(), IncludeAuthor: true, RenderContent: true}
+	})
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: live, want: codes.OK},
+		{name: "bad id", call: read(func(f *fixture) *blogpb.ReadBlogRequest {
+			return &blogpb.ReadBlogRequest{BlogId: "nope"}
+		}), want: codes.InvalidArgument},
+		{name: "missing", call: read(func(f *fixture) *blogpb.ReadBlogRequest {
+			return &blogpb.ReadBlogRequest{BlogId: missingID}
+		}), want: codes.NotFound},
+		{name: "trashed", call: read(func(f *fixture) *blogpb.ReadBlogRequest {
+			return &blogpb.ReadBlogRequest{BlogId: f.trashed.GetId()}
+		}), want: codes.NotFound},
+		{name: "trashed included", call: readAs(asUser("bob"), trashed), want: codes.OK},
+		{name: "trashed included for an admin", call: readAs(asUser("admin", auth.AdminRole), trashed), want: codes.OK},
+		{name: "trashed of another author", call: readAs(asUser("alice"), trashed), want: codes.NotFound},
+		{name: "trashed included anonymously", call: read(trashed), want: codes.Unauthenticated},
+		{name: "store unavailable", storeErr: errDown, call: live, want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: live, want: codes.Internal},
+	})
+}
+
+func TestRenderBlog(t *testing.T) {
+	render := func(id func(f *fixture) string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.RenderBlog(context.Background(), &blogpb.RenderBlogRequest{BlogId: id(f)})
+			return err
+		}
+	}
+	live := render(func(f *fixture) string { return f.blog.GetId() })
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: live, want: codes.OK},
+		{name: "bad id", call: render(func(f *fixture) string { return "nope" }), want: codes.InvalidArgument},
+		{name: "missing", call: render(func(f *fixture) string { return missingID }), want: codes.NotFound},
+		{name: "trashed", call: render(func(f *fixture) string { return f.trashed.GetId() }), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: live, want: codes.Unavailable},
+	})
+}
+
+func TestRenderBlogContent(t *testing.T) {
+	f := newFixture(t)
+	res, err := f.blogs.RenderBlog(context.Background(), &blogpb.RenderBlogRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("RenderBlog: %v", err)
+	}
+	got := res.GetRendered()
+	if want := "<h1 id=\"intro\">Intro</h1>\n<p>Streaming <em>all</em> the things</p>"; got.GetHtml() != want {
+		t.Errorf("got html %q, want %q", got.GetHtml(), want)
+	}
+	if toc := got.GetToc(); len(toc) != 1 || toc[0].GetLevel() != 1 || toc[0].GetText() != "Intro" || toc[0].GetAnchor() != "intro" {
+		t.Errorf("got toc %v, want the heading Intro", toc)
+	}
+
+	// ReadBlog renders the same content when asked to
+	read, err := f.blogs.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: f.blog.GetId(), RenderContent: true})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if read.GetRendered().GetHtml() != got.GetHtml() {
+		t.Errorf("ReadBlog rendered %q, want %q", read.GetRendered().GetHtml(), got.GetHtml())
+	}
+	read, err = f.blogs.ReadBlog(context.Background(), &blogpb.ReadBlogRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if read.GetRendered() != nil {
+		t.Errorf("ReadBlog rendered %v without asking for it", read.GetRendered())
+	}
+}
+
+func TestBlogTimes(t *testing.T) {
+	f := newFixture(t)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 123e6, time.UTC)
+	now := created
+	f.srv.now = func() time.Time { return now }
+	ctx := f.as("alice")
+	// The times in requests are ignored
+	epoch := timestamppb.New(time.Unix(0, 0))
+	wantTimes := func(blog *blogpb.Blog, create, update time.Time) {
+		t.Helper()
+		if got := blog.GetCreateTime().AsTime(); !got.Equal(create) {
+			t.Errorf("got create time %v, want %v", got, create)
+		}
+		if got := blog.GetUpdateTime().AsTime(); !got.Equal(update) {
+			t.Errorf("got update time %v, want %v", got, update)
+		}
+	}
+
+	blog := &blogpb.Blog{Title: "Timed", CreateTime: epoch, UpdateTime: epoch}
+	res, err := f.blogs.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: blog})
+	if err != nil {
+		t.Fatalf("CreateBlog: %v", err)
+	}
+	blog = res.GetBlog()
+	wantTimes(blog, created, created)
+
+	now = now.Add(time.Minute)
+	blog.Title = "Updated"
+	blog.CreateTime = epoch
+	updated, err := f.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{Blog: blog})
+	if err != nil {
+		t.Fatalf("UpdateBlog: %v", err)
+	}
+	wantTimes(updated.GetBlog(), created, now)
+
+	now = now.Add(time.Minute)
+	patch := &blogpb.PatchBlogRequest{Blog: blog, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}}}
+	patched, err := f.blogs.PatchBlog(ctx, patch)
+	if err != nil {
+		t.Fatalf("PatchBlog: %v", err)
+	}
+	wantTimes(patched.GetBlog(), created, now)
+
+	read, err := f.blogs.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: blog.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	wantTimes(read.GetBlog(), created, now)
+}
+
+func TestUpdateBlog(t *testing.T) {
+	update := func(subject string, edit func(f *fixture, req *blogpb.UpdateBlogRequest)) func(f *fixture) error {
+		return func(f *fixture) error {
+			req := &blogpb.UpdateBlogRequest{Blog: &blogpb.Blog{Id: f.blog.GetId(), AuthorId: "alice", Title: "Changed"}}
+			if edit != nil {
+				edit(f, req)
+			}
+			ctx := context.Background()
+			if subject != "" {
+				ctx = f.as(subject)
+			}
+			_, err := f.blogs.UpdateBlog(ctx, req)
+			return err
+		}
+	}
+	admin := func(f *fixture) error {
+		req := &blogpb.UpdateBlogRequest{Blog: &blogpb.Blog{Id: f.blog.GetId(), AuthorId: "bob", Title: "Handed over"}}
+		_, err := f.blogs.UpdateBlog(f.as("admin", auth.AdminRole), req)
+		return err
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: update("alice", nil), want: codes.OK},
+		{name: "current version", call: update("alice", func(f *fixture, req *blogpb.UpdateBlogRequest) {
+			req.ExpectedVersion = f.blog.GetVersion()
+		}), want: codes.OK},
+		{name: "admin hands over", call: admin, want: codes.OK},
+		{name: "anonymous", call: update("", nil), want: codes.Unauthenticated},
+		{name: "not the owner", call: update("bob", nil), want: codes.PermissionDenied},
+		{name: "hand over", call: update("alice", func(f *fixture, req *blogpb.UpdateBlogRequest) {
+			req.Blog.AuthorId = "bob"
+		}), want: codes.PermissionDenied},
+		{name: "hand over to no author profile", call: func(f *fixture) error {
+			req := &blogpb.UpdateBlogRequest{Blog: &blogpb.Blog{Id: f.blog.GetId(), AuthorId: "carol", Title: "Handed over"}}
+			_, err := f.blogs.UpdateBlog(f.as("admin", auth.AdminRole), req)
+			return err
+		}, want: codes.FailedPrecondition},
+		{name: "bad id", call: update("alice", func(f *fixture, req *blogpb.UpdateBlogRequest) {
+			req.Blog.Id = "nope"
+		}), want: codes.InvalidArgument},
+		{name: "missing", call: update("alice", func(f *fixture, req *blogpb.UpdateBlogRequest) {
+			req.Blog.Id = missingID
+		}), want: codes.NotFound},
+		{name: "trashed", call: update("bob", func(f *fixture, req *blogpb.UpdateBlogRequest) {
+			req.Blog.Id = f.trashed.GetId()
+			req.Blog.AuthorId = "bob"
+		}), want: codes.NotFound},
+		{name: "version mismatch", call: update("alice", func(f *fixture, req *blogpb.UpdateBlogRequest) {
+			req.ExpectedVersion = f.blog.GetVersion() + 1
+		}), want: codes.Aborted},
+		{name: "store unavailable", storeErr: errDown, call: update("alice", nil), want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: update("alice", nil), want: codes.Internal},
+	})
+}
+
+func TestUpdateBlogVersions(t *testing.T) {
+	f := newFixture(t)
+	if v := f.blog.GetVersion(); v != 1 {
+		t.Fatalf("created blog has version %v, want 1", v)
+	}
+	ctx := f.as("alice")
+	req := &blogpb.UpdateBlogRequest{Blog: &blogpb.Blog{Id: f.blog.GetId(), AuthorId: "alice", Title: "Second"}, ExpectedVersion: 1}
+	res, err := f.blogs.UpdateBlog(ctx, req)
+	if err != nil {
+		t.Fatalf("UpdateBlog: %v", err)
+	}
+	if v := res.GetBlog().GetVersion(); v != 2 {
+		t.Errorf("updated blog has version %v, want 2", v)
+	}
+
+	// A writer that read the blog before the update loses
+	req.Blog.Title = "Lost"
+	if _, err := f.blogs.UpdateBlog(ctx, req); status.Code(err) != codes.Aborted {
+		t.Errorf("UpdateBlog of version 1: got %v, want Aborted", err)
+	}
+	read, err := f.blogs.ReadBlog(ctx, &blogpb.ReadBlogRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if got := read.GetBlog(); got.GetTitle() != "Second" || got.GetVersion() != 2 {
+		t.Errorf("read %q version %v, want Second version 2", got.GetTitle(), got.GetVersion())
+	}
+}
+
+func TestPatchBlog(t *testing.T) {
+	patch := func(subject string, id func(f *fixture) string, paths ...string) func(f *fixture) error {
+		return func(f *fixture) error {
+			req := &blogpb.PatchBlogRequest{
+				Blog:       &blogpb.Blog{Id: id(f), Title: "Patched"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+			}
+			_, err := f.blogs.PatchBlog(f.as(subject), req)
+			return err
+		}
+	}
+	versioned := func(version int64) func(f *fixture) error {
+		return func(f *fixture) error {
+			req := &blogpb.PatchBlogRequest{
+				Blog:            &blogpb.Blog{Id: f.blog.GetId(), Title: "Patched"},
+				UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+				ExpectedVersion: version,
+			}
+			_, err := f.blogs.PatchBlog(f.as("alice"), req)
+			return err
+		}
+	}
+	live := func(f *fixture) string { return f.blog.GetId() }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: patch("alice", live, "title"), want: codes.OK},
+		{name: "current version", call: versioned(1), want: codes.OK},
+		{name: "empty mask", call: patch("alice", live), want: codes.InvalidArgument},
+		{name: "unknown path", call: patch("alice", live, "views"), want: codes.InvalidArgument},
+		{name: "id path", call: patch("alice", live, "id"), want: codes.InvalidArgument},
+		{name: "not the owner", call: patch("bob", live, "title"), want: codes.PermissionDenied},
+		{name: "bad id", call: patch("alice", func(f *fixture) string { return "nope" }, "title"), want: codes.InvalidArgument},
+		{name: "missing", call: patch("alice", func(f *fixture) string { return missingID }, "title"), want: codes.NotFound},
+		{name: "version mismatch", call: versioned(2), want: codes.Aborted},
+		{name: "store unavailable", storeErr: errDown, call: patch("alice", live, "title"), want: codes.Unavailable},
+	})
+}
+
+func TestPatchBlogKeepsOtherFields(t *testing.T) {
+	f := newFixture(t)
+	req := &blogpb.PatchBlogRequest{
+		// Only the fields in the mask are taken from the request
+		Blog:       &blogpb.Blog{Id: f.blog.GetId(), Title: "Patched", Content: "ignored"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	}
+	res, err := f.blogs.PatchBlog(f.as("alice"), req)
+	if err != nil {
+		t.Fatalf("PatchBlog: %v", err)
+	}
+	got := res.GetBlog()
+	if got.GetTitle() != "Patched" || got.GetContent() != f.blog.GetContent() || got.GetAuthorId() != "alice" || got.GetVersion() != 2 {
+		t.Errorf("patched %v, want the new title, the old content and author, and version 2", got)
+	}
+
+	// An empty value in the mask clears the field
+	req.UpdateMask.Paths = []string{"content"}
+	req.Blog.Content = ""
+	res, err = f.blogs.PatchBlog(f.as("alice"), req)
+	if err != nil {
+		t.Fatalf("PatchBlog: %v", err)
+	}
+	if got := res.GetBlog(); got.GetTitle() != "Patched" || got.GetContent() != "" {
+		t.Errorf("patched %v, want the content cleared", got)
+	}
+}
+
+func TestDeleteBlog(t *testing.T) {
+	del := func(subject string, req func(f *fixture) *blogpb.DeleteBlogRequest) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.DeleteBlog(f.as(subject), req(f))
+			return err
+		}
+	}
+	live := func(f *fixture) *blogpb.DeleteBlogRequest {
+		return &blogpb.DeleteBlogRequest{BlogId: f.blog.GetId()}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: del("alice", live), want: codes.OK},
+		{name: "current version", call: del("alice", func(f *fixture) *blogpb.DeleteBlogRequest {
+			return &blogpb.DeleteBlogRequest{BlogId: f.blog.GetId(), ExpectedVersion: f.blog.GetVersion()}
+		}), want: codes.OK},
+		{name: "admin", call: func(f *fixture) error {
+			_, err := f.blogs.DeleteBlog(f.as("admin", auth.AdminRole), live(f))
+			return err
+		}, want: codes.OK},
+		{name: "anonymous", call: func(f *fixture) error {
+			_, err := f.blogs.DeleteBlog(context.Background(), live(f))
+			return err
+		}, want: codes.Unauthenticated},
+		{name: "not the owner", call: del("bob", live), want: codes.PermissionDenied},
+		{name: "bad id", call: del("alice", func(f *fixture) *blogpb.DeleteBlogRequest {
+			return &blogpb.DeleteBlogRequest{BlogId: "nope"}
+		}), want: codes.InvalidArgument},
+		{name: "missing", call: del("alice", func(f *fixture) *blogpb.DeleteBlogRequest {
+			return &blogpb.DeleteBlogRequest{BlogId: missingID}
+		}), want: codes.NotFound},
+		{name: "already trashed", call: del("bob", func(f *fixture) *blogpb.DeleteBlogRequest {
+			return &blogpb.DeleteBlogRequest{BlogId: f.trashed.GetId()}
+		}), want: codes.NotFound},
+		{name: "version mismatch", call: del("alice", func(f *fixture) *blogpb.DeleteBlogRequest {
+			return &blogpb.DeleteBlogRequest{BlogId: f.blog.GetId(), ExpectedVersion: f.blog.GetVersion() + 1}
+		}), want: codes.Aborted},
+		{name: "store unavailable", storeErr: errDown, call: del("alice", live), want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: del("alice", live), want: codes.Internal},
+	})
+}
+
+func TestRestoreBlog(t *testing.T) {
+	restore := func(subject string, id func(f *fixture) string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.RestoreBlog(f.as(subject), &blogpb.RestoreBlogRequest{BlogId: id(f)})
+			return err
+		}
+	}
+	trashed := func(f *fixture) string { return f.trashed.GetId() }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: restore("bob", trashed), want: codes.OK},
+		{name: "not the owner", call: restore("alice", trashed), want: codes.PermissionDenied},
+		{name: "not in the trash", call: restore("alice", func(f *fixture) string { return f.blog.GetId() }), want: codes.FailedPrecondition},
+		{name: "bad id", call: restore("bob", func(f *fixture) string { return "nope" }), want: codes.InvalidArgument},
+		{name: "missing", call: restore("bob", func(f *fixture) string { return missingID }), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: restore("bob", trashed), want: codes.Unavailable},
+	})
+}
+
+func TestGetBlogRevision(t *testing.T) {
+	get := func(id func(f *fixture) string, version int64) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.GetBlogRevision(context.Background(), &blogpb.GetBlogRevisionRequest{BlogId: id(f), Version: version})
+			return err
+		}
+	}
+	live := func(f *fixture) string { return f.blog.GetId() }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: get(live, 1), want: codes.OK},
+		{name: "missing version", call: get(live, 99), want: codes.NotFound},
+		{name: "missing blog", call: get(func(f *fixture) string { return missingID }, 1), want: codes.NotFound},
+		{name: "bad id", call: get(func(f *fixture) string { return "nope" }, 1), want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: get(live, 1), want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: get(live, 1), want: codes.Internal},
+	})
+}
+
+func TestRevertBlog(t *testing.T) {
+	revert := func(subject string, version int64) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.RevertBlog(f.as(subject), &blogpb.RevertBlogRequest{BlogId: f.blog.GetId(), Version: version})
+			return err
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: revert("alice", 1), want: codes.OK},
+		{name: "not the owner", call: revert("bob", 1), want: codes.PermissionDenied},
+		{name: "missing version", call: revert("alice", 99), want: codes.NotFound},
+		{name: "missing blog", call: func(f *fixture) error {
+			_, err := f.blogs.RevertBlog(f.as("alice"), &blogpb.RevertBlogRequest{BlogId: missingID, Version: 1})
+			return err
+		}, want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: revert("alice", 1), want: codes.Unavailable},
+	})
+}
+
+func TestListTags(t *testing.T) {
+	list := func(f *fixture) error {
+		_, err := f.blogs.ListTags(context.Background(), &blogpb.ListTagsRequest{})
+		return err
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: list, want: codes.OK},
+		{name: "store unavailable", storeErr: errDown, call: list, want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: list, want: codes.Internal},
+	})
+}
+
+func TestListBlog(t *testing.T) {
+	list := func(req *blogpb.ListBlogRequest) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.ListBlog(context.Background(), req)
+			return drain(stream, err, &blogpb.ListBlogResponse{})
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: list(&blogpb.ListBlogRequest{PageSize: 1}), want: codes.OK},
+		{name: "negative page size", call: list(&blogpb.ListBlogRequest{PageSize: -1}), want: codes.InvalidArgument},
+		{name: "bad page token", call: list(&blogpb.ListBlogRequest{PageToken: "nope"}), want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: list(&blogpb.ListBlogRequest{}), want: codes.Unavailable},
+		{name: "store error", storeErr: errBroken, call: list(&blogpb.ListBlogRequest{}), want: codes.Internal},
+	})
+}
+
+func TestListDeletedBlogs(t *testing.T) {
+	list := func(ctx func(f *fixture) context.Context, req *blogpb.ListDeletedBlogsRequest) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.ListDeletedBlogs(ctx(f), req)
+			return drain(stream, err, &blogpb.ListDeletedBlogsResponse{})
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: list(asUser("bob"), &blogpb.ListDeletedBlogsRequest{}), want: codes.OK},
+		{name: "anonymous", call: list(anonymous, &blogpb.ListDeletedBlogsRequest{}), want: codes.Unauthenticated},
+		{name: "bad page token", call: list(asUser("bob"), &blogpb.ListDeletedBlogsRequest{PageToken: "nope"}), want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: list(asUser("bob"), &blogpb.ListDeletedBlogsRequest{}), want: codes.Unavailable},
+	})
+}
+
+func TestTrashIsPrivate(t *testing.T) {
+	f := newFixture(t)
+	// A draft in the trash too, which only its author and admins may see anyway
+	if _, err := f.blogs.DeleteBlog(f.as("alice"), &blogpb.DeleteBlogRequest{BlogId: f.draft.GetId()}); err != nil {
+		t.Fatalf("DeleteBlog: %v", err)
+	}
+	trash := func(ctx context.Context) []string {
+		t.Helper()
+		stream, err := f.blogs.ListDeletedBlogs(ctx, &blogpb.ListDeletedBlogsRequest{})
+		if err != nil {
+			t.Fatalf("ListDeletedBlogs: %v", err)
+		}
+		var titles []string
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("ListDeletedBlogs: %v", err)
+			}
+			titles = append(titles, res.GetBlog().GetTitle())
+		}
+		sort.Strings(titles)
+		return titles
+	}
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"alice", f.as("alice"), "Work in progress"},
+		{"bob", f.as("bob"), "Old news"},
+		{"carol", f.as("carol"), ""},
+		{"admin", f.as("admin", auth.AdminRole), "Old news, Work in progress"},
+	} {
+		if got := strings.Join(trash(tt.ctx), ", "); got != tt.want {
+			t.Errorf("%v: got trash %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListBlogsByTag(t *testing.T) {
+	list := func(tag string) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.ListBlogsByTag(context.Background(), &blogpb.ListBlogsByTagRequest{Tag: tag})
+			return drain(stream, err, &blogpb.ListBlogsByTagResponse{})
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: list("Go"), want: codes.OK},
+		{name: "no tag", call: list(" "), want: codes.InvalidArgument},
+		{name: "bad page token", call: func(f *fixture) error {
+			stream, err := f.blogs.ListBlogsByTag(context.Background(), &blogpb.ListBlogsByTagRequest{Tag: "go", PageToken: "nope"})
+			return drain(stream, err, &blogpb.ListBlogsByTagResponse{})
+		}, want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: list("go"), want: codes.Unavailable},
+	})
+}
+
+func TestListBlogRevisions(t *testing.T) {
+	list := func(id func(f *fixture) string) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.ListBlogRevisions(context.Background(), &blogpb.ListBlogRevisionsRequest{BlogId: id(f)})
+			return drain(stream, err, &blogpb.ListBlogRevisionsResponse{})
+		}
+	}
+	live := func(f *fixture) string { return f.blog.GetId() }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: list(live), want: codes.OK},
+		{name: "bad id", call: list(func(f *fixture) string { return "nope" }), want: codes.InvalidArgument},
+		{name: "missing", call: list(func(f *fixture) string { return missingID }), want: codes.NotFound},
+		{name: "store unavailable", storeErr: errDown, call: list(live), want: codes.Unavailable},
+	})
+}
+
+func TestDeleteAndRestoreRecordRevisions(t *testing.T) {
+	f := newFixture(t)
+	ctx := f.as("alice")
+	if _, err := f.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: f.blog.GetId()}); err != nil {
+		t.Fatalf("DeleteBlog: %v", err)
+	}
+	restored, err := f.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("RestoreBlog: %v", err)
+	}
+	if v := restored.GetBlog().GetVersion(); v != 3 {
+		t.Fatalf("restored blog has version %v, want 3", v)
+	}
+
+	// Every version has its revision
+	stream, err := f.blogs.ListBlogRevisions(ctx, &blogpb.ListBlogRevisionsRequest{BlogId: f.blog.GetId()})
+	if err != nil {
+		t.Fatalf("ListBlogRevisions: %v", err)
+	}
+	var versions []int64
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ListBlogRevisions: %v", err)
+		}
+		versions = append(versions, res.GetRevision().GetVersion())
+		if editor := res.GetRevision().GetEditor(); editor != "alice" {
+			t.Errorf("revision %v has editor %q, want alice", res.GetRevision().GetVersion(), editor)
+		}
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("got revisions %v, want %v", versions, want)
+	}
+}
+
+func TestSearchBlogs(t *testing.T) {
+	search := func(query string, limit int32) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.SearchBlogs(context.Background(), &blogpb.SearchBlogsRequest{Query: query, Limit: limit})
+			return drain(stream, err, &blogpb.SearchBlogsResponse{})
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: search("streaming", 0), want: codes.OK},
+		{name: "no query", call: search("", 0), want: codes.InvalidArgument},
+		{name: "negative limit", call: search("streaming", -1), want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: search("streaming", 0), want: codes.Unavailable},
+	})
+}
+
+func TestSearchBlogsLimitsTheVisibleBlogs(t *testing.T) {
+	f := newFixture(t)
+	// A draft that matches better than the published blog, and only bob can see
+	draft := &blogpb.Blog{Title: "Streaming", Content: "Streaming, streaming and more streaming"}
+	if _, err := f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: draft}); err != nil {
+		t.Fatalf("CreateBlog: %v", err)
+	}
+	search := func(ctx context.Context) []string {
+		t.Helper()
+		stream, err := f.blogs.SearchBlogs(ctx, &blogpb.SearchBlogsRequest{Query: "streaming", Limit: 1})
+		if err != nil {
+			t.Fatalf("SearchBlogs: %v", err)
+		}
+		var titles []string
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return titles
+			}
+			if err != nil {
+				t.Fatalf("SearchBlogs: %v", err)
+			}
+			titles = append(titles, res.GetBlog().GetTitle())
+		}
+	}
+	if got := search(context.Background()); len(got) != 1 || got[0] != f.blog.GetTitle() {
+		t.Errorf("got %q, want the published blog", got)
+	}
+	if got := search(f.as("bob")); len(got) != 1 || got[0] != "Streaming" {
+		t.Errorf("got %q for bob, want his draft", got)
+	}
+}
+
+func TestWatchBlogs(t *testing.T) {
+	watch := func(req *blogpb.WatchBlogsRequest) func(f *fixture) error {
+		return func(f *fixture) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			stream, err := f.blogs.WatchBlogs(ctx, req)
+			if err != nil {
+				return err
+			}
+			// The watch may start after the first blogs were created, so keep creating them
+			go func() {
+				for ctx.Err() == nil {
+					f.blogs.CreateBlog(f.as("alice"), &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{Title: "Watched", Status: blogpb.Blog_PUBLISHED}})
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+			res, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			if res.GetType() != blogpb.WatchBlogsResponse_CREATED {
+				return fmt.Errorf("got event %v, want CREATED", res.GetType())
+			}
+			return nil
+		}
+	}
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: watch(&blogpb.WatchBlogsRequest{AuthorId: "alice"}), want: codes.OK},
+		{name: "bad blog id", call: watch(&blogpb.WatchBlogsRequest{BlogId: "nope"}), want: codes.InvalidArgument},
+		{name: "bad resume token", call: watch(&blogpb.WatchBlogsRequest{ResumeToken: "nope"}), want: codes.InvalidArgument},
+	})
+}
+
+func TestExportBlogs(t *testing.T) {
+	export := func(ctx func(f *fixture) context.Context) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.ExportBlogs(ctx(f), &blogpb.ExportBlogsRequest{IncludeDeleted: true})
+			return drain(stream, err, &blogpb.ExportBlogsResponse{})
+		}
+	}
+	admin := export(func(f *fixture) context.Context { return f.as("admin", auth.AdminRole) })
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: admin, want: codes.OK},
+		{name: "anonymous", call: export(func(f *fixture) context.Context { return context.Background() }), want: codes.Unauthenticated},
+		{name: "not an admin", call: export(func(f *fixture) context.Context { return f.as("alice") }), want: codes.PermissionDenied},
+		{name: "store unavailable", storeErr: errDown, call: admin, want: codes.Unavailable},
+	})
+}
+
+func TestBulkCreateBlogs(t *testing.T) {
+	// bulk creates two blogs, and checks how many of them failed
+	bulk := func(ctx func(f *fixture) context.Context, wantFailed int64) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.BulkCreateBlogs(ctx(f))
+			if err != nil {
+				return err
+			}
+			for _, title := range []string{"One", "Two"} {
+				if err := stream.Send(&blogpb.BulkCreateBlogsRequest{Blog: &blogpb.Blog{Title: title}}); err != nil {
+					break
+				}
+			}
+			res, err := stream.CloseAndRecv()
+			if err != nil {
+				return err
+			}
+			if res.GetFailed() != wantFailed {
+				return fmt.Errorf("got %v failed blogs, want %v", res.GetFailed(), wantFailed)
+			}
+			return nil
+		}
+	}
+	alice := func(f *fixture) context.Context { return f.as("alice") }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: bulk(alice, 0), want: codes.OK},
+		{name: "anonymous", call: bulk(func(f *fixture) context.Context { return context.Background() }, 0), want: codes.Unauthenticated},
+		{name: "no author profile", call: bulk(func(f *fixture) context.Context { return f.as("carol") }, 2), want: codes.OK},
+		{name: "store unavailable", storeErr: errDown, call: bulk(alice, 2), want: codes.OK},
+	})
+}
+
+func TestBulkCreateBlogsAuthors(t *testing.T) {
+	f := newFixture(t)
+	stream, err := f.blogs.BulkCreateBlogs(f.as("admin", auth.AdminRole))
+	if err != nil {
+		t.Fatalf("BulkCreateBlogs: %v", err)
+	}
+	// Admins import the blogs of other authors, and the blogs without an author are their own
+	for _, author := range []string{"bob", "carol", "", "alice"} {
+		if err := stream.Send(&blogpb.BulkCreateBlogsRequest{Blog: &blogpb.Blog{AuthorId: author, Title: "Imported"}}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if res.GetCreated() != 3 || res.GetFailed() != 1 {
+		t.Errorf("created %v and failed %v blogs, want 3 and 1", res.GetCreated(), res.GetFailed())
+	}
+	for i, want := range []string{"bob", "", "admin", "alice"} {
+		result := res.GetResults()[i]
+		if result.GetIndex() != int64(i) {
+			t.Errorf("result %v has index %v", i, result.GetIndex())
+		}
+		if want == "" {
+			if result.GetError() == "" || result.GetBlogId() != "" {
+				t.Errorf("result %v: got %v, want an error", i, result)
+			}
+			continue
+		}
+		read, err := f.blogs.ReadBlog(f.as("admin", auth.AdminRole), &blogpb.ReadBlogRequest{BlogId: result.GetBlogId()})
+		if err != nil {
+			t.Fatalf("ReadBlog of result %v: %v", i, err)
+		}
+		if got := read.GetBlog().GetAuthorId(); got != want {
+			t.Errorf("result %v has author %q, want %q", i, got, want)
+		}
+	}
+
+	// Other callers only create their own blogs
+	stream, err = f.blogs.BulkCreateBlogs(f.as("alice"))
+	if err != nil {
+		t.Fatalf("BulkCreateBlogs: %v", err)
+	}
+	if err := stream.Send(&blogpb.BulkCreateBlogsRequest{Blog: &blogpb.Blog{AuthorId: "bob", Title: "Not bob's"}}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	res, err = stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	read, err := f.blogs.ReadBlog(f.as("alice"), &blogpb.ReadBlogRequest{BlogId: res.GetResults()[0].GetBlogId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if got := read.GetBlog().GetAuthorId(); got != "alice" {
+		t.Errorf("got author %q, want alice", got)
+	}
+}
+
+func TestImportBlogs(t *testing.T) {
+	// imp imports blog, and checks how many blogs failed
+	imp := func(ctx func(f *fixture) context.Context, blog func(f *fixture) *blogpb.Blog, wantFailed int64) func(f *fixture) error {
+		return func(f *fixture) error {
+			stream, err := f.blogs.ImportBlogs(ctx(f))
+			if err != nil {
+				return err
+			}
+			stream.Send(&blogpb.ImportBlogsRequest{Data: &blogpb.ImportBlogsRequest_Blog{Blog: blog(f)}})
+			res, err := stream.CloseAndRecv()
+			if err != nil {
+				return err
+			}
+			if res.GetFailed() != wantFailed {
+				return fmt.Errorf("got %v failed blogs, want %v", res.GetFailed(), wantFailed)
+			}
+			return nil
+		}
+	}
+	admin := func(f *fixture) context.Context { return f.as("admin", auth.AdminRole) }
+	fresh := func(f *fixture) *blogpb.Blog { return &blogpb.Blog{AuthorId: "bob", Title: "Imported"} }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: imp(admin, fresh, 0), want: codes.OK},
+		{name: "existing id", call: imp(admin, func(f *fixture) *blogpb.Blog { return f.blog }, 1), want: codes.OK},
+		{name: "no author", call: imp(admin, func(f *fixture) *blogpb.Blog { return &blogpb.Blog{Title: "Imported"} }, 1), want: codes.OK},
+		{name: "bad id", call: imp(admin, func(f *fixture) *blogpb.Blog { return &blogpb.Blog{Id: "nope", AuthorId: "bob"} }, 1), want: codes.OK},
+		{name: "not an admin", call: imp(func(f *fixture) context.Context { return f.as("alice") }, fresh, 0), want: codes.PermissionDenied},
+		{name: "anonymous", call: imp(func(f *fixture) context.Context { return context.Background() }, fresh, 0), want: codes.Unauthenticated},
+		{name: "store unavailable", storeErr: errDown, call: imp(admin, fresh, 1), want: codes.OK},
+	})
+}
+
+// exportAll returns all items that ExportBlogs streams, in order.
+func exportAll(t *testing.T, f *fixture) []*blogpb.ExportBlogsResponse {
+	t.Helper()
+	stream, err := f.blogs.ExportBlogs(f.as("admin", auth.AdminRole), &blogpb.ExportBlogsRequest{IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("ExportBlogs: %v", err)
+	}
+	var items []*blogpb.ExportBlogsResponse
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return items
+		}
+		if err != nil {
+			t.Fatalf("ExportBlogs: %v", err)
+		}
+		items = append(items, res)
+	}
+}
+
+// importAll sends reqs to ImportBlogs and returns the response.
+func importAll(t *testing.T, f *fixture, reqs []*blogpb.ImportBlogsRequest) *blogpb.ImportBlogsResponse {
+	t.Helper()
+	stream, err := f.blogs.ImportBlogs(f.as("admin", auth.AdminRole))
+	if err != nil {
+		t.Fatalf("ImportBlogs: %v", err)
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("ImportBlogs: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("ImportBlogs: %v", err)
+	}
+	return res
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	f := newFixture(t)
+	reply := &blogpb.Comment{BlogId: f.blog.GetId(), ParentId: f.comment.GetId(), Content: "Thanks"}
+	if _, err := f.comments.CreateComment(f.as("alice"), &blogpb.CreateCommentRequest{Comment: reply}); err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	if _, err := f.authors.UpdateAuthor(f.as("alice"), &blogpb.UpdateAuthorRequest{Author: &blogpb.Author{Id: "alice", DisplayName: "Alice"}}); err != nil {
+		t.Fatalf("UpdateAuthor: %v", err)
+	}
+	exported := exportAll(t, f)
+
+	kinds := map[string]int{}
+	var reqs []*blogpb.ImportBlogsRequest
+	for _, item := range exported {
+		// Both messages have the same fields, as a backup file stores them
+		b, err := proto.Marshal(item)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		req := &blogpb.ImportBlogsRequest{}
+		if err := proto.Unmarshal(b, req); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		reqs = append(reqs, req)
+		switch {
+		case req.GetAuthor() != nil:
+			kinds["author"]++
+		case req.GetBlog() != nil:
+			kinds["blog"]++
+		case req.GetRevision() != nil:
+			kinds["revision"]++
+		case req.GetComment() != nil:
+			kinds["comment"]++
+		}
+	}
+	// One revision for every created blog, and one for moving the blog of bob to the trash
+	want := map[string]int{"author": 3, "blog": 3, "revision": 4, "comment": 2}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("exported %v, want %v", kinds, want)
+	}
+
+	// Restore into an empty store
+	g := newFixture(t)
+	g.store.Store = newMemoryStore()
+	res := importAll(t, g, reqs)
+	if res.GetFailed() != 0 || res.GetImported() != int64(len(reqs)) {
+		t.Fatalf("imported %v, failed %v: %v", res.GetImported(), res.GetFailed(), res.GetFailures())
+	}
+	restored := exportAll(t, g)
+	if len(restored) != len(exported) {
+		t.Fatalf("restored %v items, want %v", len(restored), len(exported))
+	}
+	for i := range exported {
+		if !proto.Equal(restored[i], exported[i]) {
+			t.Errorf("restored item %v is %v, want %v", i, restored[i], exported[i])
+		}
+	}
+}
+
+func TestImportBlogsItems(t *testing.T) {
+	f := newFixture(t)
+	f.store.Store = newMemoryStore()
+	blogID := primitive.NewObjectID().Hex()
+	commentID := primitive.NewObjectID().Hex()
+	replyID := primitive.NewObjectID().Hex()
+	reqs := []*blogpb.ImportBlogsRequest{
+		{Data: &blogpb.ImportBlogsRequest_Author{Author: &blogpb.Author{Id: "carol"}}},
+		{Data: &blogpb.ImportBlogsRequest_Author{Author: &blogpb.Author{Id: "carol"}}},
+		{Data: &blogpb.ImportBlogsRequest_Blog{Blog: &blogpb.Blog{Id: blogID, AuthorId: "carol", Title: "Imported", Version: 2}}},
+		// A reply before the comment it replies to
+		{Data: &blogpb.ImportBlogsRequest_Comment{Comment: &blogpb.Comment{Id: replyID, BlogId: blogID, ParentId: commentID, AuthorId: "carol", Content: "Me too"}}},
+		{Data: &blogpb.ImportBlogsRequest_Comment{Comment: &blogpb.Comment{Id: commentID, BlogId: blogID, AuthorId: "carol", Content: "First"}}},
+		{Data: &blogpb.ImportBlogsRequest_Revision{Revision: &blogpb.BlogRevision{BlogId: primitive.NewObjectID().Hex(), AuthorId: "carol", Version: 1}}},
+		{},
+	}
+	res := importAll(t, f, reqs)
+	var failed []int64
+	for _, failure := range res.GetFailures() {
+		failed = append(failed, failure.GetIndex())
+	}
+	// The duplicate author, the early reply, the revision of an unknown blog, and the empty item
+	if want := []int64{1, 3, 5, 6}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed items %v, want %v: %v", failed, want, res.GetFailures())
+	}
+	if res.GetImported() != 3 {
+		t.Errorf("imported %v items, want 3", res.GetImported())
+	}
+
+	// The backup has no revisions of the blog, so the import records one of its current version
+	oid, _ := primitive.ObjectIDFromHex(blogID)
+	var versions []int64
+	f.store.ListRevisions(context.Background(), oid, func(rev *revisionItem) error {
+		versions = append(versions, rev.Version)
+		return nil
+	})
+	if want := []int64{2}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("got revisions %v, want %v", versions, want)
+	}
+}
+
+func TestImportBlogsDropsMissingAttachments(t *testing.T) {
+	f := newFixture(t)
+	kept, err := f.store.CreateAttachment(context.Background(), &attachmentItem{OwnerID: "carol", ContentType: "text/plain"})
+	if err != nil {
+		t.Fatalf("CreateAttachment: %v", err)
+	}
+	missing := primitive.NewObjectID()
+	blog := &blogpb.Blog{Id: primitive.NewObjectID().Hex(), AuthorId: "carol", Title: "Imported", AttachmentIds: []string{missing.Hex(), kept.ID.Hex()}}
+	res := importAll(t, f, []*blogpb.ImportBlogsRequest{{Data: &blogpb.ImportBlogsRequest_Blog{Blog: blog}}})
+	if res.GetFailed() != 0 {
+		t.Fatalf("import failed: %v", res.GetFailures())
+	}
+	read, err := f.blogs.ReadBlog(f.as("carol"), &blogpb.ReadBlogRequest{BlogId: blog.GetId()})
+	if err != nil {
+		t.Fatalf("ReadBlog: %v", err)
+	}
+	if got, want := read.GetBlog().GetAttachmentIds(), []string{kept.ID.Hex()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got attachments %v, want %v", got, want)
+	}
+}
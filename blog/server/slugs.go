@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// maxSlugLength is the length of the slugs made from titles, before a collision suffix.
+	maxSlugLength = 80
+	// defaultSlug is the slug of blogs whose title has no letters or digits that we can transliterate.
+	defaultSlug = "blog"
+	// slugAttempts is how often a blog is written with a new slug, when another blog takes the
+	// slug between uniqueSlug finding it free and the write.
+	slugAttempts = 3
+)
+
+// transliterations spell the letters that do not decompose into a Latin letter and accents.
+var transliterations = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'þ': "th", 'ł': "l", 'ı': "i", 'ŋ': "ng",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k",
+	'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g",
+}
+
+// slugify makes a slug of title: lower case ASCII letters and digits, with single dashes
+// between the words. Accents are dropped and Greek and Cyrillic letters transliterated.
+func slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFC.String(strings.ToLower(title)) {
+		spelled, ok := spell(r)
+		if spelled == "" {
+			// Letters that are not spelled out, like the Cyrillic soft sign, do not split words
+			dash = dash || !ok && b.Len() > 0
+			continue
+		}
+		if b.Len()+len(spelled)+1 > maxSlugLength {
+			break
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteString(spelled)
+	}
+	if b.Len() == 0 {
+		return defaultSlug
+	}
+	return b.String()
+}
+
+// spell returns r in lower case ASCII letters and digits, and whether r is a letter or digit
+// that we know. Letters with accents are spelled without them, and NFKD splits ligatures
+// like "ﬁ" into their letters.
+func spell(r rune) (string, bool) {
+	if spelled, ok := transliterations[r]; ok {
+		return spelled, true
+	}
+	var b strings.Builder
+	for _, d := range norm.NFKD.String(string(r)) {
+		if spelled, ok := transliterations[d]; ok {
+			b.WriteString(spelled)
+		} else if d < unicode.MaxASCII && (unicode.IsLetter(d) || unicode.IsDigit(d)) {
+			b.WriteRune(unicode.ToLower(d))
+		}
+	}
+	return b.String(), b.Len() > 0
+}
+
+// uniqueSlug returns a slug for the blog with the given id and title, which no other blog has,
+// nor is reserved. Taken slugs get a suffix "-2", "-3" and so on. A blog gets its old slug back
+// when its title changes back.
+func (s *server) uniqueSlug(ctx context.Context, id primitive.ObjectID, title string, reserved map[string]bool) (string, error) {
+	base := slugify(title)
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		if reserved[slug] {
+			continue
+		}
+		data, err := s.store.ReadBySlug(ctx, slug)
+		if err == errNotFound || err == nil && data.ID == id {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// reserveSlugs adds slugs to reserved, unless one of them is reserved already.
+// It reports whether the slugs were added.
+func reserveSlugs(reserved map[string]bool, slugs []string) bool {
+	for _, slug := range slugs {
+		if reserved[slug] {
+			return false
+		}
+	}
+	for _, slug := range slugs {
+		reserved[slug] = true
+	}
+	return true
+}
+
+// withSlug returns slugs with slug as the current slug, at the end.
+func withSlug(slugs []string, slug string) []string {
+	res := make([]string, 0, len(slugs)+1)
+	for _, old := range slugs {
+		if old != slug {
+			res = append(res, old)
+		}
+	}
+	return append(res, slug)
+}
+
+// updateSlug gives data a new current slug if its title no longer matches the title it had, the old slug
+// redirects to the new one. Blogs from before the slugs get one on their first edit.
+func (s *server) updateSlug(ctx context.Context, data *blogItem, oldTitle string) error {
+	if len(data.Slugs) > 0 && slugify(data.Title) == slugify(oldTitle) {
+		return nil
+	}
+	slug, err := s.uniqueSlug(ctx, data.ID, data.Title, nil)
+	if err != nil {
+		return err
+	}
+	data.Slugs = withSlug(data.Slugs, slug)
+	return nil
+}
+
+// oldSlugs returns the slugs of data that redirect to its current slug.
+func oldSlugs(data *blogItem) []string {
+	if len(data.Slugs) < 2 {
+		return nil
+	}
+	return data.Slugs[:len(data.Slugs)-1]
+}
+
+// GetBlogBySlug is an RPC for the Blog Service to read a blog by the slug in its public URL
+func (s *server) GetBlogBySlug(ctx context.Context, req *blogpb.GetBlogBySlugRequest) (*blogpb.GetBlogBySlugResponse, error) {
+	log.Println("Invoked RPC GetBlogBySlug...")
+	slug := req.GetSlug()
+	data, err := s.store.ReadBySlug(ctx, slug)
+	if err == nil && (data.trashed() || !canSee(ctx, data)) {
+		err = errNotFound
+	}
+	if err != nil {
+		return nil, storeError(err, slugResource(slug))
+	}
+	blog, err := s.blogWithCounts(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.GetBlogBySlugResponse{Blog: blog, Redirect: data.slug() != slug}, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello gRPC", "hello-grpc"},
+		{"  What's new in Go 1.22?  ", "what-s-new-in-go-1-22"},
+		{"Crème brûlée à la Zoë", "creme-brulee-a-la-zoe"},
+		{"Straße, Øl und Æbler", "strasse-ol-und-aebler"},
+		{"Привет, мир", "privet-mir"},
+		{"Объявление", "obyavlenie"},
+		{"Καλημέρα κόσμε", "kalimera-kosme"},
+		{"ﬁnal ①", "final-1"},
+		{"你好", defaultSlug},
+		{"!!!", defaultSlug},
+		{strings.Repeat("word ", 30), strings.TrimSuffix(strings.Repeat("word-", 16), "-")},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.title); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestGetBlogBySlug(t *testing.T) {
+	get := func(ctx func(f *fixture) context.Context, slug string) func(f *fixture) error {
+		return func(f *fixture) error {
+			_, err := f.blogs.GetBlogBySlug(ctx(f), &blogpb.GetBlogBySlugRequest{Slug: slug})
+			return err
+		}
+	}
+	anonymous := func(f *fixture) context.Context { return context.Background() }
+	alice := func(f *fixture) context.Context { return f.as("alice") }
+	runRPCTests(t, []rpcTest{
+		{name: "ok", call: get(anonymous, "hello-grpc"), want: codes.OK},
+		{name: "draft", call: get(anonymous, "work-in-progress"), want: codes.NotFound},
+		{name: "own draft", call: get(alice, "work-in-progress"), want: codes.OK},
+		{name: "trashed", call: get(anonymous, "old-news"), want: codes.NotFound},
+		{name: "missing", call: get(anonymous, "no-such-blog"), want: codes.NotFound},
+		{name: "bad slug", call: get(anonymous, "Hello gRPC"), want: codes.InvalidArgument},
+		{name: "store unavailable", storeErr: errDown, call: get(anonymous, "hello-grpc"), want: codes.Unavailable},
+	})
+}
+
+func TestSlugs(t *testing.T) {
+	f := newFixture(t)
+	if got := f.blog.GetSlug(); got != "hello-grpc" {
+		t.Fatalf("got slug %q, want hello-grpc", got)
+	}
+
+	create := func(title string) *blogpb.Blog {
+		t.Helper()
+		res, err := f.blogs.CreateBlog(f.as("alice"), &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{Title: title, Status: blogpb.Blog_PUBLISHED}})
+		if err != nil {
+			t.Fatalf("CreateBlog: %v", err)
+		}
+		return res.GetBlog()
+	}
+	var slugs []string
+	for _, title := range []string{"Hello, gRPC!", "hello grpc", "Old news"} {
+		slugs = append(slugs, create(title).GetSlug())
+	}
+	// The blog in the trash keeps its slug
+	if got := fmt.Sprint(slugs); got != "[hello-grpc-2 hello-grpc-3 old-news-2]" {
+		t.Errorf("got slugs %v of blogs with the same titles, want suffixes", got)
+	}
+
+	rename := func(title string) *blogpb.Blog {
+		t.Helper()
+		req := &blogpb.PatchBlogRequest{Blog: &blogpb.Blog{Id: f.blog.GetId(), Title: title}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}}}
+		res, err := f.blogs.PatchBlog(f.as("alice"), req)
+		if err != nil {
+			t.Fatalf("PatchBlog: %v", err)
+		}
+		return res.GetBlog()
+	}
+	if blog := rename("Hello gRPC!"); blog.GetSlug() != "hello-grpc" || len(blog.GetOldSlugs()) != 0 {
+		t.Errorf("got slugs %q %v, want the slug to stay when the title makes the same slug", blog.GetSlug(), blog.GetOldSlugs())
+	}
+	blog := rename("Goodbye gRPC")
+	if blog.GetSlug() != "goodbye-grpc" || fmt.Sprint(blog.GetOldSlugs()) != "[hello-grpc]" {
+		t.Errorf("got slugs %q %v, want goodbye-grpc with hello-grpc as an old slug", blog.GetSlug(), blog.GetOldSlugs())
+	}
+
+	res, err := f.blogs.GetBlogBySlug(context.Background(), &blogpb.GetBlogBySlugRequest{Slug: "hello-grpc"})
+	if err != nil {
+		t.Fatalf("GetBlogBySlug of an old slug: %v", err)
+	}
+	if !res.GetRedirect() || res.GetBlog().GetSlug() != "goodbye-grpc" {
+		t.Errorf("got %v, want a redirect to goodbye-grpc", res)
+	}
+	// A new blog cannot take the old slug, but the blog itself can have it back
+	if got := create("Hello gRPC").GetSlug(); got != "hello-grpc-4" {
+		t.Errorf("got slug %q, want hello-grpc-4", got)
+	}
+	if blog := rename("Hello gRPC"); blog.GetSlug() != "hello-grpc" || fmt.Sprint(blog.GetOldSlugs()) != "[goodbye-grpc]" {
+		t.Errorf("got slugs %q %v, want hello-grpc back with goodbye-grpc as an old slug", blog.GetSlug(), blog.GetOldSlugs())
+	}
+}
+
+func TestSlugsOfBulkCreatedBlogs(t *testing.T) {
+	f := newFixture(t)
+	stream, err := f.blogs.BulkCreateBlogs(f.as("alice"))
+	if err != nil {
+		t.Fatalf("BulkCreateBlogs: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := stream.Send(&blogpb.BulkCreateBlogsRequest{Blog: &blogpb.Blog{Title: "Hello gRPC"}}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	var slugs []string
+	for _, result := range res.GetResults() {
+		read, err := f.blogs.ReadBlog(f.as("alice"), &blogpb.ReadBlogRequest{BlogId: result.GetBlogId()})
+		if err != nil {
+			t.Fatalf("ReadBlog: %v", err)
+		}
+		slugs = append(slugs, read.GetBlog().GetSlug())
+	}
+	if got := fmt.Sprint(slugs); got != "[hello-grpc-2 hello-grpc-3]" {
+		t.Errorf("got slugs %v, want distinct slugs within the batch", got)
+	}
+}
+
+func TestSlugTakenConcurrently(t *testing.T) {
+	f := newFixture(t)
+	f.store.racedSlugs = map[string]bool{"hello-grpc": true}
+	res, err := f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{Title: "Hello gRPC"}})
+	if err != nil {
+		t.Fatalf("CreateBlog: %v", err)
+	}
+	if got := res.GetBlog().GetSlug(); got != "hello-grpc-2" {
+		t.Errorf("got slug %q, want the next free one", got)
+	}
+
+	f.store.racedSlugs = map[string]bool{"hello-grpc-2": true}
+	blog := &blogpb.Blog{Id: f.draft.GetId(), AuthorId: "alice", Title: "Hello gRPC"}
+	updated, err := f.blogs.UpdateBlog(f.as("alice"), &blogpb.UpdateBlogRequest{Blog: blog})
+	if err != nil {
+		t.Fatalf("UpdateBlog: %v", err)
+	}
+	if got := updated.GetBlog().GetSlug(); got != "hello-grpc-3" {
+		t.Errorf("got slug %q, want the next free one", got)
+	}
+}
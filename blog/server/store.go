@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// blogItem is the representation of a blog in the storage backends.
+type blogItem struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	AuthorID string             `bson:"author_id"`
+	Content  string             `bson:"content"`
+	Title    string             `bson:"title"`
+	// Version is incremented on every write, starting at 1.
+	Version    int64     `bson:"version"`
+	CreateTime time.Time `bson:"create_time"`
+	UpdateTime time.Time `bson:"update_time"`
+	// DeleteTime is set when the blog has been moved to the trash.
+	DeleteTime time.Time `bson:"delete_time,omitempty"`
+	// Tags are normalized with normalizeTags.
+	Tags []string `bson:"tags,omitempty"`
+	// Status is empty for blogs from before the statuses, which were all public.
+	Status blogStatus `bson:"status,omitempty"`
+	// PublishTime is when the blog was published, or will be if it is scheduled.
+	PublishTime time.Time `bson:"publish_time,omitempty"`
+	// Slugs are the URL slugs of the blog, oldest first. The last one is current,
+	// the others redirect to it. No two blogs share a slug.
+	Slugs []string `bson:"slugs,omitempty"`
+	// AttachmentIDs are the attachments of the author that the blog uses.
+	AttachmentIDs []primitive.ObjectID `bson:"attachment_ids,omitempty"`
+}
+
+// trashed reports whether the blog has been moved to the trash.
+func (b *blogItem) trashed() bool {
+	return !b.DeleteTime.IsZero()
+}
+
+// slug returns the current slug of the blog, or "" for blogs from before the slugs.
+func (b *blogItem) slug() string {
+	if len(b.Slugs) == 0 {
+		return ""
+	}
+	return b.Slugs[len(b.Slugs)-1]
+}
+
+// status returns the status of the blog, blogs without one are published.
+func (b *blogItem) status() blogStatus {
+	if b.Status == "" {
+		return statusPublished
+	}
+	return b.Status
+}
+
+// blogStatus is the publication status of a blog in the storage backends.
+type blogStatus string
+
+const (
+	statusDraft     blogStatus = "draft"
+	statusScheduled blogStatus = "scheduled"
+	statusPublished blogStatus = "published"
+	statusArchived  blogStatus = "archived"
+)
+
+// revisionItem is an immutable snapshot of a blog, recorded on every edit.
+type revisionItem struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID primitive.ObjectID `bson:"blog_id"`
+	// Version is the version of the blog that the edit produced.
+	Version    int64     `bson:"version"`
+	AuthorID   string    `bson:"author_id"`
+	Title      string    `bson:"title"`
+	Content    string    `bson:"content"`
+	Editor     string    `bson:"editor"`
+	CreateTime time.Time `bson:"create_time"`
+}
+
+// commentItem is the representation of a comment on a blog in the storage backends.
+type commentItem struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID primitive.ObjectID `bson:"blog_id"`
+	// ParentID is the comment this is a reply to, or the zero id for top level comments.
+	ParentID   primitive.ObjectID `bson:"parent_id,omitempty"`
+	AuthorID   string             `bson:"author_id"`
+	Content    string             `bson:"content"`
+	CreateTime time.Time          `bson:"create_time"`
+	UpdateTime time.Time          `bson:"update_time"`
+}
+
+// authorItem is the representation of an author profile in the storage backends.
+type authorItem struct {
+	ID          string    `bson:"_id"`
+	DisplayName string    `bson:"display_name"`
+	Bio         string    `bson:"bio"`
+	AvatarURL   string    `bson:"avatar_url"`
+	CreateTime  time.Time `bson:"create_time"`
+	UpdateTime  time.Time `bson:"update_time"`
+}
+
+// attachmentItem is the representation of an uploaded file in the storage backends.
+// The content is in a blobStore, under its checksum.
+type attachmentItem struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	OwnerID     string             `bson:"owner_id"`
+	ContentType string             `bson:"content_type"`
+	Filename    string             `bson:"filename,omitempty"`
+	Size        int64              `bson:"size"`
+	// SHA256 is the checksum of the content in hexadecimal.
+	SHA256     string    `bson:"sha256"`
+	CreateTime time.Time `bson:"create_time"`
+}
+
+// countsItem is the engagement of a blog in the storage backends: how often it was viewed and reacted to.
+type countsItem struct {
+	Views int64 `bson:"views"`
+	// Reactions are the number of users who reacted to the blog with each reaction.
+	Reactions map[string]int64 `bson:"reactions,omitempty"`
+}
+
+// reactionItem is the reaction of a user to a blog. A user reacts at most once with each reaction.
+type reactionItem struct {
+	BlogID     primitive.ObjectID `bson:"blog_id"`
+	UserID     string             `bson:"user_id"`
+	Reaction   string             `bson:"reaction"`
+	CreateTime time.Time          `bson:"create_time"`
+}
+
+// blogCount is the number of views or reactions of a blog, for the rankings of an EngagementStore.
+type blogCount struct {
+	BlogID primitive.ObjectID `bson:"_id"`
+	Count  int64              `bson:"count"`
+}
+
+// engagementMetric is what an EngagementStore ranks the blogs by.
+type engagementMetric int
+
+const (
+	metricViews engagementMetric = iota
+	metricReactions
+)
+
+// firstHourIn returns the start of the first hour that starts at t or later. Views are counted by
+// the hour, and the views of an hour are in a window if the hour starts in it.
+func firstHourIn(t time.Time) time.Time {
+	return t.Add(time.Hour - time.Nanosecond).Truncate(time.Hour)
+}
+
+// errNotFound is returned by a BlogStore when no blog matches the given id.
+var errNotFound = errors.New("blog not found")
+
+// errBlogExists is returned by a BlogStore when inserting a blog with an id that is taken.
+var errBlogExists = errors.New("blog already exists")
+
+// errVersionMismatch is returned by a BlogStore when a write expects another version than the stored one.
+var errVersionMismatch = errors.New("blog version mismatch")
+
+// errSlugExists is returned by a BlogStore when writing a blog with a slug of another blog.
+var errSlugExists = errors.New("slug already taken")
+
+// errRevisionNotFound is returned by a BlogStore when a blog has no revision with the given version.
+var errRevisionNotFound = errors.New("revision not found")
+
+var (
+	// errCommentNotFound is returned by a CommentStore when no comment matches the given id.
+	errCommentNotFound = errors.New("comment not found")
+	// errCommentExists is returned by a CommentStore when inserting a comment with an id that is taken.
+	errCommentExists = errors.New("comment already exists")
+)
+
+// errAttachmentNotFound is returned by an AttachmentStore when no attachment matches.
+var errAttachmentNotFound = errors.New("attachment not found")
+
+var (
+	// errAuthorNotFound is returned by an AuthorStore when no author matches the given id.
+	errAuthorNotFound = errors.New("author not found")
+	// errAuthorExists is returned by an AuthorStore when creating an author with an id that is taken.
+	errAuthorExists = errors.New("author already exists")
+)
+
+// Store is the storage backend of the blog server, with all of its parts.
+type Store interface {
+	BlogStore
+	CommentStore
+	AuthorStore
+	AttachmentStore
+	EngagementStore
+}
+
+// BlogStore is the storage backend used by the blog server.
+// Implementations must be safe for concurrent use.
+type BlogStore interface {
+	// Create inserts a new blog and returns it with its assigned id and version 1.
+	// Create, CreateMany, Insert and Update return errSlugExists if a slug of the blog is taken.
+	Create(ctx context.Context, item *blogItem) (*blogItem, error)
+	// CreateMany inserts new blogs like Create, in one batch, and carries on past failed blogs.
+	// It returns the created blogs and the errors in the order of items, where either the blog
+	// or the error is nil.
+	CreateMany(ctx context.Context, items []*blogItem) ([]*blogItem, []error)
+	// Insert writes blogs as they are, with their ids, versions and timestamps, in one batch.
+	// It carries on past failed blogs, and returns the errors in the order of items,
+	// errBlogExists for the blogs with an id that is taken.
+	Insert(ctx context.Context, items []*blogItem) []error
+	// Read returns the blog with the given id, also if it is in the trash.
+	Read(ctx context.Context, id primitive.ObjectID) (*blogItem, error)
+	// ReadBySlug returns the blog with the given current or old slug, also if it is in the trash.
+	ReadBySlug(ctx context.Context, slug string) (*blogItem, error)
+	// Update replaces the blog with the same id as item, if the stored version
+	// still is item.Version, and returns it with the version incremented.
+	// The check and the write are atomic.
+	Update(ctx context.Context, item *blogItem) (*blogItem, error)
+	// Delete permanently removes the blog with the given id, its revisions, comments, views and reactions. If
+	// version is non-zero, the blog is only removed if the stored version matches.
+	Delete(ctx context.Context, id primitive.ObjectID, version int64) error
+	// List calls fn for every blog selected by q in the order of q, and stops at the first error.
+	List(ctx context.Context, q listQuery, fn func(*blogItem) error) error
+	// CountTags returns the number of published blogs outside the trash with each tag.
+	CountTags(ctx context.Context) (map[string]int64, error)
+
+	// AddRevision stores a new revision. Revisions are never modified.
+	AddRevision(ctx context.Context, rev *revisionItem) error
+	// ReadRevision returns the revision of a blog with the given version.
+	ReadRevision(ctx context.Context, blogID primitive.ObjectID, version int64) (*revisionItem, error)
+	// ListRevisions calls fn for every revision of a blog, oldest first, and stops at the first error.
+	ListRevisions(ctx context.Context, blogID primitive.ObjectID, fn func(*revisionItem) error) error
+}
+
+// CommentStore is the storage backend for comments on blogs.
+// Implementations must be safe for concurrent use.
+type CommentStore interface {
+	// CreateComment inserts a new comment and returns it with its assigned id.
+	CreateComment(ctx context.Context, item *commentItem) (*commentItem, error)
+	// InsertComment inserts a comment as it is, with its id, for restoring a backup.
+	InsertComment(ctx context.Context, item *commentItem) error
+	// ReadComment returns the comment with the given id.
+	ReadComment(ctx context.Context, id primitive.ObjectID) (*commentItem, error)
+	// UpdateComment replaces the comment with the same id as item.
+	UpdateComment(ctx context.Context, item *commentItem) (*commentItem, error)
+	// DeleteComments removes the comments with the given ids.
+	DeleteComments(ctx context.Context, ids []primitive.ObjectID) error
+	// ListComments calls fn for every comment on a blog, oldest first, and stops at the first error.
+	ListComments(ctx context.Context, blogID primitive.ObjectID, fn func(*commentItem) error) error
+}
+
+// AuthorStore is the storage backend for author profiles.
+// Implementations must be safe for concurrent use.
+type AuthorStore interface {
+	// CreateAuthor inserts a new author, the id is chosen by the caller.
+	CreateAuthor(ctx context.Context, item *authorItem) (*authorItem, error)
+	// ReadAuthor returns the author with the given id.
+	ReadAuthor(ctx context.Context, id string) (*authorItem, error)
+	// UpdateAuthor replaces the author with the same id as item.
+	UpdateAuthor(ctx context.Context, item *authorItem) (*authorItem, error)
+	// ListAuthors calls fn for every author in id order, and stops at the first error.
+	ListAuthors(ctx context.Context, fn func(*authorItem) error) error
+}
+
+// AttachmentStore is the storage backend for the metadata of attachments.
+// Implementations must be safe for concurrent use.
+type AttachmentStore interface {
+	// CreateAttachment inserts a new attachment and returns it with its assigned id.
+	CreateAttachment(ctx context.Context, item *attachmentItem) (*attachmentItem, error)
+	// ReadAttachment returns the attachment with the given id.
+	ReadAttachment(ctx context.Context, id primitive.ObjectID) (*attachmentItem, error)
+	// FindAttachment returns an attachment of the owner with the given checksum and content type.
+	FindAttachment(ctx context.Context, ownerID, sha256, contentType string) (*attachmentItem, error)
+	// AttachmentUsage returns the total size of the attachments of the owner.
+	AttachmentUsage(ctx context.Context, ownerID string) (int64, error)
+}
+
+// EngagementStore is the storage backend for the views of and the reactions to the blogs.
+// The counts are updated atomically, also under concurrent load.
+// Implementations must be safe for concurrent use.
+type EngagementStore interface {
+	// AddView counts a view of a blog at t, and returns the number of views of the blog.
+	AddView(ctx context.Context, blogID primitive.ObjectID, t time.Time) (int64, error)
+	// AddReaction records the reaction of a user to a blog. It reports whether the reaction is new,
+	// the reactions that the user has already are not counted again.
+	AddReaction(ctx context.Context, item *reactionItem) (bool, error)
+	// RemoveReaction removes the reaction of a user to a blog, and reports whether there was one.
+	RemoveReaction(ctx context.Context, blogID primitive.ObjectID, userID, reaction string) (bool, error)
+	// ReadCounts returns the views and reactions of a blog, which are zero for blogs nobody saw.
+	ReadCounts(ctx context.Context, blogID primitive.ObjectID) (*countsItem, error)
+	// TopBlogs calls fn for the blogs with views or reactions from start until end, with their counts,
+	// most first, and stops at the first error. Views are counted by the hour of t in AddView.
+	TopBlogs(ctx context.Context, metric engagementMetric, start, end time.Time, fn func(blogCount) error) error
+}
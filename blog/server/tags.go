@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// normalizeTag lower cases tag and joins its words with dashes, so that
+// "Go Lang" and " go  lang" end up as the same tag "go-lang".
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+}
+
+// normalizeTags normalizes every tag, and drops empty and duplicate tags.
+func normalizeTags(tags []string) []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		res = append(res, tag)
+	}
+	return res
+}
+
+// hasTag reports whether item has the normalized tag.
+func hasTag(item *blogItem, tag string) bool {
+	for _, t := range item.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ListTags is an RPC for the Blog Service to list all tags in use, with the number of blogs for each
+func (s *server) ListTags(ctx context.Context, req *blogpb.ListTagsRequest) (*blogpb.ListTagsResponse, error) {
+	log.Println("Invoked RPC ListTags...")
+	counts, err := s.store.CountTags(ctx)
+	if err != nil {
+		return nil, storeError(err, blogs)
+	}
+
+	res := &blogpb.ListTagsResponse{}
+	for tag, n := range counts {
+		res.Tags = append(res.Tags, &blogpb.TagCount{Tag: tag, Count: n})
+	}
+	sort.Slice(res.Tags, func(i, j int) bool {
+		if res.Tags[i].Count != res.Tags[j].Count {
+			return res.Tags[i].Count > res.Tags[j].Count
+		}
+		return res.Tags[i].Tag < res.Tags[j].Tag
+	})
+	return res, nil
+}
+
+// ListBlogsByTag is an RPC for the Blog Service to list the blogs with a tag, newest first
+func (s *server) ListBlogsByTag(req *blogpb.ListBlogsByTagRequest, stream blogpb.BlogService_ListBlogsByTagServer) error {
+	log.Println("Invoked RPC ListBlogsByTag...")
+	tag := normalizeTag(req.GetTag())
+	if tag == "" {
+		return status.Errorf(codes.InvalidArgument, "Empty tag")
+	}
+	q := listQuery{Filter: blogFilter{Tag: tag}, OrderBy: orderNewestFirst}
+	onlyVisible(&q.Filter, "")
+	return s.listPage(stream.Context(), q, req.GetPageSize(), req.GetPageToken(), func(data *blogItem, nextPageToken string) error {
+		return stream.Send(&blogpb.ListBlogsByTagResponse{Blog: dataToBlogPb(data), NextPageToken: nextPageToken})
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{"Go Lang", " go  lang", "gRPC", "", "  ", "grpc"})
+	if want := []string{"go-lang", "grpc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// newTagFixture returns a fixture with more published, tagged blogs, after the one of the fixture.
+func newTagFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := newFixture(t)
+	for _, b := range []struct {
+		title string
+		tags  []string
+	}{
+		{"One", []string{"gRPC", "Go"}},
+		{"Two", []string{"grpc", " Protocol  Buffers "}},
+		{"Gone", []string{"grpc"}},
+	} {
+		blog := &blogpb.Blog{Title: b.title, Tags: b.tags, Status: blogpb.Blog_PUBLISHED}
+		created, err := f.blogs.CreateBlog(f.as("bob"), &blogpb.CreateBlogRequest{Blog: blog})
+		if err != nil {
+			t.Fatalf("CreateBlog: %v", err)
+		}
+		if b.title != "Gone" {
+			continue
+		}
+		// The tags of blogs in the trash are not counted
+		if _, err := f.blogs.DeleteBlog(f.as("bob"), &blogpb.DeleteBlogRequest{BlogId: created.GetBlog().GetId()}); err != nil {
+			t.Fatalf("DeleteBlog: %v", err)
+		}
+	}
+	return f
+}
+
+func TestListTagsCounts(t *testing.T) {
+	f := newTagFixture(t)
+	res, err := f.blogs.ListTags(context.Background(), &blogpb.ListTagsRequest{})
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	var got []string
+	for _, tag := range res.GetTags() {
+		got = append(got, tag.GetTag())
+		if want := map[string]int64{"go": 2, "grpc": 2}[tag.GetTag()]; want != 0 && tag.GetCount() != want {
+			t.Errorf("got %v blogs with tag %v, want %v", tag.GetCount(), tag.GetTag(), want)
+		}
+	}
+	// Most used first, then by name
+	if want := []string{"go", "grpc", "protocol-buffers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tags %q, want %q", got, want)
+	}
+}
+
+func TestListBlogsByTagNewestFirst(t *testing.T) {
+	f := newTagFixture(t)
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{"GRPC", []string{"Two", "One"}},
+		{"go", []string{"One", "Hello gRPC"}},
+		{"protocol buffers", []string{"Two"}},
+		{"rust", nil},
+	}
+	for _, tt := range tests {
+		stream, err := f.blogs.ListBlogsByTag(context.Background(), &blogpb.ListBlogsByTagRequest{Tag: tt.tag})
+		if err != nil {
+			t.Fatalf("ListBlogsByTag: %v", err)
+		}
+		var got []string
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("ListBlogsByTag(%q): %v", tt.tag, err)
+			}
+			got = append(got, res.GetBlog().GetTitle())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tag %q: got %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListDeletedBlogs is an RPC for the Blog Service to list the blogs of the caller in the trash, or all of them for admins
+func (s *server) ListDeletedBlogs(req *blogpb.ListDeletedBlogsRequest, stream blogpb.BlogService_ListDeletedBlogsServer) error {
+	log.Println("Invoked RPC ListDeletedBlogs...")
+	ctx := stream.Context()
+	caller := callerID(ctx)
+	if caller == "" {
+		return status.Errorf(codes.Unauthenticated, "Missing token")
+	}
+	// The trash is private, even the blogs in it that were published
+	q := listQuery{Filter: blogFilter{Trashed: true}}
+	if !isAdmin(ctx) {
+		q.Filter.AuthorID = caller
+	}
+	return s.listPage(ctx, q, req.GetPageSize(), req.GetPageToken(), func(data *blogItem, nextPageToken string) error {
+		return stream.Send(&blogpb.ListDeletedBlogsResponse{Blog: dataToBlogPb(data), NextPageToken: nextPageToken})
+	})
+}
+
+// RestoreBlog is an RPC for the Blog Service to move a blog out of the trash
+func (s *server) RestoreBlog(ctx context.Context, req *blogpb.RestoreBlogRequest) (*blogpb.RestoreBlogResponse, error) {
+	log.Println("Invoked RPC RestoreBlog...")
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Cannot parse ID: %v", err))
+	}
+
+	data, err := s.store.Read(ctx, oid)
+	if err != nil {
+		return nil, storeError(err, blogResource(req.GetBlogId()))
+	}
+	if !data.trashed() {
+		return nil, status.Errorf(codes.FailedPrecondition, fmt.Sprintf("Blog is not in the trash: %v", req.GetBlogId()))
+	}
+	data, err = s.writeBlog(ctx, data, 0, func(data *blogItem) error {
+		data.DeleteTime = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.recordRevision(ctx, data)
+
+	return &blogpb.RestoreBlogResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+// purgeTrash permanently removes the blogs that have been in the trash for longer than retention.
+// It returns the number of removed blogs.
+func (s *server) purgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	q := listQuery{Filter: blogFilter{Trashed: true, DeletedBefore: s.now().Add(-retention)}}
+	var expired []*blogItem
+	err := s.store.List(ctx, q, func(data *blogItem) error {
+		expired = append(expired, data)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, data := range expired {
+		// The version check keeps blogs that were restored in the meantime
+		err := s.store.Delete(ctx, data.ID, data.Version)
+		if err == errNotFound || err == errVersionMismatch {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+		s.events.Publish(blogpb.WatchBlogsResponse_PURGED, data, data, s.now())
+	}
+	return purged, nil
+}
+
+// runPurger calls purgeTrash every interval, until ctx is canceled.
+func (s *server) runPurger(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.purgeTrash(ctx, retention)
+			if err != nil {
+				log.Printf("Error purging trash: %v\n", err)
+			}
+			if n > 0 {
+				log.Printf("Purged %v blogs from the trash\n", n)
+			}
+		}
+	}
+}
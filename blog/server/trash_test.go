@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	f := newFixture(t)
+	srv := newServer(f.store, nil)
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+	ctx := context.Background()
+	trashedID, _ := primitive.ObjectIDFromHex(f.trashed.GetId())
+
+	// A blog that is restored before the retention ends stays
+	if _, err := f.blogs.DeleteBlog(f.as("alice"), &blogpb.DeleteBlogRequest{BlogId: f.draft.GetId()}); err != nil {
+		t.Fatalf("DeleteBlog: %v", err)
+	}
+	if _, err := f.blogs.RestoreBlog(f.as("alice"), &blogpb.RestoreBlogRequest{BlogId: f.draft.GetId()}); err != nil {
+		t.Fatalf("RestoreBlog: %v", err)
+	}
+
+	if n, err := srv.purgeTrash(ctx, time.Hour); n != 0 || err != nil {
+		t.Fatalf("purgeTrash within the retention: got %v, %v, want nothing purged", n, err)
+	}
+	now = now.Add(2 * time.Hour)
+	if n, err := srv.purgeTrash(ctx, time.Hour); n != 1 || err != nil {
+		t.Fatalf("purgeTrash after the retention: got %v, %v, want 1 blog purged", n, err)
+	}
+	if _, err := f.store.Read(ctx, trashedID); err != errNotFound {
+		t.Errorf("Read of the purged blog: got %v, want errNotFound", err)
+	}
+	if _, err := f.store.ReadRevision(ctx, trashedID, 1); err != errRevisionNotFound {
+		t.Errorf("ReadRevision of the purged blog: got %v, want errRevisionNotFound", err)
+	}
+	for _, blog := range []*blogpb.Blog{f.blog, f.draft} {
+		if _, err := f.blogs.ReadBlog(f.as("alice"), &blogpb.ReadBlogRequest{BlogId: blog.GetId()}); err != nil {
+			t.Errorf("ReadBlog of %q after the purge: %v", blog.GetTitle(), err)
+		}
+	}
+
+	f.store.err = errDown
+	if _, err := srv.purgeTrash(ctx, time.Hour); err == nil {
+		t.Errorf("purgeTrash with the store down succeeded")
+	}
+}
+
+func TestRunPurger(t *testing.T) {
+	f := newFixture(t)
+	trashedID, _ := primitive.ObjectIDFromHex(f.trashed.GetId())
+	liveID, _ := primitive.ObjectIDFromHex(f.blog.GetId())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		f.srv.runPurger(ctx, 10*time.Millisecond, 0)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := f.store.Read(context.Background(), trashedID)
+		if err == errNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the purger did not remove the trashed blog: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := f.store.Read(context.Background(), liveID); err != nil {
+		t.Errorf("Read of a live blog: %v", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the purger did not stop after its context was canceled")
+	}
+}
+
+func TestRestoreBlogUpdatesIndexAndWatchers(t *testing.T) {
+	f := newFixture(t)
+	ctx := f.as("alice")
+	if _, err := f.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{BlogId: f.blog.GetId()}); err != nil {
+		t.Fatalf("DeleteBlog: %v", err)
+	}
+	if _, err := f.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogRequest{BlogId: f.blog.GetId()}); err != nil {
+		t.Fatalf("RestoreBlog: %v", err)
+	}
+	events := watchEvents(t, f, f.srv.events.ResumeToken(blogEvent{}), 3)
+	for i, want := range []blogpb.WatchBlogsResponse_EventType{
+		blogpb.WatchBlogsResponse_CREATED, blogpb.WatchBlogsResponse_DELETED, blogpb.WatchBlogsResponse_RESTORED,
+	} {
+		if got := events[i].GetType(); got != want {
+			t.Errorf("got event %v %v, want %v", i, got, want)
+		}
+	}
+	if hits := f.srv.index.Search("streaming", 0); len(hits) != 1 || hits[0].ID.Hex() != f.blog.GetId() {
+		t.Errorf("got hits %v after the restore, want the restored blog", hits)
+	}
+
+	// A blog from before the slugs gets one when it is restored, as on any other write
+	data, err := f.store.Create(context.Background(), &blogItem{AuthorID: "alice", Title: "Legacy", DeleteTime: time.Now()})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	res, err := f.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogRequest{BlogId: data.ID.Hex()})
+	if err != nil {
+		t.Fatalf("RestoreBlog: %v", err)
+	}
+	if got := res.GetBlog().GetSlug(); got != "legacy" {
+		t.Errorf("restored blog has slug %q, want legacy", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andreasatle/grpc-go-course/blog/blogpb"
+	"github.com/andreasatle/grpc-go-course/validate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestValidation(t *testing.T) {
+	long := strings.Repeat("x", blogpb.MaxTitleLength+1)
+	tests := []struct {
+		name string
+		call func(f *fixture) error
+		// want are the fields with violations, in order
+		want []string
+	}{
+		{"create blog", func(f *fixture) error {
+			blog := &blogpb.Blog{AuthorId: "not an id", Title: " ", Content: strings.Repeat("x", blogpb.MaxContentLength+1), Tags: []string{"go", long}}
+			_, err := f.blogs.CreateBlog(f.as("alice"), &blogpb.CreateBlogRequest{Blog: blog})
+			return err
+		}, []string{"blog.author_id", "blog.title", "blog.content", "blog.tags[1]"}},
+		{"create blog without blog", func(f *fixture) error {
+			_, err := f.blogs.CreateBlog(f.as("alice"), &blogpb.CreateBlogRequest{})
+			return err
+		}, []string{"blog"}},
+		{"update blog", func(f *fixture) error {
+			_, err := f.blogs.UpdateBlog(f.as("alice"), &blogpb.UpdateBlogRequest{Blog: &blogpb.Blog{Title: long}, ExpectedVersion: -1})
+			return err
+		}, []string{"blog.title", "blog.id", "blog.author_id", "expected_version"}},
+		{"patch blog", func(f *fixture) error {
+			req := &blogpb.PatchBlogRequest{
+				Blog:       &blogpb.Blog{Id: f.blog.GetId()},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title", "views"}},
+			}
+			_, err := f.blogs.PatchBlog(f.as("alice"), req)
+			return err
+		}, []string{"blog.title", "update_mask.paths[1]"}},
+		{"get revision", func(f *fixture) error {
+			_, err := f.blogs.GetBlogRevision(context.Background(), &blogpb.GetBlogRevisionRequest{BlogId: "nope"})
+			return err
+		}, []string{"blog_id", "version"}},
+		{"list blogs", func(f *fixture) error {
+			stream, err := f.blogs.ListBlog(context.Background(), &blogpb.ListBlogRequest{PageSize: -1, AuthorId: "a/b", TitlePrefix: long})
+			return drain(stream, err, &blogpb.ListBlogResponse{})
+		}, []string{"page_size", "author_id", "title_prefix"}},
+		{"create comment", func(f *fixture) error {
+			_, err := f.comments.CreateComment(f.as("alice"), &blogpb.CreateCommentRequest{Comment: &blogpb.Comment{ParentId: "nope"}})
+			return err
+		}, []string{"comment.blog_id", "comment.parent_id", "comment.content"}},
+		{"create author", func(f *fixture) error {
+			_, err := f.authors.CreateAuthor(f.as("carol!"), &blogpb.CreateAuthorRequest{Author: &blogpb.Author{Id: "carol!", DisplayName: long}})
+			return err
+		}, []string{"author.id", "author.display_name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call(newFixture(t))
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("got %v, want InvalidArgument", err)
+			}
+			var got []string
+			for _, v := range validate.FieldViolations(err) {
+				got = append(got, v.GetField())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got violations of %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationOfStreamedBlogs(t *testing.T) {
+	f := newFixture(t)
+	stream, err := f.blogs.BulkCreateBlogs(f.as("alice"))
+	if err != nil {
+		t.Fatalf("BulkCreateBlogs: %v", err)
+	}
+	for _, title := range []string{"Valid", ""} {
+		if err := stream.Send(&blogpb.BulkCreateBlogsRequest{Blog: &blogpb.Blog{Title: title}}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if res.GetCreated() != 1 || res.GetFailed() != 1 || !strings.Contains(res.GetResults()[1].GetError(), "blog.title is required") {
+		t.Errorf("got %v, want the blog without title to fail alone", res)
+	}
+}
@@ -0,0 +1,11 @@
+package calculatorpb
+
+import "github.com/andreasatle/grpc-go-course/validate"
+
+// Validate checks the fields of the request.
+func (r *PrimeNumberRequest) Validate() error {
+	var v validate.Violations
+	// Zero and negative numbers have no decomposition into primes
+	v.Min("num", int64(r.GetNum()), 1)
+	return v.Err()
+}
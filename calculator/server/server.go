@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/andreasatle/grpc-go-course/calculator/calculatorpb"
+	"github.com/andreasatle/grpc-go-course/validate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
@@ -113,7 +114,7 @@ func main() {
 	}
 
 	// Create a new server
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.UnaryInterceptor(validate.Unary), grpc.StreamInterceptor(validate.Stream))
 
 	// Register service
 	calculatorpb.RegisterCalculatorServiceServer(s, &server{})
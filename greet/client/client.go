@@ -140,7 +140,7 @@ func doBiDiStreaming(c greetpb.GreetServiceClient, firstNames []string) {
 				break
 			}
 			if err == io.EOF {
-				log.Printf("Error receiving data from server:", err)
+				log.Printf("Error receiving data from server: %v", err)
 				break
 			}
 			fmt.Println("Received from server:", res)
@@ -0,0 +1,53 @@
+package greetpb
+
+import "github.com/andreasatle/grpc-go-course/validate"
+
+// MaxNameLength is the limit of the first and last names of a greeting.
+const MaxNameLength = 100
+
+// checkGreeting checks the names of a greeting, the first name is required.
+func checkGreeting(v *validate.Violations, g *Greeting) {
+	if g == nil {
+		v.Add("greeting", "is required")
+		return
+	}
+	if v.Required("greeting.first_name", g.GetFirstName()) {
+		v.MaxLength("greeting.first_name", g.GetFirstName(), MaxNameLength)
+	}
+	v.MaxLength("greeting.last_name", g.GetLastName(), MaxNameLength)
+}
+
+// Validate checks the fields of the request.
+func (r *GreetRequest) Validate() error {
+	var v validate.Violations
+	checkGreeting(&v, r.GetGreeting())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *GreetManyTimesRequest) Validate() error {
+	var v validate.Violations
+	checkGreeting(&v, r.GetGreeting())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *LongGreetRequest) Validate() error {
+	var v validate.Violations
+	checkGreeting(&v, r.GetGreeting())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *GreetAllRequest) Validate() error {
+	var v validate.Violations
+	checkGreeting(&v, r.GetGreeting())
+	return v.Err()
+}
+
+// Validate checks the fields of the request.
+func (r *GreetWithDeadlineRequest) Validate() error {
+	var v validate.Violations
+	checkGreeting(&v, r.GetGreeting())
+	return v.Err()
+}
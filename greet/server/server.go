@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/andreasatle/grpc-go-course/greet/greetpb"
+	"github.com/andreasatle/grpc-go-course/validate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
@@ -64,6 +65,10 @@ func (s *server) LongGreet(stream greetpb.GreetService_LongGreetServer) error {
 			return err
 		}
 
+		if err := req.Validate(); err != nil {
+			return err
+		}
+
 		// retrieve next first name.
 		firstName := req.GetGreeting().GetFirstName()
 		log.Println("Received data from client:", req)
@@ -84,6 +89,9 @@ func (s *server) GreetAll(stream greetpb.GreetService_GreetAllServer) error {
 			log.Fatalf("Error receiving data from client: %v\n", err)
 			return err
 		}
+		if err := req.Validate(); err != nil {
+			return err
+		}
 		firstName := req.GetGreeting().GetFirstName()
 		result := "Hello " + firstName + "!"
 		err = stream.Send(&greetpb.GreetAllResponse{
@@ -130,11 +138,12 @@ func main() {
 		keyFile := "ssl/server.pem"
 		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
 		if err != nil {
-			log.Fatal("Error loading certificates: %v", err)
+			log.Fatalf("Error loading certificates: %v", err)
 			return
 		}
 		opts = append(opts, grpc.Creds(creds))
 	}
+	opts = append(opts, grpc.UnaryInterceptor(validate.Unary), grpc.StreamInterceptor(validate.Stream))
 	// Create a new server
 	s := grpc.NewServer(opts...)
 
@@ -0,0 +1,139 @@
+// Package validate checks the fields of gRPC requests before they reach the handlers.
+//
+// A request type opts in by implementing Validator, usually by collecting the problems
+// of its fields in Violations. The Unary and Stream interceptors then reject invalid
+// requests with codes.InvalidArgument and a google.rpc.BadRequest detail that lists
+// every violated field, so that clients can fix all of them at once.
+package validate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Validator is a request that can check its own fields.
+type Validator interface {
+	// Validate returns nil if the request is valid, or a gRPC status error otherwise.
+	Validate() error
+}
+
+// Violations collects the invalid fields of a request.
+// The zero value is ready to use.
+type Violations struct {
+	list []*errdetails.BadRequest_FieldViolation
+}
+
+// Add records that field is invalid, with a description of the problem.
+// Fields are named by their path in the request, like "blog.title" or "blog.tags[2]".
+func (v *Violations) Add(field, format string, args ...interface{}) {
+	v.list = append(v.list, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// Required checks that value is not empty or blank.
+func (v *Violations) Required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, "is required")
+		return false
+	}
+	return true
+}
+
+// MaxLength checks that value has at most max characters.
+func (v *Violations) MaxLength(field, value string, max int) bool {
+	if n := utf8.RuneCountInString(value); n > max {
+		v.Add(field, "must be at most %d characters long, not %d", max, n)
+		return false
+	}
+	return true
+}
+
+// Match checks that value matches re. The description says what re allows, like "letters and digits".
+func (v *Violations) Match(field, value string, re *regexp.Regexp, description string) bool {
+	if !re.MatchString(value) {
+		v.Add(field, "may only contain %s", description)
+		return false
+	}
+	return true
+}
+
+// Min checks that value is at least min.
+func (v *Violations) Min(field string, value, min int64) bool {
+	if value < min {
+		v.Add(field, "must be at least %d, not %d", min, value)
+		return false
+	}
+	return true
+}
+
+// Err returns nil if there are no violations, or a codes.InvalidArgument status error
+// with a BadRequest detail listing all of them.
+func (v *Violations) Err() error {
+	if len(v.list) == 0 {
+		return nil
+	}
+	problems := make([]string, len(v.list))
+	for i, fv := range v.list {
+		problems[i] = fv.Field + " " + fv.Description
+	}
+	st := status.New(codes.InvalidArgument, "Invalid request: "+strings.Join(problems, "; "))
+	if withDetails, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v.list}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// FieldViolations returns the field violations in the BadRequest detail of err, if it has one.
+func FieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	for _, detail := range status.Convert(err).Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			return br.GetFieldViolations()
+		}
+	}
+	return nil
+}
+
+// Unary is the interceptor for unary RPCs. It validates the requests that implement Validator.
+func Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if v, ok := req.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// Stream is the interceptor for streaming RPCs. It validates the request of server streaming RPCs.
+// Client streaming RPCs are passed on as they are, their handlers validate each message themselves,
+// since one invalid message should not necessarily end the whole stream.
+func Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if info.IsClientStream {
+		return handler(srv, ss)
+	}
+	return handler(srv, &validatingStream{ServerStream: ss})
+}
+
+// validatingStream is a server stream that validates the messages it receives.
+type validatingStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if v, ok := m.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}